@@ -1,9 +1,10 @@
 package cmd
 
 import (
-	"os"
+	"errors"
 
 	"github.com/sneha-afk/trovl/internal/links"
+	"github.com/sneha-afk/trovl/internal/report"
 	"github.com/spf13/cobra"
 )
 
@@ -13,28 +14,49 @@ var addCmd = &cobra.Command{
 	Short: "Adds a symlink that points to the target file",
 	Long: `When possible, add a true symlink (as in, not a junction or hard link) to a target file.
 
-- If a symlink already exists at the specified location, the user will be prompted on if they want to overwrite it with the new link.
+- If a symlink already exists at the specified location and points to the target, it is left unchanged without prompting.
+- If a symlink already exists at the specified location but points elsewhere, the user will be prompted on if they want to overwrite it with the new link.
+  If it pointed elsewhere, the previous symlink is backed up first, and can be put back with ` + "`trovl backup restore`" + `.
+  Only symlinks trovl placed, or that point inside a dotfiles root (` + "`--dotfiles-root`" + `), are overwritten unless ` + "`--force`" + ` is given.
 - If a directory already exists at the specified location for the symlink, an error will occur.
+- Links are only placed inside the home directory (or each ` + "`--link-root`" + `) unless ` + "`--allow-outside-home`" + ` is given.
+  Protected paths, such as ` + "`~/.ssh/authorized_keys`" + `, ` + "`/etc/passwd`" + ` and the dotfiles roots themselves, are always refused.
 - If a single, ordinary file already exists at the specified location for the symlink, the user will be prompted on if they want to backup the file.
 
 When backing up a file that would be overwritten by this new symlink, trovl always uses ` + "`$XDG_CACHE_HOME`" + ` first, before
 falling back to OS defaults. The backup directory is ` + "`$XDG_CACHE_HOME/trovl/backups`" + `.
 See [trovl's use of environment variables](/trovl/configuration/#environment-variables) to learn more.
+
+By default, trovl stops at the first link that fails. With ` + "`--keep-going`" + `, every link is attempted and trovl exits
+nonzero at the end if any failed. Declining to overwrite or back up a file is not a failure.
 `,
 	Run: func(cmd *cobra.Command, args []string) {
+		beginOperation(cmd, args)
+		defer endOperation()
+
+		var errs []error
 		for i := 0; i < len(args); i += 2 {
 			target := args[i]
 			symlink := args[i+1]
 
-			if err := links.Add(State, target, symlink); err != nil {
+			a, err := links.Add(State, target, symlink)
+			outcome := a.Outcome(State, err)
+			State.Report(a.Result(outcome, err))
+			switch outcome {
+			case report.OutcomeUnchanged:
+				State.Logger.Info("Symlink unchanged", "target", target, "link", symlink)
+			case report.OutcomeFailed:
 				State.Logger.Error("Failed to create symlink (hint: try running as admin?)", "error", err)
-				os.Exit(1)
-			}
-
-			if !State.Options.DryRun {
+				errs = append(errs, err)
+				if !State.Options.KeepGoing {
+					finishLinks(err)
+				}
+			case report.OutcomeCreated:
 				State.LogSuccess("Added symlink", "target", target, "link", symlink)
 			}
 		}
+
+		finishLinks(errors.Join(errs...))
 	},
 	Args:    cobra.MinimumNArgs(2),
 	Aliases: []string{"link", "create", "new"},
@@ -43,13 +65,19 @@ See [trovl's use of environment variables](/trovl/configuration/#environment-var
 
 func init() {
 	rootCmd.AddCommand(addCmd)
+	addOutputFlag(addCmd)
+	addRootFlags(addCmd)
+	addManagedFlags(addCmd)
+	addLinkRootFlags(addCmd)
 
+	addCmd.Flags().BoolVar(&cfg.KeepGoing, "keep-going", false, "attempt every link even if some fail, exiting nonzero at the end if any did")
 	addCmd.Flags().BoolVar(&cfg.UseRelative, "relative", false, "retain relative paths to target")
 	addCmd.Flags().BoolVar(&cfg.OverwriteYes, "overwrite", false, "overwrite any existing symlinks")
 	addCmd.Flags().BoolVar(&cfg.OverwriteNo, "no-overwrite", false, "do not overwrite any existing symlinks")
 	addCmd.Flags().BoolVar(&cfg.BackupYes, "backup", false, "backup existing single files if a symlink would overwrite it")
 	addCmd.Flags().BoolVar(&cfg.BackupYes, "no-backup", false, "do not backup existing files and abandon symlink creation")
 	addCmd.Flags().StringVar(&cfg.BackupDir, "backup-dir", "", "specify where to backup files (default: $XDG_CACHE_HOME/trovl/backups)")
+	addCmd.Flags().BoolVar(&cfg.BackupCompress, "backup-compress", false, "gzip the contents of backed up files")
 
 	addCmd.MarkFlagsMutuallyExclusive("overwrite", "no-overwrite")
 	addCmd.MarkFlagsMutuallyExclusive("backup", "no-backup")
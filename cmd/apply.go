@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 
@@ -11,6 +13,98 @@ import (
 
 var defaultFile = "manifest.json"
 
+var (
+	planFile    string
+	resumeApply bool
+)
+
+// resumeFile is the name of the file in the state directory holding what an interrupted apply did not do.
+const resumeFile = "resume.json"
+
+func resumePath() (string, error) {
+	stateDir, err := utils.GetStateDir()
+	if err != nil {
+		return "", fmt.Errorf("could not get state directory: %w", err)
+	}
+	return filepath.Join(stateDir, resumeFile), nil
+}
+
+// loadPlan reads a saved plan and checks it can still be executed, exiting if not.
+func loadPlan(path string) *manifests.Plan {
+	p, err := manifests.LoadPlan(path)
+	if err != nil {
+		State.Logger.Error("Could not load plan", "error", err)
+		fail(err)
+	}
+	// paths in the plan are only meaningful inside the root it was computed for
+	p.Adopt(State.Options)
+	State.ResetFS()
+	if err := p.Verify(State); err != nil {
+		State.Logger.Error("Plan can no longer be applied, run `trovl plan` again", "error", err)
+		fail(err)
+	}
+	return p
+}
+
+// executePlan executes p. If interrupted, the actions not yet carried out are saved so they can be
+// continued with --resume; otherwise anything saved by an earlier interrupted apply is discarded.
+func executePlan(p *manifests.Plan) error {
+	err := p.Execute(State)
+	if State.Options.DryRun {
+		return err
+	}
+
+	path, pathErr := resumePath()
+	if pathErr != nil {
+		State.Logger.Warn("Could not find where to save progress", "error", pathErr)
+		return err
+	}
+	if !errors.Is(err, manifests.ErrInterrupted) {
+		if rmErr := os.Remove(path); rmErr != nil && !errors.Is(rmErr, os.ErrNotExist) {
+			State.Logger.Warn("Could not discard interrupted apply", "error", rmErr)
+		}
+		return err
+	}
+
+	if State.Journal != nil {
+		State.Journal.Interrupted = true
+	}
+	saveErr := os.MkdirAll(filepath.Dir(path), 0o755)
+	if saveErr == nil {
+		saveErr = p.Remaining().Save(path)
+	}
+	if saveErr != nil {
+		State.Logger.Warn("Could not save progress, apply again to continue", "error", saveErr)
+	} else {
+		State.Logger.Warn("Continue the interrupted apply with `trovl apply --resume`")
+	}
+	return err
+}
+
+// manifestPaths returns the manifests given as arguments, or the default manifest if there are none.
+func manifestPaths(cmd *cobra.Command, args []string) []string {
+	if len(args) > 0 {
+		return args
+	}
+
+	configDir, err := utils.GetConfigDir()
+	if err != nil {
+		State.Logger.Error("Could not read config directory", "error", err)
+	}
+	path := filepath.Join(configDir, defaultFile)
+
+	if _, err := os.Stat(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			State.Logger.Error("Manifest not found at default location", "expected_location", path)
+		} else {
+			State.Logger.Error("Error reading the default manifest", "error", err)
+		}
+		cmd.Help()
+		exit(ExitInvalidManifest)
+	}
+	return []string{path}
+}
+
 // applyCmd represents the apply command
 var applyCmd = &cobra.Command{
 	Use:   "apply <manifest_file> [more_manifests]",
@@ -23,55 +117,90 @@ By default, trovl looks for a manifest in ` + "`$XDG_CONFIG_HOME/trovl/manifest.
 See [trovl's use of environment variables](/trovl/configuration/#environment-variables) to learn more on how these are determined.
 
 Similar to the add command:
-- If a symlink already exists at the specified location, the user will be prompted on if they want to overwrite it with the new link.
+- If a symlink already exists at the specified location and points to the target, it is left unchanged without prompting.
+- If a symlink already exists at the specified location but points elsewhere, the user will be prompted on if they want to overwrite it with the new link.
+  If it pointed elsewhere, the previous symlink is backed up first, and can be put back with ` + "`trovl backup restore`" + `.
 - If a directory already exists at the specified location for the symlink, an error will occur.
 - If a single, ordinary file already exists at the specified location for the symlink, the user will be prompted on if they want to backup the file.
 
 When backing up a file that would be overwritten by this new symlink, trovl always uses ` + "`$XDG_CACHE_HOME`" + ` first, before
-falling back to OS defaults. The backup directory is ` + "`$XDG_CACHE_HOME/trovl/backups`.",
-	Run: func(cmd *cobra.Command, args []string) {
-		// Find one of the default filepaths to apply
-		if len(args) <= 0 {
-			configDir, err := utils.GetConfigDir()
-			if err != nil {
-				State.Logger.Error("Could not read config directory", "error", err)
-			}
-			path := filepath.Join(configDir, defaultFile)
+falling back to OS defaults. The backup directory is ` + "`$XDG_CACHE_HOME/trovl/backups`." + `
 
-			defaultManifest, err := manifests.New(path)
-			if err != nil {
-				if err == os.ErrNotExist {
-					State.Logger.Error("Manifest not found at default location", "expected_location", path)
-				} else {
-					State.Logger.Error("Error reading the default manifest", "error", err)
-				}
-				cmd.Help()
-				os.Exit(1)
-			}
+If interrupted (e.g. with Ctrl-C), the link being placed is finished and the rest are listed as not applied. Those
+can be applied later with ` + "`trovl apply --resume`" + `, as long as nothing they act on has changed in the meantime.
 
-			if err := defaultManifest.Apply(State); err != nil {
-				State.Logger.Error("Could not apply default manifest file", "error", err)
-				os.Exit(1)
-			}
+A plan saved with ` + "`trovl plan --out plan.json`" + ` can be executed with ` + "`trovl apply --plan plan.json`" + `. If anything the plan
+acts on has changed since it was computed, nothing is applied and the plan must be computed again.
+
+By default, applying stops at the first link that fails. With ` + "`--keep-going`" + `, every link is attempted and the
+failures are reported together at the end. Either way, a summary of how many links were created, unchanged, skipped,
+declined and failed is printed, and trovl exits nonzero only if something failed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		beginOperation(cmd, args)
+		defer endOperation()
+
+		if (planFile != "" || resumeApply) && len(args) > 0 {
+			State.Logger.Error("Manifests cannot be given together with --plan or --resume")
+			exit(ExitUsage)
 		}
 
-		for _, path := range args {
-			m, err := manifests.New(path)
+		var p *manifests.Plan
+		var errs []error
+		switch {
+		case planFile != "":
+			p = loadPlan(planFile)
+		case resumeApply:
+			path, err := resumePath()
+			if err == nil {
+				if _, err = os.Stat(path); errors.Is(err, os.ErrNotExist) {
+					State.Logger.Info("No interrupted apply to resume")
+					return
+				}
+			}
 			if err != nil {
-				State.Logger.Error("Could not read manifest file", "error", err)
-				os.Exit(1)
+				State.Logger.Error("Could not find interrupted apply", "error", err)
+				fail(err)
 			}
-
-			if err := m.Apply(State); err != nil {
-				State.Logger.Error("Could not apply manifest file", "error", err)
-				os.Exit(1)
+			p = loadPlan(path)
+		default:
+			var ms []*manifests.Manifest
+			for _, path := range manifestPaths(cmd, args) {
+				m, err := manifests.New(path)
+				if err != nil {
+					State.Logger.Error("Could not apply manifest file", "path", path, "error", err)
+					errs = append(errs, err)
+					if !State.Options.KeepGoing {
+						finishLinks(err)
+					}
+					continue
+				}
+				ms = append(ms, m)
+			}
+			manifests.ProtectRoots(State, ms...)
+			if err := manifests.CheckOverlaps(State, ms...); err != nil {
+				State.Logger.Error("Links of the manifests overlap", "error", err)
+				finishLinks(errors.Join(append(errs, err)...))
 			}
 
-			if !State.Options.DryRun {
-				State.LogSuccess("Applied manifest file", "path", path)
+			p = manifests.NewPlan()
+			for _, m := range ms {
+				if err := m.Plan(State, p); err != nil {
+					State.Logger.Error("Could not apply manifest file", "path", m.Path, "error", err)
+					errs = append(errs, err)
+					if !State.Options.KeepGoing {
+						finishLinks(err)
+					}
+				}
 			}
 		}
 
+		if err := executePlan(p); err != nil {
+			State.Logger.Error("Could not apply", "error", err)
+			errs = append(errs, err)
+		} else if !State.Options.DryRun {
+			State.LogSuccess("Applied", "actions", len(p.Actions))
+		}
+		finishLinks(errors.Join(errs...))
 	},
 	Aliases: []string{"exec", "run", "do"},
 	Example: "trovl apply .trovl",
@@ -79,13 +208,23 @@ falling back to OS defaults. The backup directory is ` + "`$XDG_CACHE_HOME/trovl
 
 func init() {
 	rootCmd.AddCommand(applyCmd)
+	addOutputFlag(applyCmd)
+	addRootFlags(applyCmd)
+	addManagedFlags(applyCmd)
+	addLinkRootFlags(applyCmd)
+	addJobsFlag(applyCmd)
 
 	applyCmd.Flags().BoolVar(&cfg.OverwriteYes, "overwrite", false, "overwrite any existing symlinks")
 	applyCmd.Flags().BoolVar(&cfg.OverwriteNo, "no-overwrite", false, "do not overwrite any existing symlinks")
 	applyCmd.Flags().BoolVar(&cfg.BackupYes, "backup", false, "backup existing single files if a symlink would overwrite it")
 	applyCmd.Flags().BoolVar(&cfg.BackupYes, "no-backup", false, "do not backup existing files and abandon symlink creation")
 	applyCmd.Flags().StringVar(&cfg.BackupDir, "backup-dir", "", "specify where to backup files (default: $XDG_CACHE_HOME/trovl/backups)")
+	applyCmd.Flags().BoolVar(&cfg.BackupCompress, "backup-compress", false, "gzip the contents of backed up files")
+	applyCmd.Flags().BoolVar(&cfg.KeepGoing, "keep-going", false, "attempt every link even if some fail, exiting nonzero at the end if any did")
+	applyCmd.Flags().StringVar(&planFile, "plan", "", "execute a plan saved by `trovl plan --out` instead of planning again")
+	applyCmd.Flags().BoolVar(&resumeApply, "resume", false, "continue an apply that was interrupted")
 
 	applyCmd.MarkFlagsMutuallyExclusive("overwrite", "no-overwrite")
 	applyCmd.MarkFlagsMutuallyExclusive("backup", "no-backup")
+	applyCmd.MarkFlagsMutuallyExclusive("plan", "resume")
 }
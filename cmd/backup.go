@@ -0,0 +1,225 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sneha-afk/trovl/internal/journal"
+	"github.com/sneha-afk/trovl/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pruneKeep      int
+	pruneOlderThan string
+	restoreTo      string
+)
+
+// getBackupDir returns the backup directory passed in by flag, otherwise the default.
+func getBackupDir() string {
+	if cfg.BackupDir != "" {
+		return cfg.BackupDir
+	}
+
+	backupDir, err := utils.GetBackupDir()
+	if err != nil {
+		State.Logger.Error("Could not read backup directory", "error", err)
+		fail(err)
+	}
+	return backupDir
+}
+
+// backupCmd represents the backup command
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Manage backups of files replaced by symlinks",
+	Long: `Manage the backups trovl makes of ordinary files before replacing them with symlinks.
+
+Backups are laid out by the path of the original file, e.g. backing up ` + "`/home/me/.bashrc`" + ` is stored at
+` + "`<backup-dir>/home/me/.bashrc_backup_<timestamp>`" + `, alongside a ` + "`.meta.json`" + ` file describing it.
+Backing up a file whose contents are identical to an existing backup of the same path reuses that backup.
+
+The default backup directory is ` + "`$XDG_CACHE_HOME/trovl/backups`" + `.
+See [trovl's use of environment variables](/trovl/configuration/#environment-variables) to learn more.`,
+}
+
+// backupListCmd represents the backup list command
+var backupListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists all backups, newest first",
+	Run: func(cmd *cobra.Command, args []string) {
+		backups, err := utils.ListBackups(getBackupDir())
+		if err != nil {
+			State.Logger.Error("Could not list backups", "error", err)
+			fail(err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TIME\tORIGINAL\tBACKUP")
+		for _, b := range backups {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", b.Time.Format(utils.FileTimeFormat), b.Original, b.Path)
+		}
+		w.Flush()
+	},
+	Aliases: []string{"ls"},
+	Args:    cobra.NoArgs,
+}
+
+// backupPruneCmd represents the backup prune command
+var backupPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Deletes old backups",
+	Long: `Deletes old backups. For each original file, the newest ` + "`--keep`" + ` backups are always retained, and of the rest,
+only those older than ` + "`--older-than`" + ` are deleted. At least one of the two must be given.
+
+Backups that ` + "`trovl undo`" + ` would restore are never deleted, until the operation that made them is undone.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if !cmd.Flags().Changed("keep") && pruneOlderThan == "" {
+			State.Logger.Error("Specify at least one of --keep or --older-than")
+			cmd.Help()
+			exit(ExitUsage)
+		}
+		if cmd.Flags().Changed("keep") && pruneKeep <= 0 {
+			State.Logger.Error("--keep must be at least 1", "keep", pruneKeep)
+			exit(ExitUsage)
+		}
+
+		var olderThan time.Duration
+		if pruneOlderThan != "" {
+			age, err := utils.ParseAge(pruneOlderThan)
+			if err != nil {
+				State.Logger.Error("Could not parse --older-than", "error", err)
+				exit(ExitUsage)
+			}
+			if age <= 0 {
+				State.Logger.Error("--older-than must be longer than zero", "older-than", pruneOlderThan)
+				exit(ExitUsage)
+			}
+			olderThan = age
+		}
+
+		acquireLock()
+		backups, err := utils.ListBackups(getBackupDir())
+		if err != nil {
+			State.Logger.Error("Could not list backups", "error", err)
+			fail(err)
+		}
+
+		referenced, err := journal.ReferencedBackups()
+		if err != nil {
+			State.Logger.Error("Could not read operation history", "error", err)
+			fail(err)
+		}
+
+		// backups an operation would restore on `trovl undo` are kept, whatever their age
+		prunable := slices.DeleteFunc(utils.SelectPrunable(backups, pruneKeep, olderThan, time.Now()), func(b utils.Backup) bool {
+			return referenced[filepath.Clean(b.Path)]
+		})
+		for _, b := range prunable {
+			State.LogBackup("Pruning backup", "backup", b.Path, "original", b.Original, "time", b.Time.Format(utils.FileTimeFormat))
+			if State.Options.DryRun {
+				continue
+			}
+			if err := utils.RemoveBackup(b); err != nil {
+				State.Logger.Error("Could not remove backup", "backup", b.Path, "error", err)
+				fail(err)
+			}
+		}
+
+		if !State.Options.DryRun {
+			State.LogSuccess("Pruned backups", "removed", len(prunable), "remaining", len(backups)-len(prunable))
+		}
+	},
+	Args:    cobra.NoArgs,
+	Example: "trovl backup prune --keep 5 --older-than 30d",
+}
+
+// backupRestoreCmd represents the backup restore command
+var backupRestoreCmd = &cobra.Command{
+	Use:   "restore <backup_or_original_path> [more_paths]",
+	Short: "Restores a backup to where it was backed up from",
+	Long: `Restores a backup to the path it was backed up from. Either the path of the backup itself, or the path of the
+original file can be given, in which case the newest backup of that file is restored.
+
+Symlinks that trovl replaced are restored as symlinks pointing to their previous target.
+
+- If a symlink exists where the backup is restored, it is replaced.
+- If an ordinary file exists there, it is only replaced with ` + "`--overwrite`" + `.
+- If a directory exists there, an error will occur.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		acquireLock()
+		backupDir := getBackupDir()
+
+		for _, path := range args {
+			path, err := State.CleanPath(path, false)
+			if err != nil {
+				State.Logger.Error("Invalid path", "path", path, "error", err)
+				fail(err)
+			}
+
+			b, err := utils.FindBackup(backupDir, path)
+			if err != nil {
+				State.Logger.Error("Could not find backup", "error", err)
+				fail(err)
+			}
+
+			dest := b.Original
+			if restoreTo != "" {
+				if dest, err = State.CleanPath(restoreTo, false); err != nil {
+					State.Logger.Error("Invalid path", "path", restoreTo, "error", err)
+					fail(err)
+				}
+			}
+
+			info, err := utils.GetPathInfo(dest)
+			if err != nil {
+				State.Logger.Error("Could not get info of restore destination", "path", dest, "error", err)
+				fail(err)
+			}
+			if info.IsDir && !info.IsSymlink {
+				State.Logger.Error("A directory exists where the backup would be restored", "path", dest)
+				exit(ExitConflict)
+			}
+			if info.Exists && !info.IsSymlink && !cfg.OverwriteYes {
+				State.Logger.Error("A file exists where the backup would be restored (hint: use --overwrite)", "path", dest)
+				exit(ExitConflict)
+			}
+
+			State.LogBackup("Restoring backup", "backup", b.Path, "destination", dest, "previous_target", b.LinkTarget)
+			if State.Options.DryRun {
+				continue
+			}
+
+			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+				State.Logger.Error("Could not create parent directories", "error", err)
+				fail(err)
+			}
+			if err := utils.RestoreBackup(b, dest); err != nil {
+				State.Logger.Error("Could not restore backup", "backup", b.Path, "error", err)
+				fail(err)
+			}
+			State.LogSuccess("Restored backup", "backup", b.Path, "destination", dest)
+		}
+	},
+	Args:    cobra.MinimumNArgs(1),
+	Example: "trovl backup restore ~/.bashrc",
+}
+
+func init() {
+	rootCmd.AddCommand(backupCmd)
+	backupCmd.AddCommand(backupListCmd)
+	backupCmd.AddCommand(backupPruneCmd)
+	backupCmd.AddCommand(backupRestoreCmd)
+
+	backupCmd.PersistentFlags().StringVar(&cfg.BackupDir, "backup-dir", "", "backup directory to manage (default: $XDG_CACHE_HOME/trovl/backups)")
+
+	backupPruneCmd.Flags().IntVar(&pruneKeep, "keep", 0, "number of newest backups to always keep per original file")
+	backupPruneCmd.Flags().StringVar(&pruneOlderThan, "older-than", "", "only delete backups older than this age (e.g. 30d, 2w, 12h)")
+
+	backupRestoreCmd.Flags().StringVar(&restoreTo, "to", "", "restore to this path instead of the original path")
+	backupRestoreCmd.Flags().BoolVar(&cfg.OverwriteYes, "overwrite", false, "overwrite an ordinary file at the restore destination")
+}
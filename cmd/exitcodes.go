@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+
+	"github.com/sneha-afk/trovl/internal/links"
+	"github.com/sneha-afk/trovl/internal/lock"
+	"github.com/sneha-afk/trovl/internal/manifests"
+)
+
+// Exit codes, so scripts can tell categories of failure apart. Documented in docs/commands.md.
+const (
+	ExitOK              = 0 // Success, including when the user declined some changes
+	ExitFailure         = 1 // Any failure not covered below
+	ExitUsage           = 2 // Invalid arguments or flags
+	ExitInvalidManifest = 3 // A manifest could not be read or does not follow the schema
+	ExitTargetMissing   = 4 // The target of a link does not exist
+	ExitConflict        = 5 // Something in the way of a link cannot be replaced, or a saved plan is stale
+	ExitPermission      = 6 // Permission denied (hint: try running as admin)
+	ExitDrift           = 7 // `status` found links that are not in their desired state
+	ExitLocked          = 8 // Another trovl process is modifying links
+
+	ExitInterrupted = 130 // Stopped by SIGINT or SIGTERM, as shells report for Ctrl-C
+)
+
+// exitCode chooses the exit code for err. When err joins several errors, the first category matched
+// in the order below is used.
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, manifests.ErrInterrupted), errors.Is(err, context.Canceled):
+		return ExitInterrupted
+	case errors.Is(err, lock.ErrLocked):
+		return ExitLocked
+	case errors.Is(err, manifests.ErrInvalidManifest), errors.Is(err, manifests.ErrOverlappingLinks):
+		return ExitInvalidManifest
+	case errors.Is(err, fs.ErrPermission):
+		return ExitPermission
+	case errors.Is(err, links.ErrTargetMissing):
+		return ExitTargetMissing
+	case errors.Is(err, links.ErrConflictDir), errors.Is(err, links.ErrPlanStale), errors.Is(err, manifests.ErrPlanMismatch),
+		errors.Is(err, links.ErrNotSymlink),
+		errors.Is(err, links.ErrNotManaged), errors.Is(err, links.ErrUnexpectedTarget),
+		errors.Is(err, links.ErrProtectedPath), errors.Is(err, links.ErrOutsideRoots):
+		return ExitConflict
+	default:
+		return ExitFailure
+	}
+}
+
+// fail exits with the exit code for err.
+func fail(err error) {
+	exit(exitCode(err))
+}
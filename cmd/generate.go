@@ -34,17 +34,17 @@ func generate(path string) {
 	data, err := json.MarshalIndent(out, "", "  ")
 	if err != nil {
 		State.Logger.Error("Could not marshal manifest", "error", err)
-		os.Exit(1)
+		fail(err)
 	}
 
 	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		State.Logger.Error("Could not create parent directory", "dir", filepath.Dir(path), "error", err)
-		os.Exit(1)
+		fail(err)
 	}
 
 	if err := os.WriteFile(path, data, 0644); err != nil {
 		State.Logger.Error("Could not write manifest file", "path", path, "error", err)
-		os.Exit(1)
+		fail(err)
 	}
 
 	State.LogSuccess("Generated default manifest file", "path", path)
@@ -60,10 +60,10 @@ var generateCmd = &cobra.Command{
 		var path string
 		if 0 < len(args) {
 			for _, arg := range args {
-				path, err := utils.CleanPath(arg, true)
+				path, err := State.CleanPath(arg, true)
 				if err != nil {
 					State.Logger.Error("Could not clean up argument path", "error", err)
-					os.Exit(1)
+					fail(err)
 				}
 				generate(path)
 			}
@@ -71,7 +71,7 @@ var generateCmd = &cobra.Command{
 			configDir, err := utils.GetConfigDir()
 			if err != nil {
 				State.Logger.Error("Could not read config directory", "error", err)
-				os.Exit(1)
+				fail(err)
 			}
 			path = filepath.Join(configDir, defaultFile)
 			generate(path)
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sneha-afk/trovl/internal/journal"
+	"github.com/sneha-afk/trovl/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Lists past operations that can be reverted with `trovl undo`",
+	Long: `Lists past operations of mutating commands (add, apply, remove), newest first, along with the number of changes
+each made. Operations that changed nothing are not recorded.
+
+Records are kept in ` + "`$XDG_STATE_HOME/trovl/history`" + `.
+See [trovl's use of environment variables](/trovl/configuration/#environment-variables) to learn more.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ops, err := journal.List()
+		if err != nil {
+			State.Logger.Error("Could not read history", "error", err)
+			fail(err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tTIME\tCOMMAND\tCHANGES\tUNDONE")
+		for _, op := range ops {
+			undone := ""
+			if op.UndoneAt != nil {
+				undone = op.UndoneAt.Format(utils.FileTimeFormat)
+			}
+			command := strings.TrimSpace(op.Command + " " + strings.Join(op.Args, " "))
+			if op.Interrupted {
+				command += " (interrupted)"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", op.ID, op.Time.Format(utils.FileTimeFormat), command, len(op.Actions), undone)
+		}
+		w.Flush()
+	},
+	Args:    cobra.NoArgs,
+	Aliases: []string{"log"},
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+}
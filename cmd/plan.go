@@ -1,23 +1,81 @@
 package cmd
 
 import (
+	"os"
+
+	"github.com/sneha-afk/trovl/internal/manifests"
 	"github.com/spf13/cobra"
 )
 
+var planOut string
+
 // planCmd represents the plan command
 var planCmd = &cobra.Command{
 	Use:   "plan <manifest_file> [more_manifests]",
 	Short: "Describes what will happen during an `apply` without modifying the filesystem",
-	Long: `Describes the actions that will happen when a manifest file is applied. This is essentially
-an alias for running ` + "`trovl apply --dry-run`",
+	Long: `Describes the actions that will happen when manifest files are applied (by default, the same default manifest
+as ` + "`trovl apply`" + `), without modifying the filesystem or prompting.
+
+Each action is listed with what it will do: creating a directory or link, replacing a symlink that points elsewhere,
+backing up an ordinary file and replacing it, rendering a template, or nothing at all. Actions marked "(will ask)"
+prompt for confirmation when applied, unless decided by the overwrite/backup flags given here. A glob target is
+listed as one action per match.
+
+With ` + "`--out`" + `, the plan is also saved as JSON, to be executed exactly as shown with ` + "`trovl apply --plan`" + `.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		State.Options.DryRun = true
-		State.SetLogLevel()
-		applyCmd.Run(cmd, args)
+		var ms []*manifests.Manifest
+		for _, path := range manifestPaths(cmd, args) {
+			m, err := manifests.New(path)
+			if err != nil {
+				State.Logger.Error("Could not read manifest file", "error", err)
+				fail(err)
+			}
+			ms = append(ms, m)
+		}
+		manifests.ProtectRoots(State, ms...)
+		if err := manifests.CheckOverlaps(State, ms...); err != nil {
+			State.Logger.Error("Links of the manifests overlap", "error", err)
+			fail(err)
+		}
+
+		p := manifests.NewPlan()
+		for _, m := range ms {
+			if err := m.Plan(State, p); err != nil {
+				State.Logger.Error("Could not plan manifest file", "path", m.Path, "error", err)
+				fail(err)
+			}
+		}
+
+		if textOutput() {
+			p.Render(os.Stdout)
+		} else {
+			p.Report(State)
+		}
+
+		if planOut != "" {
+			if err := p.Save(planOut); err != nil {
+				State.Logger.Error("Could not save plan", "error", err)
+				fail(err)
+			}
+			State.LogSuccess("Saved plan", "path", planOut)
+		}
 	},
-	Example: "trovl plan .trovl",
+	Example: "trovl plan .trovl --out plan.json",
 }
 
 func init() {
 	rootCmd.AddCommand(planCmd)
+	addOutputFlag(planCmd)
+	addRootFlags(planCmd)
+	addManagedFlags(planCmd)
+	addLinkRootFlags(planCmd)
+
+	planCmd.Flags().StringVar(&planOut, "out", "", "save the plan as JSON to this file")
+	planCmd.Flags().BoolVar(&cfg.OverwriteYes, "overwrite", false, "plan to overwrite any existing symlinks")
+	planCmd.Flags().BoolVar(&cfg.OverwriteNo, "no-overwrite", false, "plan to not overwrite any existing symlinks")
+	planCmd.Flags().BoolVar(&cfg.BackupYes, "backup", false, "plan to backup existing single files if a symlink would overwrite it")
+	planCmd.Flags().BoolVar(&cfg.BackupNo, "no-backup", false, "plan to not backup existing files and abandon symlink creation")
+
+	planCmd.MarkFlagsMutuallyExclusive("overwrite", "no-overwrite")
+	planCmd.MarkFlagsMutuallyExclusive("backup", "no-backup")
 }
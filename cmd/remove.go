@@ -1,9 +1,10 @@
 package cmd
 
 import (
-	"os"
+	"errors"
 
 	"github.com/sneha-afk/trovl/internal/links"
+	"github.com/sneha-afk/trovl/internal/report"
 	"github.com/spf13/cobra"
 )
 
@@ -12,24 +13,52 @@ var removeCmd = &cobra.Command{
 	Use:   "remove <symlink> [more_symlinks]",
 	Short: "Removes a specified symlink while keeping the target file as-is.",
 	Long: `Removes symlinks while keeping the target file untouched. Validates any argument passed
-in as truly being a symlink to prevent data loss.`,
+in as truly being a symlink to prevent data loss.
+
+Only symlinks trovl placed, or that point inside a dotfiles root (--dotfiles-root, or $TROVL_DOTFILES),
+are removed, so symlinks managed by other tools are left alone. Use --force to remove any symlink.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		beginOperation(cmd, args)
+		defer endOperation()
+
+		var errs []error
 		for _, symlink := range args {
-			if err := links.RemoveByPath(State, symlink); err != nil {
+			link, err := links.RemoveByPath(State, symlink)
+			record := report.Record{Index: -1, ID: link.LinkMount, Target: link.Target, Link: link.LinkMount, Action: "remove", Outcome: report.OutcomeRemoved}
+			if State.Options.DryRun {
+				record.Outcome = report.OutcomePlanned
+			}
+			if err != nil {
+				record.Outcome, record.Error = report.OutcomeFailed, err.Error()
+			}
+			State.Report(record)
+			if err != nil {
 				State.Logger.Error("Could not remove symlink", "error", err)
-				os.Exit(1)
+				errs = append(errs, err)
+				if !State.Options.KeepGoing {
+					finishLinks(err)
+				}
+				continue
 			}
 
 			if !State.Options.DryRun {
 				State.LogSuccess("Removed symlink", "link", symlink)
 			}
 		}
+
+		finishLinks(errors.Join(errs...))
 	},
 	Args:    cobra.MinimumNArgs(1),
 	Aliases: []string{"unlink", "delete", "rm", "del"},
-	Example: "trovl remove ~/.vimrc (where it is a symlink)",
+	Example: "trovl remove ~/.vimrc (where it is a symlink)\ntrovl remove ~/.vimrc --expect-target ~/dotfiles/vimrc",
 }
 
 func init() {
 	rootCmd.AddCommand(removeCmd)
+	addOutputFlag(removeCmd)
+	addRootFlags(removeCmd)
+	addManagedFlags(removeCmd)
+
+	removeCmd.Flags().StringVar(&cfg.ExpectTarget, "expect-target", "", "only remove symlinks that point to this path")
+	removeCmd.Flags().BoolVar(&cfg.KeepGoing, "keep-going", false, "attempt to remove every symlink even if some fail, exiting nonzero at the end if any did")
 }
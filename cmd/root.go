@@ -4,16 +4,33 @@ Package cmd is the central CobraCLI aspects of trovl.
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 
+	"github.com/sneha-afk/trovl/internal/journal"
+	"github.com/sneha-afk/trovl/internal/ledger"
+	"github.com/sneha-afk/trovl/internal/links"
+	"github.com/sneha-afk/trovl/internal/lock"
+	"github.com/sneha-afk/trovl/internal/report"
 	"github.com/sneha-afk/trovl/internal/state"
 	"github.com/spf13/cobra"
 )
 
+// DotfilesEnv lists the dotfiles roots when --dotfiles-root is not given, separated like $PATH.
+const DotfilesEnv = "TROVL_DOTFILES"
+
 var (
 	cfg   = &state.TrovlOptions{}
 	State *state.TrovlState
+
+	waitForLock bool       // Wait for another trovl process to finish, rather than exiting
+	runLock     *lock.Lock // Held while a mutating command runs
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -24,9 +41,32 @@ var rootCmd = &cobra.Command{
 It features configurable paths for files and directories that vary in location depending on the system,
 and true-symlinking when possible.
 	`,
-	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if _, err := report.ParseFormat(cfg.Output); err != nil {
+			return err
+		}
+		if cmd.Flags().Changed("jobs") && cfg.Jobs < 1 {
+			return fmt.Errorf("--jobs must be at least 1, got %d", cfg.Jobs)
+		}
+		if len(cfg.DotfilesRoots) == 0 {
+			cfg.DotfilesRoots = filepath.SplitList(os.Getenv(DotfilesEnv))
+		}
+		if err := setRoot(); err != nil {
+			return err
+		}
+		if len(cfg.LinkRoots) == 0 {
+			cfg.LinkRoots = links.DefaultLinkRoots(cfg.PathEnv())
+		}
 		State = state.New(cfg)
+		State.Context = interruptContext()
 		slog.SetDefault(State.Logger)
+		loadLedger()
+		return nil
+	},
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		saveLedger()
+		releaseLock()
+		closeReport()
 	},
 }
 
@@ -37,15 +77,210 @@ func Execute(version string) {
 
 	err := rootCmd.Execute()
 	if err != nil {
-		os.Exit(1)
+		// errors reaching here are from cobra parsing arguments and flags
+		os.Exit(ExitUsage)
 	}
 }
 
 func Root() *cobra.Command { return rootCmd }
 
+// interruptContext returns a context canceled on SIGINT or SIGTERM, so commands can finish the link
+// they are working on and stop cleanly. A second signal terminates trovl immediately, as usual.
+func interruptContext() context.Context {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+		State.Logger.Warn("Interrupted, stopping after the current link (interrupt again to stop immediately)")
+	}()
+	return ctx
+}
+
+// beginOperation takes the lock and starts recording the inverse of the changes made by a mutating
+// command, so they can be reverted with `trovl undo`. Nothing is locked or recorded during a dry-run.
+func beginOperation(cmd *cobra.Command, args []string) {
+	if State.Options.DryRun {
+		return
+	}
+	acquireLock()
+	State.Journal = journal.New(cmd.Name(), args)
+}
+
+// acquireLock takes the lock held by commands that modify links or backups, so two trovl processes
+// cannot interleave. With --wait, this waits for another process to finish rather than exiting.
+func acquireLock() {
+	if State.Options.DryRun || runLock != nil {
+		return
+	}
+	path, err := lock.Path()
+	if err != nil {
+		State.Logger.Error("Could not find lock file", "error", err)
+		exit(ExitFailure)
+	}
+
+	ctx := State.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	l, err := lock.Acquire(ctx, path, waitForLock)
+	if errors.Is(err, lock.ErrLocked) {
+		State.Logger.Error("Another trovl process is running, try again once it finishes or use --wait", "error", err, "lock", path)
+		exit(ExitLocked)
+	}
+	if err != nil {
+		State.Logger.Error("Could not take lock", "error", err)
+		exit(ExitFailure)
+	}
+	runLock = l
+
+	// another process may have placed links while this one waited for the lock
+	loadLedger()
+}
+
+// releaseLock releases the lock, if it is held.
+func releaseLock() {
+	if err := runLock.Release(); err != nil {
+		State.Logger.Warn("Could not release lock", "error", err)
+	}
+	runLock = nil
+}
+
+// loadLedger reads the record of the symlinks trovl placed. If it cannot be read, every existing
+// symlink is treated as not placed by trovl.
+func loadLedger() {
+	path, err := ledger.Path()
+	if err != nil {
+		State.Logger.Warn("Could not find record of placed symlinks", "error", err)
+		return
+	}
+	if State.Ledger, err = ledger.Load(path); err != nil {
+		State.Logger.Warn("Could not read record of placed symlinks", "error", err, "path", path)
+	}
+}
+
+// saveLedger writes the record of the symlinks trovl placed, if it changed. Nothing is written
+// during a dry-run.
+func saveLedger() {
+	if State.Options.DryRun || State.Ledger == nil {
+		return
+	}
+	path, err := ledger.Path()
+	if err == nil {
+		err = State.Ledger.Save(path)
+	}
+	if err != nil {
+		State.Logger.Warn("Could not save record of placed symlinks", "error", err)
+	}
+}
+
+// endOperation saves the record of the current operation, if it changed anything.
+func endOperation() {
+	saveLedger()
+	if State.Journal == nil {
+		return
+	}
+	if err := State.Journal.Save(); err != nil {
+		State.Logger.Warn("Could not save operation record, it cannot be undone", "error", err)
+	} else if len(State.Journal.Actions) > 0 {
+		State.Logger.Info("Recorded operation (revert with `trovl undo`)", "id", State.Journal.ID)
+	}
+	State.Journal = nil
+}
+
+// closeReport finishes writing the results of the command, for formats that only write at the end.
+func closeReport() {
+	if err := State.Reporter.Close(); err != nil {
+		State.Logger.Error("Could not write results", "error", err)
+	}
+}
+
+// addOutputFlag adds the flag choosing the format results are written to stdout in.
+func addOutputFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&cfg.Output, "output", string(report.FormatText), "format of results written to stdout: text, json or ndjson")
+}
+
+// addJobsFlag adds the flag choosing how many links are worked on at once.
+func addJobsFlag(cmd *cobra.Command) {
+	cmd.Flags().IntVarP(&cfg.Jobs, "jobs", "j", 1, "number of links to work on at once")
+}
+
+// addManagedFlags adds the flags deciding which existing symlinks may be replaced or removed.
+func addManagedFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&cfg.Force, "force", false, "replace or remove symlinks even if trovl did not place them")
+	cmd.Flags().StringSliceVar(&cfg.DotfilesRoots, "dotfiles-root", nil, "treat symlinks into this directory as placed by trovl (repeatable, default: $"+DotfilesEnv+")")
+}
+
+// addRootFlags adds the flags placing links somewhere other than the real root and home directory.
+func addRootFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&cfg.Root, "root", "", "place links inside this directory as if it were mounted as /, e.g. a container or disk image")
+	cmd.Flags().StringVar(&cfg.Home, "home", "", "home directory ~ and $HOME expand to in link and target paths (inside --root, if given)")
+}
+
+// setRoot checks the --root and --home flags and makes paths expand relative to them.
+func setRoot() error {
+	if cfg.Home != "" {
+		home, err := filepath.Abs(cfg.Home)
+		if err != nil {
+			return fmt.Errorf("invalid --home: %w", err)
+		}
+		cfg.Home = home
+	}
+	if cfg.Root == "" {
+		return nil
+	}
+	root, err := filepath.Abs(cfg.Root)
+	if err != nil {
+		return fmt.Errorf("invalid --root: %w", err)
+	}
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		return fmt.Errorf("invalid --root: %v is not a directory", root)
+	}
+	cfg.Root = root
+	return nil
+}
+
+// addLinkRootFlags adds the flags deciding where links may be placed.
+func addLinkRootFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&cfg.LinkRoots, "link-root", nil, "only place links inside this directory (repeatable, default: home directory)")
+	cmd.Flags().BoolVar(&cfg.AllowOutsideHome, "allow-outside-home", false, "place links outside the link roots (protected paths are still refused)")
+}
+
+// textOutput reports whether results are written for people rather than scripts.
+func textOutput() bool {
+	return cfg.Output == "" || cfg.Output == string(report.FormatText)
+}
+
+// finishLinks writes a summary of the results of a command acting on links, and exits with the
+// exit code for err, or ExitFailure if no error is given but a link failed.
+func finishLinks(err error) {
+	if textOutput() {
+		fmt.Fprintln(os.Stdout, "Summary: "+State.Summary.String())
+	} else {
+		State.Logger.Info("Summary: " + State.Summary.String())
+	}
+
+	if err != nil {
+		fail(err)
+	}
+	if State.Summary.Failed() {
+		exit(ExitFailure)
+	}
+}
+
+// exit saves the record of the current operation and writes the results so far before exiting, so
+// partially completed operations can still be undone and reported.
+func exit(code int) {
+	endOperation()
+	releaseLock()
+	closeReport()
+	os.Exit(code)
+}
+
 func init() {
 	State = state.DefaultState()
 	rootCmd.PersistentFlags().BoolVarP(&cfg.Verbose, "verbose", "v", false, "have verbose outputs for actions taken")
 	rootCmd.PersistentFlags().BoolVar(&cfg.Debug, "debug", false, "show debug info")
 	rootCmd.PersistentFlags().BoolVar(&cfg.DryRun, "dry-run", false, "walk through an operation without making changes")
+	rootCmd.PersistentFlags().BoolVar(&cfg.StrictVars, "strict-vars", false, "treat variables in paths that are not set as an error, rather than empty")
+	rootCmd.PersistentFlags().BoolVar(&waitForLock, "wait", false, "wait for another running trovl process to finish instead of exiting")
 }
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/sneha-afk/trovl/internal/links"
+	"github.com/sneha-afk/trovl/internal/manifests"
+	"github.com/sneha-afk/trovl/internal/report"
+	"github.com/sneha-afk/trovl/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status [manifest_file] [more_manifests]",
+	Short: "Reports the state of each link in a manifest (default: `$XDG_CONFIG_HOME/trovl/manifest.json`)",
+	Long: `Reports the state of each link in a manifest that applies to the current platform, without modifying anything.
+
+For symlinks, the status is one of:
+- linked: the symlink exists and points to the target
+- missing: nothing exists at the link path yet
+- wrong-target: a symlink exists but points elsewhere
+- conflict: an ordinary file or directory is in the way
+- target-missing: the target itself does not exist
+- loop: a symlink exists, but following it loops back on itself
+- orphaned: a symlink placed for a match of a glob target, whose match no longer exists
+
+A glob target is reported as one line per match, with the link it expands to.
+
+Symlinks are followed through any chain of symlinks they lead to, resolving relative ones against the directory
+containing them. A symlink that reaches the target through other symlinks is linked. With ` + "`--verbose`" + `, the
+chain is shown for links that go through more than one symlink.
+
+For templated links (` + "`\"method\": \"template\"`" + `), the status is one of:
+- rendered: the output matches the latest render of the template
+- outdated: the output is untouched, but the template or its vars have changed since
+- modified: the output has been edited locally since trovl last wrote it
+- untracked: a file exists at the output path that trovl did not write
+- missing: nothing exists at the output path yet
+- target-missing: the template itself does not exist
+
+trovl exits with code 7 if any link is not linked (or rendered), so scripts can detect drift. An optional link
+(` + "`\"optional\": true`" + `) whose target is missing is not counted as drift.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) <= 0 {
+			configDir, err := utils.GetConfigDir()
+			if err != nil {
+				State.Logger.Error("Could not read config directory", "error", err)
+				fail(err)
+			}
+			args = []string{filepath.Join(configDir, defaultFile)}
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		if textOutput() {
+			fmt.Fprintln(w, "INDEX\tSTATUS\tMETHOD\tLINK\tTARGET")
+		}
+
+		var drift bool
+		for _, path := range args {
+			m, err := manifests.New(path)
+			if err != nil {
+				State.Logger.Error("Could not read manifest file", "error", err)
+				fail(err)
+			}
+
+			statuses, err := m.Status(State)
+			for _, st := range statuses {
+				optionalMissing := st.Optional && st.Status == string(links.StatusTargetMissing)
+				if st.Status != string(links.StatusLinked) && st.Status != string(links.RenderClean) && !optionalMissing {
+					drift = true
+				}
+				if textOutput() {
+					fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", st.Index, st.Status, st.Method, st.Link, st.Target)
+					continue
+				}
+				State.Report(report.Record{
+					Index:    st.Index,
+					ID:       fmt.Sprintf("%v:links[%d]", path, st.Index),
+					Manifest: path,
+					Target:   st.Target,
+					Link:     st.Link,
+					Action:   "status",
+					Outcome:  report.Outcome(st.Status),
+				})
+			}
+			if err != nil {
+				w.Flush()
+				State.Logger.Error("Could not get status of manifest file", "path", path, "error", err)
+				fail(err)
+			}
+		}
+
+		w.Flush()
+		if drift {
+			exit(ExitDrift)
+		}
+	},
+	Aliases: []string{"st"},
+	Example: "trovl status .trovl",
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+	addOutputFlag(statusCmd)
+	addRootFlags(statusCmd)
+	addJobsFlag(statusCmd)
+}
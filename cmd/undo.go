@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"github.com/sneha-afk/trovl/internal/journal"
+	"github.com/sneha-afk/trovl/internal/links"
+	"github.com/spf13/cobra"
+)
+
+// undoCmd represents the undo command
+var undoCmd = &cobra.Command{
+	Use:   "undo [operation_id]",
+	Short: "Reverts the last operation, or the one specified (see `trovl history`)",
+	Long: `Reverts the changes made by an operation of a mutating command (add, apply, remove). By default,
+the newest operation that has not been undone yet is reverted.
+
+- Symlinks that were created are removed, as long as they still point to the same target.
+- Symlinks that were removed are recreated, as long as nothing else is at that path.
+- Files and symlinks that were replaced are restored from their backups.
+
+Any change that cannot be reverted safely (e.g., the file was modified since) is skipped with a warning.
+Changes that fail to be reverted (e.g., their backup was deleted) do not stop the rest, and are kept so that
+undoing the operation again retries them.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		acquireLock()
+
+		var op *journal.Operation
+		var err error
+		if len(args) > 0 {
+			op, err = journal.Load(args[0])
+		} else {
+			op, err = journal.Latest()
+		}
+		if err != nil {
+			State.Logger.Error("Could not find operation to undo", "error", err)
+			fail(err)
+		}
+
+		if op.UndoneAt != nil {
+			State.Logger.Warn("Operation was already undone", "id", op.ID, "undone_at", op.UndoneAt)
+		}
+
+		State.Logger.Info("Undoing operation", "id", op.ID, "command", op.Command, "changes", len(op.Actions))
+		failed, err := links.Undo(State, op)
+		if len(failed) > 0 {
+			// the changes that could not be reverted are kept, so undoing the operation again retries them
+			State.Logger.Error("Could not undo operation", "id", op.ID, "failed", len(failed), "error", err)
+			if !State.Options.DryRun {
+				op.Actions = failed
+				if saveErr := op.Save(); saveErr != nil {
+					State.Logger.Warn("Could not save operation record", "id", op.ID, "error", saveErr)
+				}
+			}
+			fail(err)
+		}
+
+		if State.Options.DryRun {
+			return
+		}
+		if markErr := op.MarkUndone(); markErr != nil {
+			State.Logger.Warn("Could not mark operation as undone", "id", op.ID, "error", markErr)
+		}
+
+		if err != nil {
+			State.Logger.Warn("Partially undid operation", "id", op.ID, "error", err)
+			fail(err)
+		}
+		State.LogSuccess("Undid operation", "id", op.ID)
+	},
+	Args:    cobra.MaximumNArgs(1),
+	Example: "trovl undo\ntrovl undo 20250101-120000-ab12",
+}
+
+func init() {
+	rootCmd.AddCommand(undoCmd)
+}
@@ -0,0 +1,210 @@
+/*
+Package ignore decides which files trovl leaves out when walking directories, following
+.trovlignore files written in gitignore syntax.
+*/
+package ignore
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/sneha-afk/trovl/internal/vfs"
+)
+
+// FileName is the name of the ignore files read from each directory that is walked.
+const FileName = ".trovlignore"
+
+// Defaults are ignored everywhere, unless an ignore file says otherwise.
+var Defaults = []string{".git", FileName}
+
+// ErrBadPattern is returned for an ignore pattern that cannot be parsed.
+var ErrBadPattern = errors.New("bad ignore pattern")
+
+// rule is a single line of an ignore file.
+type rule struct {
+	segs     []string // Pattern split on /, matched against a path relative to the file's directory
+	negate   bool     // !pattern: re-includes what an earlier rule ignored
+	dirOnly  bool     // pattern/: only matches directories
+	anchored bool     // Contains a / other than at the end: matched from the file's directory only
+}
+
+// parse returns the rule a line of an ignore file stands for, or false if it is blank or a comment.
+func parse(line string) (rule, bool) {
+	line = strings.TrimRight(strings.TrimSuffix(line, "\r"), " \t")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule{}, false
+	}
+
+	var r rule
+	if strings.HasPrefix(line, "!") {
+		r.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		r.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return rule{}, false
+	}
+	r.segs = strings.Split(line, "/")
+	return r, true
+}
+
+// Check returns an error if pattern is not a valid ignore pattern.
+func Check(pattern string) error {
+	r, ok := parse(pattern)
+	if !ok {
+		return nil
+	}
+	for _, seg := range r.segs {
+		if _, err := path.Match(seg, ""); err != nil {
+			return fmt.Errorf("%w: %q", ErrBadPattern, pattern)
+		}
+	}
+	return nil
+}
+
+// match reports whether the rule matches rel, a slash-separated path relative to the directory of
+// its ignore file.
+func (r rule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	segs := strings.Split(rel, "/")
+	if !r.anchored {
+		return matchSegs(r.segs, segs[len(segs)-1:])
+	}
+	return matchSegs(r.segs, segs)
+}
+
+// matchSegs matches path segments against pattern segments, where ** matches any number of them.
+func matchSegs(pattern, segs []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segs); i++ {
+				if matchSegs(pattern[1:], segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segs[0]); !ok {
+			return false
+		}
+		pattern, segs = pattern[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
+
+// Matcher decides whether paths below a root directory are ignored, by the patterns it was given
+// and the ignore files in the root and each directory below it. Ignore files are read as needed.
+// Like in git, a later rule overrides an earlier one, and rules of deeper ignore files override
+// those of shallower ones. A Matcher is not safe for concurrent use.
+type Matcher struct {
+	fsys  vfs.FS
+	root  string
+	extra []rule
+	rules map[string][]rule // Rules of the ignore file of each directory read so far
+}
+
+// New returns a Matcher for paths below root on fsys. patterns apply from root as if they were at
+// the start of its ignore file, after Defaults.
+func New(fsys vfs.FS, root string, patterns []string) *Matcher {
+	m := &Matcher{fsys: fsys, root: filepath.Clean(root), rules: map[string][]rule{}}
+	for _, p := range append(slices.Clone(Defaults), patterns...) {
+		if r, ok := parse(p); ok {
+			m.extra = append(m.extra, r)
+		}
+	}
+	return m
+}
+
+// FindRoot returns the directory ignore files are read from for a walk of dir: the nearest
+// directory containing .git (the root of the repository dir is in), or else the outermost one
+// containing an ignore file, or else dir itself.
+func FindRoot(fsys vfs.FS, dir string) string {
+	dir = filepath.Clean(dir)
+	root := dir
+	for d := dir; ; {
+		if _, err := fsys.Lstat(filepath.Join(d, ".git")); err == nil {
+			return d
+		}
+		if _, err := fsys.Lstat(filepath.Join(d, FileName)); err == nil {
+			root = d
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return root
+		}
+		d = parent
+	}
+}
+
+// fileRules returns the rules of the ignore file in dir, if any.
+func (m *Matcher) fileRules(dir string) []rule {
+	if rules, ok := m.rules[dir]; ok {
+		return rules
+	}
+	var rules []rule
+	if data, err := m.fsys.ReadFile(filepath.Join(dir, FileName)); err == nil {
+		for line := range strings.Lines(string(data)) {
+			if r, ok := parse(strings.TrimSuffix(line, "\n")); ok {
+				rules = append(rules, r)
+			}
+		}
+	}
+	m.rules[dir] = rules
+	return rules
+}
+
+// Ignored reports whether path is ignored. isDir tells whether it is a directory. Anything inside
+// an ignored directory is ignored too, and paths outside the root never are.
+func (m *Matcher) Ignored(path string, isDir bool) bool {
+	rel, err := filepath.Rel(m.root, filepath.Clean(path))
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+
+	segs := strings.Split(rel, string(filepath.Separator))
+	for i := range segs {
+		if m.ignored(segs[:i+1], isDir || i < len(segs)-1) {
+			return true
+		}
+	}
+	return false
+}
+
+// ignored reports whether the path with the segments below the root is ignored by the rules that
+// apply to it, regardless of its parents.
+func (m *Matcher) ignored(segs []string, isDir bool) bool {
+	ignored := false
+	apply := func(rules []rule, rel []string) {
+		for _, r := range rules {
+			if r.match(strings.Join(rel, "/"), isDir) {
+				ignored = !r.negate
+			}
+		}
+	}
+
+	apply(m.extra, segs)
+	dir := m.root
+	for i := range segs {
+		apply(m.fileRules(dir), segs[i:])
+		dir = filepath.Join(dir, segs[i])
+	}
+	return ignored
+}
@@ -0,0 +1,104 @@
+package ignore_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/sneha-afk/trovl/internal/ignore"
+	"github.com/sneha-afk/trovl/internal/vfs"
+)
+
+func TestMatcher(t *testing.T) {
+	root := filepath.Join(string(filepath.Separator), "dotfiles")
+	path := func(elem ...string) string { return filepath.Join(append([]string{root}, elem...)...) }
+
+	fsys := vfs.NewMemFS()
+	files := map[string]string{
+		path(".git", "HEAD"):                   "ref: refs/heads/main",
+		path(ignore.FileName):                  "# machine-specific\n*.swp\n/README.md\nlocal/\n*.bak\n!keep.bak\n",
+		path("bin", ignore.FileName):           "secret*\n",
+		path("bin", "nested", ignore.FileName): "!secret-ok\n",
+	}
+	for name, data := range files {
+		if err := fsys.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := fsys.WriteFile(name, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := ignore.New(fsys, ignore.FindRoot(fsys, path("bin")), []string{"*.tmp", "bin/**/generated"})
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{path: path(".git"), isDir: true, want: true},
+		{path: path(".git", "HEAD"), want: true},
+		{path: path(ignore.FileName), want: true},
+		{path: path("README.md"), want: true},
+		{path: path("bin", "README.md"), want: false}, // anchored to the root
+		{path: path("bin", ".vimrc.swp"), want: true},
+		{path: path("local"), isDir: true, want: true},
+		{path: path("local"), want: false}, // only directories
+		{path: path("local", "zshrc"), want: true},
+		{path: path("old.bak"), want: true},
+		{path: path("keep.bak"), want: false},
+		{path: path("bin", "secret-token"), want: true},
+		{path: path("secret-token"), want: false}, // only below bin
+		{path: path("bin", "nested", "secret-ok"), want: false},
+		{path: path("bin", "nested", "secret-no"), want: true},
+		{path: path("bin", "x.tmp"), want: true},
+		{path: path("bin", "a", "b", "generated"), want: true},
+		{path: path("bin", "tool"), want: false},
+		{path: filepath.Join(string(filepath.Separator), "elsewhere", "x.swp"), want: false},
+	}
+
+	for _, tt := range tests {
+		if got := m.Ignored(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("Ignored(%q, %v) = %v, want %v", tt.path, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestFindRoot(t *testing.T) {
+	fsys := vfs.NewMemFS()
+	repo := filepath.Join(string(filepath.Separator), "dotfiles")
+	if err := fsys.MkdirAll(filepath.Join(repo, ".git"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsys.MkdirAll(filepath.Join(repo, "bin"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := ignore.FindRoot(fsys, filepath.Join(repo, "bin")); got != repo {
+		t.Errorf("FindRoot() inside a repository = %q, want %q", got, repo)
+	}
+	other := filepath.Join(string(filepath.Separator), "other")
+	if got := ignore.FindRoot(fsys, filepath.Join(other, "bin")); got != filepath.Join(other, "bin") {
+		t.Errorf("FindRoot() outside a repository = %q, want the directory itself", got)
+	}
+	if err := fsys.MkdirAll(filepath.Join(other, "bin"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsys.WriteFile(filepath.Join(other, ignore.FileName), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := ignore.FindRoot(fsys, filepath.Join(other, "bin")); got != other {
+		t.Errorf("FindRoot() below an ignore file = %q, want %q", got, other)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	for _, pattern := range []string{"*.swp", "!keep", "/README.md", "local/", "**/x", "# comment", ""} {
+		if err := ignore.Check(pattern); err != nil {
+			t.Errorf("Check(%q) unexpected error: %v", pattern, err)
+		}
+	}
+	if err := ignore.Check("[a"); !errors.Is(err, ignore.ErrBadPattern) {
+		t.Errorf("Check(%q) error = %v, want ErrBadPattern", "[a", err)
+	}
+}
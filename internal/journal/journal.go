@@ -0,0 +1,210 @@
+/*
+Package journal records the changes made by mutating commands as lists of inverse actions, so that
+an operation can later be reverted with `trovl undo`.
+*/
+package journal
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sneha-afk/trovl/internal/utils"
+)
+
+type ActionType string
+
+// Inverse actions, i.e. what must be done to revert a change
+const (
+	RemoveLink    ActionType = "remove_link"    // A symlink was created at Path pointing to Target
+	CreateLink    ActionType = "create_link"    // A symlink at Path pointing to Target was removed
+	RestoreBackup ActionType = "restore_backup" // The file or symlink at Path was replaced after being backed up to Backup
+	RemoveFile    ActionType = "remove_file"    // A file was written at Path with contents hashing to Hash
+	RemoveDir     ActionType = "remove_dir"     // An empty directory was created at Path
+)
+
+type Action struct {
+	Type   ActionType `json:"type"`
+	Path   string     `json:"path"`
+	Target string     `json:"target,omitempty"`
+	Backup string     `json:"backup,omitempty"`
+	Hash   string     `json:"hash,omitempty"`
+}
+
+// Operation is the record of a single invocation of a mutating command.
+type Operation struct {
+	ID       string     `json:"id"`
+	Command  string     `json:"command"`
+	Args     []string   `json:"args,omitempty"`
+	Time     time.Time  `json:"time"`
+	Actions  []Action   `json:"actions"`
+	UndoneAt *time.Time `json:"undone_at,omitempty"`
+
+	// Interrupted is set if the command was stopped by a signal before it finished
+	Interrupted bool `json:"interrupted,omitempty"`
+}
+
+// historyDir is the directory under the state directory where operations are stored.
+const historyDir = "history"
+
+// ErrNoOperation is returned when there is no operation that can be undone.
+var ErrNoOperation = errors.New("no operation found")
+
+func getHistoryDir() (string, error) {
+	stateDir, err := utils.GetStateDir()
+	if err != nil {
+		return "", fmt.Errorf("could not get state directory: %w", err)
+	}
+	return filepath.Join(stateDir, historyDir), nil
+}
+
+// New starts the record of an operation for command invoked with args.
+func New(command string, args []string) *Operation {
+	suffix := make([]byte, 2)
+	_, _ = rand.Read(suffix)
+
+	now := time.Now()
+	return &Operation{
+		ID:      now.Format("20060102-150405") + "-" + hex.EncodeToString(suffix),
+		Command: command,
+		Args:    args,
+		Time:    now,
+	}
+}
+
+// Record appends an inverse action to the operation.
+func (op *Operation) Record(a Action) {
+	op.Actions = append(op.Actions, a)
+}
+
+// Save writes the operation to the history directory. Operations that changed nothing are not saved.
+func (op *Operation) Save() error {
+	if len(op.Actions) == 0 {
+		return nil
+	}
+
+	dir, err := getHistoryDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("could not create history directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(op, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, op.ID+".json")
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return fmt.Errorf("could not write operation record: %w", err)
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// List returns every recorded operation, newest first.
+func List() ([]*Operation, error) {
+	dir, err := getHistoryDir()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read history directory: %w", err)
+	}
+
+	var ops []*Operation
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		op, err := Load(strings.TrimSuffix(e.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+
+	slices.SortFunc(ops, func(a, b *Operation) int {
+		return b.Time.Compare(a.Time)
+	})
+	return ops, nil
+}
+
+// Load reads the operation with the given ID.
+func Load(id string) (*Operation, error) {
+	dir, err := getHistoryDir()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, filepath.Base(id)+".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w with id %q", ErrNoOperation, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read operation record: %w", err)
+	}
+
+	op := &Operation{}
+	if err := json.Unmarshal(data, op); err != nil {
+		return nil, fmt.Errorf("could not parse operation record %q: %w", id, err)
+	}
+	return op, nil
+}
+
+// Latest returns the newest operation that has not already been undone.
+func Latest() (*Operation, error) {
+	ops, err := List()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range ops {
+		if op.UndoneAt == nil {
+			return op, nil
+		}
+	}
+	return nil, ErrNoOperation
+}
+
+// ReferencedBackups returns the backups that operations not yet undone would restore, so they are
+// not deleted while they may still be needed.
+func ReferencedBackups() (map[string]bool, error) {
+	ops, err := List()
+	if err != nil {
+		return nil, err
+	}
+	backups := map[string]bool{}
+	for _, op := range ops {
+		if op.UndoneAt != nil {
+			continue
+		}
+		for _, a := range op.Actions {
+			if a.Type == RestoreBackup && a.Backup != "" {
+				backups[filepath.Clean(a.Backup)] = true
+			}
+		}
+	}
+	return backups, nil
+}
+
+// MarkUndone records that the operation has been reverted.
+func (op *Operation) MarkUndone() error {
+	now := time.Now()
+	op.UndoneAt = &now
+	return op.Save()
+}
@@ -0,0 +1,70 @@
+package journal_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sneha-afk/trovl/internal/journal"
+)
+
+func TestSaveListLoad(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	if _, err := journal.Latest(); !errors.Is(err, journal.ErrNoOperation) {
+		t.Fatalf("expected ErrNoOperation with no history, got %v", err)
+	}
+
+	empty := journal.New("add", nil)
+	if err := empty.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	first := journal.New("add", []string{"a", "b"})
+	first.Record(journal.Action{Type: journal.RemoveLink, Path: "/b", Target: "/a"})
+	if err := first.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	second := journal.New("remove", []string{"/b"})
+	second.Time = first.Time.Add(time.Second)
+	second.ID = first.ID + "-2"
+	second.Record(journal.Action{Type: journal.CreateLink, Path: "/b", Target: "/a"})
+	if err := second.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ops, err := journal.List()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ops) != 2 {
+		t.Fatalf("expected operations that changed nothing to not be saved, got %d operations", len(ops))
+	}
+	if ops[0].ID != second.ID {
+		t.Errorf("expected newest operation first, got %v", ops[0].ID)
+	}
+
+	loaded, err := journal.Load(first.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if loaded.Command != "add" || len(loaded.Actions) != 1 || loaded.Actions[0] != first.Actions[0] {
+		t.Errorf("loaded operation does not match saved: %+v", loaded)
+	}
+
+	if err := ops[0].MarkUndone(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	latest, err := journal.Latest()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if latest.ID != first.ID {
+		t.Errorf("expected latest to skip undone operations, got %v", latest.ID)
+	}
+
+	if _, err := journal.Load("does-not-exist"); !errors.Is(err, journal.ErrNoOperation) {
+		t.Errorf("expected ErrNoOperation for unknown id, got %v", err)
+	}
+}
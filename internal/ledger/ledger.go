@@ -0,0 +1,152 @@
+/*
+Package ledger records the symlinks trovl has placed, so that symlinks placed by something else
+(e.g. another tool's shims) are not removed or overwritten by accident.
+*/
+package ledger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/sneha-afk/trovl/internal/utils"
+)
+
+// FileName is the name of the ledger in the state directory.
+const FileName = "links.json"
+
+// Ledger maps the absolute path of each symlink trovl placed to what it was made to point to.
+// It is safe for concurrent use.
+type Ledger struct {
+	mu    sync.Mutex
+	links map[string]string
+	// changes holds the links added or removed (as nil) since the ledger was loaded, so they can
+	// be applied to the ledger another process saved in the meantime
+	changes map[string]*string
+}
+
+// New returns an empty ledger.
+func New() *Ledger {
+	return &Ledger{links: map[string]string{}, changes: map[string]*string{}}
+}
+
+// Path returns the path of the ledger in the state directory.
+func Path() (string, error) {
+	stateDir, err := utils.GetStateDir()
+	if err != nil {
+		return "", fmt.Errorf("could not get state directory: %w", err)
+	}
+	return filepath.Join(stateDir, FileName), nil
+}
+
+// Load reads the ledger from path, or returns an empty one if there is none yet.
+func Load(path string) (*Ledger, error) {
+	l := New()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return l, fmt.Errorf("could not read ledger: %w", err)
+	}
+	if err := json.Unmarshal(data, &l.links); err != nil {
+		return New(), fmt.Errorf("could not parse ledger: %w", err)
+	}
+	if l.links == nil {
+		l.links = map[string]string{}
+	}
+	return l, nil
+}
+
+// Save writes the ledger to path, if it changed since it was loaded. The changes are applied to the
+// ledger at path as it is now, so links recorded there by another process are kept.
+func (l *Ledger) Save(path string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.changes) == 0 {
+		return nil
+	}
+
+	current, err := Load(path)
+	if err != nil {
+		// a ledger that cannot be read is replaced, as it was when loaded
+		current = New()
+		maps.Copy(current.links, l.links)
+	}
+	for link, contents := range l.changes {
+		if contents == nil {
+			delete(current.links, link)
+		} else {
+			current.links[link] = *contents
+		}
+	}
+
+	data, err := json.MarshalIndent(current.links, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("could not create state directory: %w", err)
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return fmt.Errorf("could not write ledger: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("could not write ledger: %w", err)
+	}
+	l.links = current.links
+	l.changes = map[string]*string{}
+	return nil
+}
+
+// Add records that trovl placed a symlink at link with the given contents.
+func (l *Ledger) Add(link, contents string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.links[key(link)] = contents
+	l.changes[key(link)] = &contents
+}
+
+// Remove forgets the symlink at link, e.g. as it was removed.
+func (l *Ledger) Remove(link string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.links[key(link)]; ok {
+		delete(l.links, key(link))
+		l.changes[key(link)] = nil
+	}
+}
+
+// Manages reports whether the symlink at link, with the given contents, is one trovl placed and
+// has not been changed since. A nil ledger manages nothing.
+func (l *Ledger) Manages(link, contents string) bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	recorded, ok := l.links[key(link)]
+	if !ok {
+		return false
+	}
+	return recorded == contents || utils.ResolveLinkTarget(link, recorded) == utils.ResolveLinkTarget(link, contents)
+}
+
+func key(link string) string {
+	if abs, err := filepath.Abs(link); err == nil {
+		return abs
+	}
+	return filepath.Clean(link)
+}
@@ -0,0 +1,130 @@
+package ledger_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sneha-afk/trovl/internal/ledger"
+)
+
+func TestManages(t *testing.T) {
+	tmp := t.TempDir()
+	link := filepath.Join(tmp, "home", ".vimrc")
+	target := filepath.Join(tmp, "dotfiles", "vimrc")
+
+	l := ledger.New()
+	l.Add(link, target)
+
+	tests := []struct {
+		name     string
+		ledger   *ledger.Ledger
+		link     string
+		contents string
+		want     bool
+	}{
+		{name: "recorded link and contents", ledger: l, link: link, contents: target, want: true},
+		{name: "relative contents resolving to the recorded target", ledger: l, link: link, contents: filepath.Join("..", "dotfiles", "vimrc"), want: true},
+		{name: "link changed since it was placed", ledger: l, link: link, contents: filepath.Join(tmp, "shim"), want: false},
+		{name: "link not recorded", ledger: l, link: filepath.Join(tmp, "home", ".bashrc"), contents: target, want: false},
+		{name: "nil ledger", ledger: nil, link: link, contents: target, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.ledger.Manages(tt.link, tt.contents); got != tt.want {
+				t.Errorf("Manages(%v, %v) = %v, want %v", tt.link, tt.contents, got, tt.want)
+			}
+		})
+	}
+
+	l.Remove(link)
+	if l.Manages(link, target) {
+		t.Error("expected removed link to no longer be managed")
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "state", ledger.FileName)
+
+	l, err := ledger.Load(path)
+	if err != nil {
+		t.Fatalf("expected empty ledger when there is no file, got %v", err)
+	}
+	if err := l.Save(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(path); err == nil {
+		t.Error("expected an unchanged ledger to not be written")
+	}
+
+	link := filepath.Join(tmp, "link")
+	l.Add(link, "/dotfiles/a")
+	if err := l.Save(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	loaded, err := ledger.Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !loaded.Manages(link, "/dotfiles/a") {
+		t.Error("expected saved link to be managed after loading")
+	}
+
+	if err := os.WriteFile(path, []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ledger.Load(path); err == nil {
+		t.Error("expected error loading a corrupt ledger")
+	}
+}
+
+func TestSave_KeepsOtherChanges(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, ledger.FileName)
+	a, b, c := filepath.Join(tmp, "a"), filepath.Join(tmp, "b"), filepath.Join(tmp, "c")
+
+	initial := ledger.New()
+	initial.Add(a, "/dotfiles/a")
+	if err := initial.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// two processes load the same ledger, and each saves its own changes
+	first, err := ledger.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := ledger.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Add(b, "/dotfiles/b")
+	if err := first.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	second.Remove(a)
+	second.Add(c, "/dotfiles/c")
+	if err := second.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := ledger.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Manages(a, "/dotfiles/a") {
+		t.Error("expected the link removed by the second process to be forgotten")
+	}
+	if !loaded.Manages(b, "/dotfiles/b") {
+		t.Error("expected the link added by the first process to be kept")
+	}
+	if !loaded.Manages(c, "/dotfiles/c") {
+		t.Error("expected the link added by the second process to be recorded")
+	}
+	if !second.Manages(b, "/dotfiles/b") {
+		t.Error("expected the saved ledger to include links recorded by others")
+	}
+}
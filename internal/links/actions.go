@@ -6,12 +6,14 @@ package links
 import (
 	"errors"
 	"fmt"
-	"os"
+	"io/fs"
 	"path/filepath"
-	"unicode"
+	"time"
 
+	"github.com/sneha-afk/trovl/internal/journal"
 	"github.com/sneha-afk/trovl/internal/state"
 	"github.com/sneha-afk/trovl/internal/utils"
+	"github.com/sneha-afk/trovl/internal/vfs"
 )
 
 type LinkType int
@@ -28,197 +30,336 @@ type Link struct {
 }
 
 var ErrDryRun = errors.New("no-op: running dry-run")
-var ErrDeclinedOverwrite = errors.New("user declined overwriting existing file, no action taken")
-var ErrDeclinedBackup = errors.New("user declined backing up exisitng file to place new symlink, no action taken")
+var ErrUnchanged = errors.New("symlink already points to target, no action taken")
+var ErrTargetMissing = errors.New("target does not exist")
+var ErrConflictDir = errors.New("a directory exists at the link path")
+var ErrNotSymlink = errors.New("not a symlink")
 
-// Construct a Link type and validate the target file exists.
-func Construct(s *state.TrovlState, targetPath, symlinkPath string) (Link, error) {
-	targetFileInfo, err := utils.GetPathInfo(targetPath)
-	if !targetFileInfo.Exists || err != nil {
-		return Link{}, fmt.Errorf("invalid target path '%v': %v", targetPath, err)
-	}
+// ErrNotManaged is returned when removing or overwriting a symlink trovl did not place, without Force.
+var ErrNotManaged = errors.New("symlink was not placed by trovl")
+
+// ErrUnexpectedTarget is returned when removing a symlink that does not point to ExpectTarget.
+var ErrUnexpectedTarget = errors.New("symlink does not point to the expected target")
+
+// ErrDeclined matches every error returned when the user or options decline modifying an existing file.
+var ErrDeclined = errors.New("declined, no action taken")
+
+var ErrDeclinedOverwrite error = declinedError("user declined overwriting existing file, no action taken")
+var ErrDeclinedBackup error = declinedError("user declined backing up exisitng file to place new symlink, no action taken")
 
-	targetFile, err := os.Open(targetPath)
+// declinedError is a specific reason for declining, which also matches ErrDeclined.
+type declinedError string
+
+func (e declinedError) Error() string { return string(e) }
+
+func (e declinedError) Is(target error) bool { return target == ErrDeclined }
+
+// Construct a Link type and validate the target file exists. Any conflicting file at the symlink
+// path is resolved, prompting if needed, but nothing is modified: the conflicting file is only
+// replaced once the symlink is placed.
+func Construct(s *state.TrovlState, targetPath, symlinkPath string) (Link, error) {
+	a, err := PlanLink(s, targetPath, symlinkPath)
 	if err != nil {
 		return Link{}, err
 	}
 
-	var linkType LinkType
-	if targetFileInfo.IsDir {
-		linkType = LinkDirectory
-	} else {
-		linkType = LinkFile
+	link := Link{
+		Target:    a.Target,
+		LinkMount: a.Link,
+		Type:      a.Type,
 	}
 
-	targetFile.Close()
+	switch a.Kind {
+	case ActionUnchanged:
+		s.Logger.Info("Symlink already points to target, unchanged", "link", symlinkPath, "target", targetPath)
+		return link, ErrUnchanged
+	case ActionDeclined:
+		return Link{}, declinedErr(s, a)
+	}
 
-	symlinkInfo, err := utils.GetPathInfo(symlinkPath)
-	if err != nil {
-		return Link{}, fmt.Errorf("could not get symlink info: %v", err)
+	if s.Options.DryRun {
+		return link, nil
+	}
+	if err := confirm(s, a); err != nil {
+		return Link{}, err
 	}
 
-	// Conflict: existing file at the symlink position
-	if symlinkInfo.Exists {
-		s.Logger.Warn("Conflict with existing file", "link", symlinkPath, "existing_is_symlink", symlinkInfo.IsSymlink, "existing_is_dir", symlinkInfo.IsDir)
+	s.LogSuccess("Constructed symlink before operation", "target", targetPath, "link", symlinkPath)
+	return link, nil
+}
 
-		if symlinkInfo.IsSymlink {
-			if symlinkInfo.TargetPath == targetPath {
-				s.Logger.Info("Conflicting symlink already points to target", "path", symlinkPath, "target", targetPath)
-			} else {
-				s.Logger.Warn("Conflicting symlink points elsewhere", "path", symlinkPath, "current", symlinkInfo.TargetPath, "desired", targetPath)
-			}
+// declinedErr logs and returns the error for an action declined by the options.
+func declinedErr(s *state.TrovlState, a Action) error {
+	if a.Template {
+		s.Logger.Warn("Declined overwriting locally modified file, no action taken", "output", a.Link)
+		return ErrDeclinedRender
+	}
+	if a.Existing.IsSymlink {
+		s.Logger.Warn("Declined overwriting existing file, no action taken", "link", a.Link)
+		return ErrDeclinedOverwrite
+	}
+	s.Logger.Warn("Declined backing up existing file, no action taken", "link", a.Link)
+	return ErrDeclinedBackup
+}
 
-			if s.Options.DryRun {
-				return Link{}, nil
+// confirm asks the user whether to replace the existing file at the link path, if the action
+// requires it.
+func confirm(s *state.TrovlState, a Action) error {
+	switch a.Kind {
+	case ActionReplaceLink:
+		s.Logger.Warn("Conflicting symlink points elsewhere", "path", a.Link, "current", a.Existing.LinkTarget, "desired", a.Target)
+
+		shouldOverwrite := true
+		if a.Confirm {
+			var err error
+			shouldOverwrite, err = s.Confirm(state.Conflict{
+				Kind:     string(a.Kind),
+				Path:     a.Link,
+				Target:   a.Target,
+				Current:  a.Existing.LinkTarget,
+				Question: "Overwrite?",
+			})
+			if err != nil {
+				return err
 			}
+		}
+		s.Logger.Info("User's decision for overwriting", "overwrite", shouldOverwrite)
+		if !shouldOverwrite {
+			s.Logger.Warn("Declined overwriting existing file, no action taken")
+			return ErrDeclinedOverwrite
+		}
 
-			shouldOverwrite := false
-			if s.Options.OverwriteYes {
-				shouldOverwrite = true
-			} else if s.Options.OverwriteNo {
-				shouldOverwrite = false
-			} else {
-				if symlinkInfo.TargetPath == targetPath {
-					fmt.Print("Overwrite anyway? [y/N] > ")
-				} else {
-					fmt.Print("Overwrite? [y/N] > ")
-				}
-
-				var input = 'n'
-				if _, err := fmt.Scanf("%c\n", &input); err != nil {
-					return Link{}, fmt.Errorf("could not read input, no action taken: %v", err)
-				}
-				shouldOverwrite = unicode.ToLower(input) == 'y'
+	case ActionBackupReplace:
+		s.Logger.Warn("Conflicting file is an ordinary (non-link) file", "existing_path", a.Link)
+
+		shouldBackup := true
+		if a.Confirm {
+			var err error
+			shouldBackup, err = s.Confirm(state.Conflict{
+				Kind:     string(a.Kind),
+				Path:     a.Link,
+				Target:   a.Target,
+				Question: "Backup existing file before placing the symlink?",
+			})
+			if err != nil {
+				return err
 			}
+		}
+		s.Logger.Info("User's decision for backup", "backup", shouldBackup)
+		if !shouldBackup {
+			s.Logger.Warn("Declined backing up existing file, no action taken")
+			return ErrDeclinedBackup
+		}
+	}
+	return nil
+}
 
-			s.Logger.Info("User's decision for overwriting", "overwrite", shouldOverwrite)
-
-			if shouldOverwrite {
-				s.LogOverwrite("Overwriting existing file", "existing_path", symlinkPath)
-				if err := os.Remove(symlinkPath); err != nil {
-					return Link{}, fmt.Errorf("could not delete existing file: %v", err)
-				}
-			} else {
-				s.Logger.Warn("Declined overwriting existing file, no action taken")
-				return Link{}, ErrDeclinedOverwrite
-			}
-		} else {
-			if symlinkInfo.IsDir {
-				return Link{}, fmt.Errorf("existing file at conflicting symlink path is a directory, exiting")
-			}
+// backupExisting backs up whatever exists at the link path before it is replaced. Nothing is removed.
+func backupExisting(s *state.TrovlState, a Action) error {
+	switch a.Kind {
+	case ActionReplaceLink:
+		// a symlink pointing elsewhere may be managed by another tool, so keep a record of it
+		backupPath, err := utils.BackupFile(s.HostPath(a.Link), utils.BackupOptions{
+			Dir:             s.Options.BackupDir,
+			TimestampFormat: utils.FileTimeFormat,
+		})
+		if err != nil {
+			return fmt.Errorf("could not backup existing symlink: %w", err)
+		}
+		s.LogBackup("Backed up existing symlink", "backup", backupPath, "original", a.Link, "previous_target", a.Existing.LinkTarget)
+		s.Record(journal.Action{Type: journal.RestoreBackup, Path: s.HostPath(a.Link), Backup: backupPath})
+
+		s.LogOverwrite("Overwriting existing file", "existing_path", a.Link)
+
+	case ActionBackupReplace:
+		backupPath, err := utils.BackupFile(s.HostPath(a.Link), utils.BackupOptions{
+			Dir:             s.Options.BackupDir,
+			TimestampFormat: utils.FileTimeFormat,
+			Compress:        s.Options.BackupCompress,
+		})
+		if err != nil {
+			return fmt.Errorf("could not backup file: %w", err)
+		}
+		s.LogSuccess("Backed up file", "backup", backupPath, "original", a.Link)
+		s.Record(journal.Action{Type: journal.RestoreBackup, Path: s.HostPath(a.Link), Backup: backupPath})
+	}
+	return nil
+}
 
-			s.Logger.Warn("Conflicting file is an ordinary (non-link) file", "existing_path", symlinkPath)
+// tempPath returns an unused name beside path to create its replacement at before renaming it into place.
+func tempPath(path string) string {
+	return filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.tmp-%d", filepath.Base(path), time.Now().UnixNano()))
+}
 
-			if s.Options.DryRun {
-				return Link{}, nil
-			}
+// replaceWithSymlink atomically replaces whatever is at link with a symlink to target: the symlink
+// is created beside it under a temporary name and renamed over it, so the path never stops existing.
+func replaceWithSymlink(fsys vfs.FS, target, link string) error {
+	tmp := tempPath(link)
+	if err := fsys.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := fsys.Rename(tmp, link); err != nil {
+		fsys.Remove(tmp)
+		return fmt.Errorf("could not replace existing file: %w", err)
+	}
+	return nil
+}
 
-			shouldBackup := false
-			if s.Options.BackupYes {
-				shouldBackup = true
-			} else if s.Options.BackupNo {
-				shouldBackup = false
-			} else {
-				fmt.Print("Backup existing file before placing the symlink? [y/N] > ")
-
-				var input = 'n'
-				if _, err := fmt.Scanf("%c\n", &input); err != nil {
-					return Link{}, fmt.Errorf("could not read input, no action taken: %v", err)
-				}
-				shouldBackup = unicode.ToLower(input) == 'y'
-			}
+// Execute carries out a planned symlink action: resolving any conflict, creating parent
+// directories and placing the symlink. Unchanged and declined actions return ErrUnchanged and
+// ErrDeclinedOverwrite/ErrDeclinedBackup (ErrDeclinedRender for templates) respectively, and skipped actions do nothing.
+// The user is asked before anything is checked or modified, and an existing file is replaced
+// atomically. In dry-runs, the action is only simulated on the state's in-memory filesystem.
+func Execute(s *state.TrovlState, a Action) error {
+	switch a.Kind {
+	case ActionUnchanged:
+		return ErrUnchanged
+	case ActionDeclined:
+		return declinedErr(s, a)
+	case ActionSkip:
+		return nil
+	case ActionCreate, ActionReplaceLink, ActionBackupReplace:
+	default:
+		return fmt.Errorf("cannot execute %q as a symlink action", a.Kind)
+	}
 
-			s.Logger.Info("User's decision for backup", "backup", shouldBackup)
-
-			if shouldBackup {
-				backupDir := s.Options.BackupDir
-				if s.Options.BackupDir == "" {
-					cacheDir, err := utils.GetCacheDir()
-					if err != nil {
-						return Link{}, fmt.Errorf("could not get cache directory: %v", err)
-					}
-					if err := os.MkdirAll(cacheDir, 0o755); err != nil {
-						return Link{}, fmt.Errorf("could not create cache directory: %v", err)
-					}
-					backupDir = filepath.Join(cacheDir, "backups")
-				}
-
-				backupPath, err := utils.BackupFile(symlinkPath, backupDir, utils.FileTimeFormat)
-				if err != nil {
-					return Link{}, fmt.Errorf("could not backup file: %v", err)
-				}
-				s.LogSuccess("Backed up file", "backup", backupPath, "original", symlinkPath)
-
-				if err := os.Remove(symlinkPath); err != nil {
-					return Link{}, fmt.Errorf("could not delete existing file: %v", err)
-				}
-			} else {
-				s.Logger.Warn("Declined backing up existing file, no action taken")
-				return Link{}, ErrDeclinedBackup
-			}
+	s.LogLink(a.Describe())
+	if s.Options.DryRun {
+		if err := a.Verify(s.FS); err != nil {
+			return err
 		}
+		return Simulate(s.FS, a)
 	}
 
-	s.LogSuccess("Constructed symlink before operation", "target", targetPath, "link", symlinkPath)
+	if a.Confirm {
+		// only ask about what was planned
+		if err := a.Verify(s.FS); err != nil {
+			return err
+		}
+	}
+	if err := confirm(s, a); err != nil {
+		return err
+	}
+	if err := backupExisting(s, a); err != nil {
+		return err
+	}
+	// check right before acting, as the path may have changed while the user was asked
+	if err := a.Verify(s.FS); err != nil {
+		return err
+	}
 
-	return Link{
-		Target:    targetPath,
-		LinkMount: symlinkPath,
-		Type:      linkType,
-	}, nil
+	if a.Kind == ActionCreate {
+		// parent directories usually exist already, so only create them once the symlink cannot be
+		err := s.FS.Symlink(a.Target, a.Link)
+		if errors.Is(err, fs.ErrNotExist) {
+			if err := s.FS.MkdirAll(filepath.Dir(a.Link), 0755); err != nil {
+				return fmt.Errorf("failed to create parent directories: %w", err)
+			}
+			err = s.FS.Symlink(a.Target, a.Link)
+		}
+		if err != nil {
+			return err
+		}
+	} else if err := replaceWithSymlink(s.FS, a.Target, a.Link); err != nil {
+		return err
+	}
+	s.Ledger.Add(s.HostPath(a.Link), a.Target)
+	s.Record(journal.Action{Type: journal.RemoveLink, Path: s.HostPath(a.Link), Target: a.Target})
+	return nil
 }
 
-// Add a symlink specified by the Link class.
-// Precondition: there is no existing file where the symlink was specified
-func Add(s *state.TrovlState, targetPath, symlinkPath string) error {
-	targetPath, err := utils.CleanPath(targetPath, s.Options.UseRelative)
+// Add a symlink at symlinkPath pointing to targetPath, resolving any conflict with an existing file.
+// The action taken is returned for reporting, along with any error from taking it.
+func Add(s *state.TrovlState, targetPath, symlinkPath string) (Action, error) {
+	failed := Action{Kind: ActionCreate, Index: -1, Target: targetPath, Link: symlinkPath}
+
+	targetPath, err := s.CleanPath(targetPath, s.Options.UseRelative)
 	if err != nil {
-		return fmt.Errorf("invalid path (target): %v", err)
+		return failed, fmt.Errorf("invalid path (target): %w", err)
 	}
-	symlinkPath, err = utils.CleanPath(symlinkPath, s.Options.UseRelative)
+	symlinkPath, err = s.CleanPath(symlinkPath, s.Options.UseRelative)
 	if err != nil {
-		return fmt.Errorf("invalid path (symlink): %v", err)
+		return failed, fmt.Errorf("invalid path (symlink): %w", err)
 	}
 
-	link, err := Construct(s, targetPath, symlinkPath)
-	if err != nil && err != ErrDryRun {
-		if errors.Is(err, ErrDeclinedOverwrite) || errors.Is(err, ErrDeclinedBackup) {
-			return err
-		}
-		return fmt.Errorf("failed to construct link: %v", err)
+	a, err := PlanLink(s, targetPath, symlinkPath)
+	if err != nil {
+		return failed, fmt.Errorf("failed to construct link: %w", err)
 	}
+	return a, Execute(s, a)
+}
 
-	if s.Options.DryRun {
+// checkManaged returns ErrNotManaged unless the symlink at link with the given contents was placed by
+// trovl (per the ledger), points inside one of the dotfiles roots, or Force is set.
+func checkManaged(s *state.TrovlState, link, contents string) error {
+	if s.Options.Force || s.Ledger.Manages(s.HostPath(link), contents) {
 		return nil
 	}
-	if err := os.MkdirAll(filepath.Dir(link.LinkMount), 0755); err != nil {
-		return fmt.Errorf("failed to create parent directories: %w", err)
+	resolved := utils.ResolveLinkTarget(link, contents)
+	for _, root := range s.Options.DotfilesRoots {
+		if root, err := s.CleanPath(root, false); err == nil && utils.IsWithin(resolved, root) {
+			return nil
+		}
 	}
-	return os.Symlink(link.Target, link.LinkMount)
+	return fmt.Errorf("%w: %v -> %v (use --force if it should be replaced or removed anyway)", ErrNotManaged, link, contents)
 }
 
 // RemoveByPath takes in the path to a symlink to remove, while keeping the original
 // file intact (note: target file is not checked for existence as the symlink is being removed.)
-func RemoveByPath(s *state.TrovlState, path string) error {
-	path, err := utils.CleanPath(path, true)
+// The removed link is returned for reporting.
+func RemoveByPath(s *state.TrovlState, path string) (Link, error) {
+	link := Link{LinkMount: path}
+	path, err := s.CleanPath(path, true)
 	if err != nil {
-		return fmt.Errorf("invalid path (symlink): %v", err)
+		return link, fmt.Errorf("invalid path (symlink): %w", err)
 	}
-	info, err := utils.GetPathInfo(path)
+	link.LinkMount = path
+
+	info, err := utils.GetPathInfoFS(s.FS, path)
 	if err != nil {
-		return fmt.Errorf("could not get symlink info: %v", err)
+		return link, fmt.Errorf("could not get symlink info: %w", err)
 	}
 
 	if !info.Exists {
-		return fmt.Errorf("no symlink exists at %v", path)
+		return link, fmt.Errorf("no symlink exists at %v: %w", path, fs.ErrNotExist)
 	}
 
 	if !info.IsSymlink {
-		return fmt.Errorf("invalid symlink: %v", err)
+		return link, fmt.Errorf("invalid symlink %v: %w", path, ErrNotSymlink)
+	}
+	link.Target = info.TargetPath
+
+	if s.Options.ExpectTarget != "" {
+		expected, err := s.CleanPath(s.Options.ExpectTarget, false)
+		if err != nil {
+			return link, fmt.Errorf("invalid path (expected target): %w", err)
+		}
+		if !utils.SameLinkTargetFS(s.FS, path, info.TargetPath, expected) {
+			return link, fmt.Errorf("%w: %v points to %v, not %v", ErrUnexpectedTarget, path, info.TargetPath, expected)
+		}
+	}
+	if err := checkManaged(s, path, info.TargetPath); err != nil {
+		return link, err
+	}
+
+	// removing a broken link is fine, but say what it was so removing the wrong one is noticed
+	chain, err := utils.ResolveChainFS(s.FS, path)
+	switch {
+	case err != nil:
+		s.Logger.Warn("Removing a symlink that cannot be followed", "link", path, "error", err)
+	case !chain.Exists:
+		s.Logger.Warn("Removing a dangling symlink", "link", path, "chain", chain.String())
+	default:
+		s.Logger.Info("Removing symlink", "link", path, "chain", chain.String())
 	}
 
 	if s.Options.DryRun {
-		return nil
+		return link, s.FS.Remove(path)
+	}
+	if err := s.FS.Remove(path); err != nil {
+		return link, err
 	}
-	return os.Remove(path)
+	s.Ledger.Remove(s.HostPath(path))
+	s.Record(journal.Action{Type: journal.CreateLink, Path: s.HostPath(path), Target: info.TargetPath})
+	return link, nil
 }
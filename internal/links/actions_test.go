@@ -1,13 +1,18 @@
 package links_test
 
 import (
+	"errors"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/sneha-afk/trovl/internal/ledger"
 	"github.com/sneha-afk/trovl/internal/links"
 	"github.com/sneha-afk/trovl/internal/state"
 	"github.com/sneha-afk/trovl/internal/utils"
+	"github.com/sneha-afk/trovl/internal/vfs"
 )
 
 func TestMain(m *testing.M) {
@@ -19,6 +24,7 @@ func TestAdd(t *testing.T) {
 	tests := []struct {
 		name       string
 		wantErr    bool
+		errIs      error // If set, the error must match this
 		options    *state.TrovlOptions
 		targetPath string
 		linkPath   string
@@ -28,6 +34,7 @@ func TestAdd(t *testing.T) {
 		{
 			name:    "error: target does not exist",
 			wantErr: true,
+			errIs:   links.ErrTargetMissing,
 		},
 		{
 			name: "success: brand new symlink",
@@ -60,15 +67,84 @@ func TestAdd(t *testing.T) {
 			},
 		},
 		{
-			name:    "error: existing symlink, overwrite no",
+			name: "success: existing symlink elsewhere, overwrite yes backs up previous link",
+			options: &state.TrovlOptions{
+				OverwriteYes: true,
+				Force:        true,
+			},
+			setup: func(tmp, targetPath, linkPath string) {
+				_ = os.WriteFile(targetPath, []byte("target"), 0644)
+				_ = os.Symlink(filepath.Join(tmp, "managed_elsewhere"), linkPath)
+			},
+			validate: func(t *testing.T, tmp, targetPath, linkPath string) {
+				backupDir, err := utils.GetBackupDir()
+				if err != nil {
+					t.Fatalf("could not get backup directory: %v", err)
+				}
+				b, err := utils.FindBackup(backupDir, linkPath)
+				if err != nil {
+					t.Fatalf("expected previous symlink to be backed up: %v", err)
+				}
+				if b.LinkTarget != filepath.Join(tmp, "managed_elsewhere") {
+					t.Errorf("expected backup to record previous target, got %q", b.LinkTarget)
+				}
+				if got, err := os.Readlink(b.Path); err != nil || got != b.LinkTarget {
+					t.Errorf("expected backup to be a symlink to the previous target, got %q (%v)", got, err)
+				}
+			},
+		},
+		{
+			name:    "error: existing symlink elsewhere, overwrite no",
 			wantErr: true,
+			errIs:   links.ErrDeclined,
 			options: &state.TrovlOptions{
 				OverwriteNo: true,
 			},
+			setup: func(tmp, targetPath, linkPath string) {
+				_ = os.WriteFile(targetPath, []byte("target"), 0644)
+				_ = os.Symlink(filepath.Join(tmp, "elsewhere"), linkPath)
+			},
+		},
+		{
+			name:    "error: existing symlink placed by another tool is not overwritten",
+			wantErr: true,
+			errIs:   links.ErrNotManaged,
+			options: &state.TrovlOptions{
+				OverwriteYes: true,
+			},
+			setup: func(tmp, targetPath, linkPath string) {
+				_ = os.WriteFile(targetPath, []byte("target"), 0644)
+				_ = os.Symlink(filepath.Join(tmp, "shim"), linkPath)
+			},
+			validate: func(t *testing.T, tmp, targetPath, linkPath string) {
+				if got, err := os.Readlink(linkPath); err != nil || got != filepath.Join(tmp, "shim") {
+					t.Fatalf("expected symlink to be left as-is, got %q (%v)", got, err)
+				}
+			},
+		},
+		{
+			name: "success: existing symlink already points to target, unchanged without prompting",
 			setup: func(tmp, targetPath, linkPath string) {
 				_ = os.WriteFile(targetPath, []byte("target"), 0644)
 				_ = os.Symlink(targetPath, linkPath)
 			},
+			validate: func(t *testing.T, tmp, targetPath, linkPath string) {
+				if got, err := os.Readlink(linkPath); err != nil || got != targetPath {
+					t.Fatalf("expected symlink to be left as-is, got %q (%v)", got, err)
+				}
+			},
+		},
+		{
+			name: "success: existing relative symlink resolving to target, unchanged",
+			setup: func(tmp, targetPath, linkPath string) {
+				_ = os.WriteFile(targetPath, []byte("target"), 0644)
+				_ = os.Symlink(filepath.Base(targetPath), linkPath)
+			},
+			validate: func(t *testing.T, tmp, targetPath, linkPath string) {
+				if got, err := os.Readlink(linkPath); err != nil || got != filepath.Base(targetPath) {
+					t.Fatalf("expected relative symlink to be left as-is, got %q (%v)", got, err)
+				}
+			},
 		},
 		{
 			name: "success: ordinary file exists, backup yes",
@@ -88,6 +164,7 @@ func TestAdd(t *testing.T) {
 		{
 			name:    "error: ordinary file exists, backup no",
 			wantErr: true,
+			errIs:   links.ErrDeclined,
 			options: &state.TrovlOptions{
 				BackupNo: true,
 			},
@@ -99,6 +176,7 @@ func TestAdd(t *testing.T) {
 		{
 			name:    "error: directory exists at symlink path",
 			wantErr: true,
+			errIs:   links.ErrConflictDir,
 			setup: func(tmp, targetPath, linkPath string) {
 				_ = os.WriteFile(targetPath, []byte("target"), 0644)
 				_ = os.Mkdir(linkPath, 0755)
@@ -167,15 +245,25 @@ func TestAdd(t *testing.T) {
 				st.Options = tt.options
 			}
 
+			// an unchanged link is a successful no-op
 			_, err := links.Construct(st, tt.targetPath, tt.linkPath)
+			if errors.Is(err, links.ErrUnchanged) {
+				err = nil
+			}
 			if (err != nil) != tt.wantErr {
 				t.Fatalf("Construct: wantErr=%v, got %v", tt.wantErr, err)
 			}
 
-			err = links.Add(st, tt.targetPath, tt.linkPath)
+			_, err = links.Add(st, tt.targetPath, tt.linkPath)
+			if errors.Is(err, links.ErrUnchanged) {
+				err = nil
+			}
 			if (err != nil) != tt.wantErr {
 				t.Fatalf("Add: wantErr=%v, got %v", tt.wantErr, err)
 			}
+			if tt.errIs != nil && !errors.Is(err, tt.errIs) {
+				t.Fatalf("Add: expected error matching %v, got %v", tt.errIs, err)
+			}
 
 			if tt.validate != nil {
 				tt.validate(t, tmp, tt.targetPath, tt.linkPath)
@@ -251,25 +339,73 @@ func TestValidateSymlink(t *testing.T) {
 }
 
 func TestRemoveByPath(t *testing.T) {
-	teststate := state.DefaultState()
-
 	tests := []struct {
 		name         string
+		options      func(tmp string) *state.TrovlOptions
+		managed      bool // The link is recorded in the ledger
 		targetExists bool
 		createLink   bool
+		createFile   bool
 		expectErr    bool
+		errIs        error
 	}{
 		{
-			name:         "success: remove existing symlink",
+			name:         "success: remove symlink trovl placed",
+			managed:      true,
+			targetExists: true,
+			createLink:   true,
+		},
+		{
+			name:         "success: remove unmanaged symlink with force",
+			options:      func(string) *state.TrovlOptions { return &state.TrovlOptions{Force: true} },
+			targetExists: true,
+			createLink:   true,
+		},
+		{
+			name:         "success: remove symlink into a dotfiles root",
+			options:      func(tmp string) *state.TrovlOptions { return &state.TrovlOptions{DotfilesRoots: []string{tmp}} },
+			targetExists: true,
+			createLink:   true,
+		},
+		{
+			name:         "error: symlink was not placed by trovl",
+			targetExists: true,
+			createLink:   true,
+			expectErr:    true,
+			errIs:        links.ErrNotManaged,
+		},
+		{
+			name: "success: symlink points to the expected target",
+			options: func(tmp string) *state.TrovlOptions {
+				return &state.TrovlOptions{ExpectTarget: filepath.Join(tmp, "target.txt")}
+			},
+			managed:      true,
+			targetExists: true,
+			createLink:   true,
+		},
+		{
+			name: "error: symlink points somewhere other than expected",
+			options: func(tmp string) *state.TrovlOptions {
+				return &state.TrovlOptions{ExpectTarget: filepath.Join(tmp, "other.txt"), Force: true}
+			},
 			targetExists: true,
 			createLink:   true,
-			expectErr:    false,
+			expectErr:    true,
+			errIs:        links.ErrUnexpectedTarget,
 		},
 		{
 			name:         "error: symlink does not exist",
 			targetExists: false,
 			createLink:   false,
 			expectErr:    true,
+			errIs:        fs.ErrNotExist,
+		},
+		{
+			name:         "error: ordinary file is not a symlink",
+			targetExists: true,
+			createFile:   true,
+			expectErr:    true,
+			errIs:        links.ErrNotSymlink,
 		},
 	}
 
@@ -279,6 +415,13 @@ func TestRemoveByPath(t *testing.T) {
 			targetPath := filepath.Join(tmp, "target.txt")
 			linkPath := filepath.Join(tmp, "link.txt")
 
+			opts := &state.TrovlOptions{}
+			if tc.options != nil {
+				opts = tc.options(tmp)
+			}
+			teststate := state.New(opts)
+			teststate.Ledger = ledger.New()
+
 			if tc.targetExists {
 				os.WriteFile(targetPath, []byte("target"), 0644)
 			}
@@ -288,18 +431,211 @@ func TestRemoveByPath(t *testing.T) {
 					t.Errorf("error during link setup: %v", err)
 				}
 			}
+			if tc.managed {
+				teststate.Ledger.Add(linkPath, targetPath)
+			}
+			if tc.createFile {
+				os.WriteFile(linkPath, []byte("ordinary"), 0644)
+			}
 
-			err := links.RemoveByPath(teststate, linkPath)
+			_, err := links.RemoveByPath(teststate, linkPath)
 
 			if (err != nil) != tc.expectErr {
 				t.Errorf("expected error: %v, got: %v", tc.expectErr, err)
 			}
+			if tc.errIs != nil && !errors.Is(err, tc.errIs) {
+				t.Errorf("expected error matching %v, got: %v", tc.errIs, err)
+			}
 
+			_, statErr := os.Lstat(linkPath)
 			if !tc.expectErr {
-				if _, err := os.Lstat(linkPath); err == nil {
+				if statErr == nil {
 					t.Error("expected symlink to be removed, but it still exists")
 				}
+				if teststate.Ledger.Manages(linkPath, targetPath) {
+					t.Error("expected removed symlink to be forgotten by the ledger")
+				}
+			} else if tc.createLink && statErr != nil {
+				t.Error("expected symlink to be kept, but it was removed")
 			}
 		})
 	}
 }
+
+func TestAdd_MemFS(t *testing.T) {
+	root := string(filepath.Separator)
+	target := filepath.Join(root, "dotfiles", "vimrc")
+	link := filepath.Join(root, "home", "user", ".vimrc")
+
+	fsys := vfs.NewMemFS()
+	if err := fsys.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsys.WriteFile(target, []byte("set nocompatible"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	st := state.New(&state.TrovlOptions{})
+	st.FS = fsys
+
+	a, err := links.Add(st, target, link)
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if a.Kind != links.ActionCreate {
+		t.Errorf("Add() action = %v, want %v", a.Kind, links.ActionCreate)
+	}
+	if got, _, err := links.GetLinkStatus(st, target, link); err != nil || got != links.StatusLinked {
+		t.Errorf("GetLinkStatus() = %v, %v, want %v", got, err, links.StatusLinked)
+	}
+
+	if _, err := links.Add(st, target, link); !errors.Is(err, links.ErrUnchanged) {
+		t.Errorf("Add() again error = %v, want ErrUnchanged", err)
+	}
+	if _, err := os.Lstat(link); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Add() on an in-memory filesystem modified the real one")
+	}
+}
+
+func TestExecute_Declined(t *testing.T) {
+	tests := []struct {
+		name   string
+		action links.Action
+		want   error
+	}{
+		{name: "symlink", action: links.Action{Kind: links.ActionDeclined, Existing: links.Snapshot{IsSymlink: true}}, want: links.ErrDeclinedOverwrite},
+		{name: "ordinary file", action: links.Action{Kind: links.ActionDeclined}, want: links.ErrDeclinedBackup},
+		{name: "rendered template", action: links.Action{Kind: links.ActionDeclined, Template: true}, want: links.ErrDeclinedRender},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := links.Execute(state.DefaultState(), tt.action)
+			if !errors.Is(err, tt.want) || !errors.Is(err, links.ErrDeclined) {
+				t.Errorf("Execute() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecute_Replace(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", tmp)
+
+	target := filepath.Join(tmp, "target")
+	link := filepath.Join(tmp, "link")
+	if err := os.WriteFile(target, []byte("target"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("replaces atomically", func(t *testing.T) {
+		if err := os.WriteFile(link, []byte("ordinary"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		st := state.New(&state.TrovlOptions{BackupYes: true})
+		a, err := links.PlanLink(st, target, link)
+		if err != nil {
+			t.Fatalf("PlanLink() error = %v", err)
+		}
+		if err := links.Execute(st, a); err != nil {
+			t.Fatalf("Execute() error = %v", err)
+		}
+		if got, err := os.Readlink(link); err != nil || got != target {
+			t.Errorf("Readlink() = %q, %v, want %q", got, err, target)
+		}
+		entries, _ := os.ReadDir(tmp)
+		for _, e := range entries {
+			if strings.Contains(e.Name(), ".tmp-") {
+				t.Errorf("temporary file %v was left behind", e.Name())
+			}
+		}
+	})
+
+	t.Run("changed while asking", func(t *testing.T) {
+		if err := os.Remove(link); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join(tmp, "elsewhere"), link); err != nil {
+			t.Fatal(err)
+		}
+		st := state.New(&state.TrovlOptions{Force: true})
+		st.Resolver = state.ResolverFunc(func(state.Conflict) (bool, error) {
+			// another program replaces the symlink before the user answers
+			if err := os.Remove(link); err != nil {
+				return false, err
+			}
+			return true, os.WriteFile(link, []byte("new"), 0644)
+		})
+		a, err := links.PlanLink(st, target, link)
+		if err != nil {
+			t.Fatalf("PlanLink() error = %v", err)
+		}
+		if err := links.Execute(st, a); !errors.Is(err, links.ErrPlanStale) {
+			t.Fatalf("Execute() error = %v, want ErrPlanStale", err)
+		}
+		if data, err := os.ReadFile(link); err != nil || string(data) != "new" {
+			t.Errorf("file written while asking = %q, %v, want it left alone", data, err)
+		}
+	})
+}
+
+func TestLinkChains(t *testing.T) {
+	tmp := t.TempDir()
+	path := func(name string) string { return filepath.Join(tmp, name) }
+	symlink := func(target, link string) {
+		t.Helper()
+		if err := os.Symlink(target, path(link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	target := path("target")
+	if err := os.WriteFile(target, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	symlink("target", "intermediate")
+	symlink("intermediate", "chained") // resolves to the target through another link
+	symlink("loop-b", "loop-a")
+	symlink("loop-a", "loop-b")
+	symlink("missing", "dangling")
+
+	st := state.New(&state.TrovlOptions{OverwriteYes: true, Force: true})
+
+	t.Run("status follows chains", func(t *testing.T) {
+		status, chain, err := links.GetLinkStatus(st, target, path("chained"))
+		if err != nil || status != links.StatusLinked {
+			t.Fatalf("GetLinkStatus() = %v, %v, want %v", status, err, links.StatusLinked)
+		}
+		if len(chain.Hops) != 2 || chain.Final != target {
+			t.Errorf("expected the chain to go through both links to the target, got %v", chain)
+		}
+	})
+
+	t.Run("status of a loop", func(t *testing.T) {
+		if status, _, err := links.GetLinkStatus(st, target, path("loop-a")); err != nil || status != links.StatusLoop {
+			t.Errorf("GetLinkStatus() = %v, %v, want %v", status, err, links.StatusLoop)
+		}
+	})
+
+	t.Run("chain to the target is unchanged", func(t *testing.T) {
+		if a, err := links.PlanLink(st, target, path("chained")); err != nil || a.Kind != links.ActionUnchanged {
+			t.Errorf("PlanLink() = %v, %v, want %v", a.Kind, err, links.ActionUnchanged)
+		}
+	})
+
+	t.Run("looping link is replaced", func(t *testing.T) {
+		a, err := links.PlanLink(st, target, path("loop-a"))
+		if err != nil || a.Kind != links.ActionReplaceLink || !strings.Contains(a.Describe(), "loops") {
+			t.Errorf("PlanLink() = %v (%v), %v, want %v of a loop", a.Kind, a.Describe(), err, links.ActionReplaceLink)
+		}
+	})
+
+	t.Run("target resolving nowhere", func(t *testing.T) {
+		if _, err := links.PlanLink(st, path("dangling"), path("new")); !errors.Is(err, links.ErrTargetMissing) {
+			t.Errorf("expected ErrTargetMissing for a dangling target, got %v", err)
+		}
+		if _, err := links.PlanLink(st, path("loop-a"), path("new")); !errors.Is(err, utils.ErrLinkLoop) {
+			t.Errorf("expected ErrLinkLoop for a looping target, got %v", err)
+		}
+	})
+}
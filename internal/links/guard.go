@@ -0,0 +1,104 @@
+package links
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/sneha-afk/trovl/internal/state"
+	"github.com/sneha-afk/trovl/internal/utils"
+)
+
+// ErrProtectedPath is returned when a link would be placed at a path trovl never modifies, e.g.
+// ~/.ssh/authorized_keys or inside the dotfiles repo itself.
+var ErrProtectedPath = errors.New("link path is protected")
+
+// ErrOutsideRoots is returned when a link would be placed outside every allowed link root, without
+// AllowOutsideHome.
+var ErrOutsideRoots = errors.New("link path is outside the allowed link roots")
+
+// protectedPaths returns the paths no link is placed at or inside. Paths starting with ~ are
+// relative to the home directory.
+func protectedPaths() []string {
+	return []string{
+		"/etc/passwd",
+		"/etc/shadow",
+		"/etc/group",
+		"/etc/sudoers",
+		filepath.Join("~", ".ssh", "authorized_keys"),
+		filepath.Join("~", ".gnupg"),
+	}
+}
+
+// DefaultLinkRoots returns the directories links are placed in when none are configured: the home
+// directory paths are expanded against.
+func DefaultLinkRoots(env utils.PathEnv) []string {
+	home, err := env.HomeDir()
+	if err != nil {
+		return nil
+	}
+	return []string{home}
+}
+
+// CheckLinkPath returns an error if a link may not be placed at path: ErrProtectedPath if it is, or
+// is inside, a protected path, a dotfiles root or a manifest root, and ErrOutsideRoots if LinkRoots are set, path is
+// not inside any of them, and AllowOutsideHome is not set. path should be clean. Symlinks in its
+// parent directories are followed on the state's filesystem, so paths are compared where the link
+// would actually be placed.
+func CheckLinkPath(s *state.TrovlState, path string) error {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	shown := path
+	if resolved := resolveParent(s, path); resolved != path {
+		shown = fmt.Sprintf("%v (through symlinks, %v)", path, resolved)
+		path = resolved
+	}
+
+	for _, protected := range protectedPaths() {
+		if protected, err := s.CleanPath(protected, false); err == nil && utils.IsWithin(path, resolveParent(s, protected)) {
+			return fmt.Errorf("%w: %v", ErrProtectedPath, protected)
+		}
+	}
+	for _, root := range s.Options.DotfilesRoots {
+		if root, err := s.CleanPath(root, false); err == nil && utils.IsWithin(path, resolve(s, root)) {
+			return fmt.Errorf("%w: %v is inside the dotfiles root %v", ErrProtectedPath, shown, root)
+		}
+	}
+	for _, root := range s.Options.ManifestRoots {
+		if root, err := s.CleanPath(root, false); err == nil && utils.IsWithin(path, resolve(s, root)) {
+			return fmt.Errorf("%w: %v is inside %v, which holds a manifest", ErrProtectedPath, shown, root)
+		}
+	}
+
+	if s.Options.AllowOutsideHome || len(s.Options.LinkRoots) == 0 {
+		return nil
+	}
+	var roots []string
+	for _, root := range s.Options.LinkRoots {
+		root, err := s.CleanPath(root, false)
+		if err != nil {
+			continue
+		}
+		if utils.IsWithin(path, resolve(s, root)) {
+			return nil
+		}
+		roots = append(roots, root)
+	}
+	return fmt.Errorf("%w: %v is not inside %v (use --allow-outside-home to place it anyway)", ErrOutsideRoots, shown, roots)
+}
+
+// resolve returns path with every symlink along it followed on the state's filesystem, or path
+// itself if it cannot be resolved.
+func resolve(s *state.TrovlState, path string) string {
+	if resolved, err := utils.EvalSymlinksFS(s.FS, path); err == nil {
+		return resolved
+	}
+	return path
+}
+
+// resolveParent is resolve for the parent directory of path, leaving path itself as it is, e.g.
+// when it is where a link goes, or a protected symlink.
+func resolveParent(s *state.TrovlState, path string) string {
+	return filepath.Join(resolve(s, filepath.Dir(path)), filepath.Base(path))
+}
@@ -0,0 +1,152 @@
+package links_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sneha-afk/trovl/internal/links"
+	"github.com/sneha-afk/trovl/internal/state"
+)
+
+func TestCheckLinkPath(t *testing.T) {
+	tmp := t.TempDir()
+	home := filepath.Join(tmp, "home")
+	dotfiles := filepath.Join(home, "dotfiles")
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	// parents that are symlinks lead elsewhere than their path suggests
+	if err := os.MkdirAll(home, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{
+		"cfg":    filepath.Join(tmp, "elsewhere"),
+		"dots":   dotfiles,
+		".ssh":   filepath.Join(tmp, "keys"),
+		".local": home,
+	} {
+		if err := os.MkdirAll(target, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(target, filepath.Join(home, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		options state.TrovlOptions
+		path    string
+		errIs   error
+	}{
+		{
+			name:    "inside home",
+			options: state.TrovlOptions{LinkRoots: []string{home}},
+			path:    filepath.Join(home, ".vimrc"),
+		},
+		{
+			name:    "outside home",
+			options: state.TrovlOptions{LinkRoots: []string{home}},
+			path:    filepath.Join(tmp, "elsewhere", ".vimrc"),
+			errIs:   links.ErrOutsideRoots,
+		},
+		{
+			name:    "outside home, allowed",
+			options: state.TrovlOptions{LinkRoots: []string{home}, AllowOutsideHome: true},
+			path:    filepath.Join(tmp, "elsewhere", ".vimrc"),
+		},
+		{
+			name:    "inside another link root",
+			options: state.TrovlOptions{LinkRoots: []string{home, filepath.Join(tmp, "elsewhere")}},
+			path:    filepath.Join(tmp, "elsewhere", ".vimrc"),
+		},
+		{
+			name: "anywhere without link roots",
+			path: filepath.Join(tmp, "elsewhere", ".vimrc"),
+		},
+		{
+			name:    "authorized keys are protected",
+			options: state.TrovlOptions{LinkRoots: []string{home}},
+			path:    filepath.Join(home, ".ssh", "authorized_keys"),
+			errIs:   links.ErrProtectedPath,
+		},
+		{
+			name:    "protected even outside home when allowed",
+			options: state.TrovlOptions{AllowOutsideHome: true},
+			path:    "/etc/passwd",
+			errIs:   links.ErrProtectedPath,
+		},
+		{
+			name:    "inside the dotfiles repo",
+			options: state.TrovlOptions{LinkRoots: []string{home}, DotfilesRoots: []string{dotfiles}},
+			path:    filepath.Join(dotfiles, "vimrc"),
+			errIs:   links.ErrProtectedPath,
+		},
+		{
+			name:    "outside home through a symlinked parent",
+			options: state.TrovlOptions{LinkRoots: []string{home}},
+			path:    filepath.Join(home, "cfg", ".vimrc"),
+			errIs:   links.ErrOutsideRoots,
+		},
+		{
+			name:    "inside home through a symlinked parent",
+			options: state.TrovlOptions{LinkRoots: []string{home}},
+			path:    filepath.Join(home, ".local", ".vimrc"),
+		},
+		{
+			name:    "inside the dotfiles repo through a symlinked parent",
+			options: state.TrovlOptions{LinkRoots: []string{home}, DotfilesRoots: []string{dotfiles}},
+			path:    filepath.Join(home, "dots", "vimrc"),
+			errIs:   links.ErrProtectedPath,
+		},
+		{
+			name:    "authorized keys through a symlinked parent",
+			options: state.TrovlOptions{LinkRoots: []string{home}, AllowOutsideHome: true},
+			path:    filepath.Join(tmp, "keys", "authorized_keys"),
+			errIs:   links.ErrProtectedPath,
+		},
+		{
+			name:    "inside the directory holding a manifest",
+			options: state.TrovlOptions{LinkRoots: []string{home}, ManifestRoots: []string{dotfiles}},
+			path:    filepath.Join(home, "dots", "vimrc"),
+			errIs:   links.ErrProtectedPath,
+		},
+		{
+			name:    "the dotfiles repo itself",
+			options: state.TrovlOptions{LinkRoots: []string{home}, DotfilesRoots: []string{dotfiles}},
+			path:    dotfiles,
+			errIs:   links.ErrProtectedPath,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := links.CheckLinkPath(state.New(&tt.options), tt.path)
+			if tt.errIs == nil && err != nil {
+				t.Errorf("CheckLinkPath(%v) unexpected error: %v", tt.path, err)
+			}
+			if tt.errIs != nil && !errors.Is(err, tt.errIs) {
+				t.Errorf("CheckLinkPath(%v) error = %v, want %v", tt.path, err, tt.errIs)
+			}
+		})
+	}
+}
+
+func TestAdd_OutsideRoots(t *testing.T) {
+	tmp := t.TempDir()
+	target := filepath.Join(tmp, "target.txt")
+	link := filepath.Join(tmp, "elsewhere", "link.txt")
+	if err := os.WriteFile(target, []byte("target"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	st := state.New(&state.TrovlOptions{LinkRoots: []string{filepath.Join(tmp, "home")}})
+	if _, err := links.Add(st, target, link); !errors.Is(err, links.ErrOutsideRoots) {
+		t.Fatalf("Add() error = %v, want ErrOutsideRoots", err)
+	}
+	if _, err := os.Lstat(link); err == nil {
+		t.Error("expected no link to be placed outside the link roots")
+	}
+}
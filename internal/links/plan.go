@@ -0,0 +1,326 @@
+package links
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/sneha-afk/trovl/internal/journal"
+	"github.com/sneha-afk/trovl/internal/report"
+	"github.com/sneha-afk/trovl/internal/state"
+	"github.com/sneha-afk/trovl/internal/utils"
+	"github.com/sneha-afk/trovl/internal/vfs"
+)
+
+type ActionKind string
+
+const (
+	ActionMkdir         ActionKind = "mkdir"          // Create the missing parent directories of Link
+	ActionCreateTarget  ActionKind = "create_target"  // Create the missing target at Link as an empty file or directory
+	ActionCreate        ActionKind = "create"         // Create a new symlink where nothing exists
+	ActionReplaceLink   ActionKind = "replace_link"   // Back up a symlink that points elsewhere and replace it
+	ActionBackupReplace ActionKind = "backup_replace" // Back up an ordinary file and replace it with a symlink
+	ActionRender        ActionKind = "render"         // Render a template to Link
+	ActionUnchanged     ActionKind = "unchanged"      // Link is already as it should be
+	ActionDeclined      ActionKind = "declined"       // A conflict exists and the options say to leave it be
+	ActionSkip          ActionKind = "skip"           // Nothing to do, see Reason
+)
+
+// ErrPlanStale is returned when executing an action whose link path has changed since it was planned.
+var ErrPlanStale = errors.New("filesystem has changed since the plan was computed")
+
+// Snapshot is the state of a path at the time an action was planned, used to detect if it changed
+// before the action is executed.
+type Snapshot struct {
+	Exists     bool   `json:"exists"`
+	IsDir      bool   `json:"is_dir,omitempty"`
+	IsSymlink  bool   `json:"is_symlink,omitempty"`
+	LinkTarget string `json:"link_target,omitempty"`
+	Size       int64  `json:"size,omitempty"`
+	ModTime    int64  `json:"mod_time,omitempty"`  // Unix nanoseconds
+	Simulated  bool   `json:"simulated,omitempty"` // The path is as an earlier action of the plan would leave it
+}
+
+// TakeSnapshot records the state of the file or symlink at path on fsys, without following symlinks.
+func TakeSnapshot(fsys vfs.FS, path string) (Snapshot, error) {
+	info, err := fsys.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Snapshot{Simulated: simulated(fsys, path)}, nil
+	}
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	snap := Snapshot{
+		Exists:    true,
+		IsDir:     info.IsDir(),
+		IsSymlink: info.Mode()&fs.ModeSymlink != 0,
+		Size:      info.Size(),
+		ModTime:   info.ModTime().UnixNano(),
+		Simulated: simulated(fsys, path),
+	}
+	if snap.IsSymlink {
+		if snap.LinkTarget, err = fsys.Readlink(path); err != nil {
+			return Snapshot{}, err
+		}
+	}
+	if snap.IsDir || snap.IsSymlink {
+		// directory sizes and times change with their contents, and a symlink is only what it points to
+		snap.Size, snap.ModTime = 0, 0
+	}
+	return snap, nil
+}
+
+// simulated reports whether path on fsys reflects changes that have only been simulated.
+func simulated(fsys vfs.FS, path string) bool {
+	c, ok := fsys.(vfs.Changer)
+	return ok && c.Changed(path)
+}
+
+// Action is a single planned step of placing a link. Actions are computed without modifying
+// anything or prompting, and can be serialized to be executed later.
+type Action struct {
+	Kind     ActionKind        `json:"kind"`
+	Manifest string            `json:"manifest,omitempty"` // Manifest the action came from, if any
+	Index    int               `json:"index"`              // Index of the link in its manifest, -1 if none
+	Target   string            `json:"target,omitempty"`
+	Link     string            `json:"link"`
+	Type     LinkType          `json:"link_type"`
+	Confirm  bool              `json:"confirm,omitempty"`  // The user is prompted before the action is taken
+	Reason   string            `json:"reason,omitempty"`   // Why the action is being taken, skipped or declined
+	Vars     map[string]string `json:"vars,omitempty"`     // Template variables, for render actions
+	Template bool              `json:"template,omitempty"` // Link is rendered from a template, rather than a symlink
+	Existing Snapshot          `json:"existing"`           // What was at Link when planned
+}
+
+// Verify checks that the link path on fsys is in the same state as when the action was planned.
+func (a Action) Verify(fsys vfs.FS) error {
+	if a.Kind == ActionMkdir || a.Kind == ActionSkip {
+		return nil
+	}
+	curr, err := TakeSnapshot(fsys, a.Link)
+	if err != nil {
+		return fmt.Errorf("could not get info of %v: %w", a.Link, err)
+	}
+
+	want := a.Existing
+	if want.Simulated {
+		// the contents an earlier action writes cannot be known until it has been carried out
+		curr.Size, curr.ModTime, want.Size, want.ModTime = 0, 0, 0, 0
+	}
+	curr.Simulated, want.Simulated = false, false
+	if curr != want {
+		return fmt.Errorf("%w: %v", ErrPlanStale, a.Link)
+	}
+	return nil
+}
+
+// Describe returns a short human-readable description of the action.
+func (a Action) Describe() string {
+	switch a.Kind {
+	case ActionMkdir:
+		return fmt.Sprintf("create directory %v", a.Link)
+	case ActionCreateTarget:
+		if a.Type == LinkDirectory {
+			return fmt.Sprintf("create empty target directory %v", a.Link)
+		}
+		return fmt.Sprintf("create empty target file %v", a.Link)
+	case ActionCreate:
+		return fmt.Sprintf("link %v -> %v", a.Link, a.Target)
+	case ActionReplaceLink:
+		if a.Reason != "" {
+			return fmt.Sprintf("replace symlink %v -> %v (currently -> %v %v, backed up first)", a.Link, a.Target, a.Existing.LinkTarget, a.Reason)
+		}
+		return fmt.Sprintf("replace symlink %v -> %v (currently -> %v, backed up first)", a.Link, a.Target, a.Existing.LinkTarget)
+	case ActionBackupReplace:
+		return fmt.Sprintf("back up file %v and link it -> %v", a.Link, a.Target)
+	case ActionRender:
+		return fmt.Sprintf("render %v from %v (%v)", a.Link, a.Target, a.Reason)
+	case ActionUnchanged:
+		return fmt.Sprintf("%v already -> %v", a.Link, a.Target)
+	default:
+		return fmt.Sprintf("%v: %v", a.Link, a.Reason)
+	}
+}
+
+// Preparatory reports whether the action only prepares for the action on the link after it, e.g.
+// creating its parent directory, and is reported through that action.
+func (a Action) Preparatory() bool {
+	return a.Kind == ActionMkdir || a.Kind == ActionCreateTarget
+}
+
+// ID identifies the link the action is for: its manifest and index, or its path if it has no manifest.
+func (a Action) ID() string {
+	if a.Index < 0 {
+		return a.Link
+	}
+	if a.Manifest == "" {
+		return fmt.Sprintf("links[%d]", a.Index)
+	}
+	return fmt.Sprintf("%v:links[%d]", a.Manifest, a.Index)
+}
+
+// Result returns the record reporting the given outcome of the action. err is only included if
+// the action failed.
+func (a Action) Result(outcome report.Outcome, err error) report.Record {
+	r := report.Record{
+		Index:    a.Index,
+		ID:       a.ID(),
+		Manifest: a.Manifest,
+		Target:   a.Target,
+		Link:     a.Link,
+		Action:   string(a.Kind),
+		Outcome:  outcome,
+	}
+	if err != nil && outcome == report.OutcomeFailed {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// Outcome classifies the result of executing the action, where err is what Execute returned.
+func (a Action) Outcome(s *state.TrovlState, err error) report.Outcome {
+	switch {
+	case errors.Is(err, ErrUnchanged), a.Kind == ActionUnchanged:
+		return report.OutcomeUnchanged
+	case errors.Is(err, ErrDeclined):
+		return report.OutcomeDeclined
+	case err != nil:
+		return report.OutcomeFailed
+	case a.Kind == ActionSkip:
+		return report.OutcomeSkipped
+	case s.Options.DryRun:
+		return report.OutcomePlanned
+	default:
+		return report.OutcomeCreated
+	}
+}
+
+// PlanLink decides what placing a symlink at symlinkPath pointing to targetPath involves, based on
+// what currently exists there and the overwrite/backup options. Nothing is modified and the user is
+// not prompted; actions the user must confirm are marked with Confirm.
+// Paths are expected to have been cleaned already.
+func PlanLink(s *state.TrovlState, targetPath, symlinkPath string) (Action, error) {
+	if err := CheckLinkPath(s, symlinkPath); err != nil {
+		return Action{}, err
+	}
+
+	// a target that is itself a symlink is followed to what it ultimately leads to
+	targetChain, err := utils.ResolveChainFS(s.FS, targetPath)
+	if err != nil {
+		return Action{}, fmt.Errorf("invalid target path '%v': %w", targetPath, err)
+	}
+	if !targetChain.Exists {
+		if targetChain.Links() {
+			return Action{}, fmt.Errorf("invalid target path '%v' (resolves to %v): %w", targetPath, targetChain.Final, ErrTargetMissing)
+		}
+		return Action{}, fmt.Errorf("invalid target path '%v': %w", targetPath, ErrTargetMissing)
+	}
+
+	existing, err := TakeSnapshot(s.FS, symlinkPath)
+	if err != nil {
+		return Action{}, fmt.Errorf("could not get symlink info: %w", err)
+	}
+
+	a := Action{
+		Index:    -1,
+		Target:   targetPath,
+		Link:     symlinkPath,
+		Type:     LinkFile,
+		Existing: existing,
+	}
+	if targetChain.IsDir {
+		a.Type = LinkDirectory
+	}
+
+	switch {
+	case !existing.Exists:
+		a.Kind = ActionCreate
+	case existing.IsSymlink && utils.SameLinkTargetFS(s.FS, symlinkPath, existing.LinkTarget, targetPath):
+		a.Kind = ActionUnchanged
+	case existing.IsSymlink:
+		if s.Options.OverwriteNo {
+			a.Kind = ActionDeclined
+			a.Reason = "symlink points elsewhere, not overwriting"
+		} else {
+			// a symlink an earlier action of the plan would place is managed by definition
+			if !existing.Simulated {
+				if err := checkManaged(s, symlinkPath, existing.LinkTarget); err != nil {
+					return Action{}, err
+				}
+			}
+			a.Kind = ActionReplaceLink
+			a.Confirm = !s.Options.OverwriteYes
+			if _, err := utils.ResolveChainFS(s.FS, symlinkPath); errors.Is(err, utils.ErrLinkLoop) {
+				a.Reason = "which loops back on itself"
+			}
+		}
+	case existing.IsDir:
+		return Action{}, fmt.Errorf("existing file at conflicting symlink path is a directory, exiting: %w", ErrConflictDir)
+	default:
+		if s.Options.BackupNo {
+			a.Kind = ActionDeclined
+			a.Reason = "ordinary file exists, not backing up"
+		} else {
+			a.Kind = ActionBackupReplace
+			a.Confirm = !s.Options.BackupYes
+		}
+	}
+
+	return a, nil
+}
+
+// Simulate applies the effect the action would have to fsys, which should be an in-memory overlay.
+// Nothing is backed up, recorded or confirmed, and rendered files are written empty.
+func Simulate(fsys vfs.FS, a Action) error {
+	switch a.Kind {
+	case ActionMkdir:
+		return fsys.MkdirAll(a.Link, 0755)
+	case ActionCreateTarget:
+		return CreateTarget(fsys, a)
+	case ActionCreate:
+		if err := fsys.MkdirAll(filepath.Dir(a.Link), 0755); err != nil {
+			return fmt.Errorf("failed to create parent directories: %w", err)
+		}
+		return fsys.Symlink(a.Target, a.Link)
+	case ActionReplaceLink, ActionBackupReplace:
+		return replaceWithSymlink(fsys, a.Target, a.Link)
+	case ActionRender:
+		if err := fsys.MkdirAll(filepath.Dir(a.Link), 0755); err != nil {
+			return fmt.Errorf("failed to create parent directories: %w", err)
+		}
+		return writeFileAtomic(fsys, a.Link, nil, 0644)
+	default:
+		return nil
+	}
+}
+
+// CreateTarget creates the empty file or directory a create_target action is for on fsys, along
+// with its missing parent directories.
+func CreateTarget(fsys vfs.FS, a Action) error {
+	if a.Type == LinkDirectory {
+		return fsys.MkdirAll(a.Link, 0755)
+	}
+	if err := fsys.MkdirAll(filepath.Dir(a.Link), 0755); err != nil {
+		return fmt.Errorf("failed to create parent directories: %w", err)
+	}
+	return fsys.WriteFile(a.Link, nil, 0644)
+}
+
+// PlaceTarget creates the target of a create_target action, recording its removal so that undo
+// takes it away again as long as it is still empty.
+func PlaceTarget(s *state.TrovlState, a Action) error {
+	if err := CreateTarget(s.FS, a); err != nil {
+		return err
+	}
+	if a.Type == LinkDirectory {
+		s.Record(journal.Action{Type: journal.RemoveDir, Path: s.HostPath(a.Link)})
+	} else {
+		sum := sha256.Sum256(nil)
+		s.Record(journal.Action{Type: journal.RemoveFile, Path: s.HostPath(a.Link), Hash: hex.EncodeToString(sum[:])})
+	}
+	return nil
+}
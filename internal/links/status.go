@@ -0,0 +1,69 @@
+package links
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sneha-afk/trovl/internal/state"
+	"github.com/sneha-afk/trovl/internal/utils"
+)
+
+// LinkStatus describes the state of a symlink relative to what it should point to.
+type LinkStatus string
+
+const (
+	StatusLinked        LinkStatus = "linked"         // Symlink exists and points to the target
+	StatusMissing       LinkStatus = "missing"        // Nothing exists at the symlink path
+	StatusWrongTarget   LinkStatus = "wrong-target"   // Symlink exists but points elsewhere
+	StatusConflict      LinkStatus = "conflict"       // A non-symlink file or directory is in the way
+	StatusTargetMissing LinkStatus = "target-missing" // The target itself does not exist
+	StatusLoop          LinkStatus = "loop"           // Symlink exists but following it loops back on itself
+	StatusOrphaned      LinkStatus = "orphaned"       // Symlink was placed for a glob match that no longer exists
+)
+
+// GetLinkStatus reports the state of the symlink at symlinkPath on the state's filesystem without
+// modifying anything, along with where following it leads if it is a symlink.
+func GetLinkStatus(s *state.TrovlState, targetPath, symlinkPath string) (LinkStatus, utils.LinkChain, error) {
+	var chain utils.LinkChain
+	fsys := s.FS
+	targetPath, err := s.CleanPath(targetPath, s.Options.UseRelative)
+	if err != nil {
+		return "", chain, fmt.Errorf("invalid path (target): %w", err)
+	}
+	symlinkPath, err = s.CleanPath(symlinkPath, s.Options.UseRelative)
+	if err != nil {
+		return "", chain, fmt.Errorf("invalid path (symlink): %w", err)
+	}
+
+	targetInfo, err := utils.GetPathInfoFS(fsys, targetPath)
+	if err != nil {
+		return "", chain, fmt.Errorf("could not get target info: %w", err)
+	}
+	if !targetInfo.Exists {
+		return StatusTargetMissing, chain, nil
+	}
+
+	symlinkInfo, err := utils.GetPathInfoFS(fsys, symlinkPath)
+	if err != nil {
+		return "", chain, fmt.Errorf("could not get symlink info: %w", err)
+	}
+	switch {
+	case !symlinkInfo.Exists:
+		return StatusMissing, chain, nil
+	case !symlinkInfo.IsSymlink:
+		return StatusConflict, chain, nil
+	}
+
+	chain, err = utils.ResolveChainFS(fsys, symlinkPath)
+	if errors.Is(err, utils.ErrLinkLoop) {
+		return StatusLoop, chain, nil
+	}
+	if err != nil {
+		return "", chain, fmt.Errorf("could not resolve symlink: %w", err)
+	}
+
+	if utils.SameLinkTargetFS(fsys, symlinkPath, symlinkInfo.TargetPath, targetPath) {
+		return StatusLinked, chain, nil
+	}
+	return StatusWrongTarget, chain, nil
+}
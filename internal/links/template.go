@@ -0,0 +1,300 @@
+package links
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"text/template"
+
+	"github.com/sneha-afk/trovl/internal/journal"
+	"github.com/sneha-afk/trovl/internal/state"
+	"github.com/sneha-afk/trovl/internal/utils"
+	"github.com/sneha-afk/trovl/internal/vfs"
+)
+
+// RenderStatus describes the state of a rendered template at its output path.
+type RenderStatus string
+
+const (
+	RenderMissing   RenderStatus = "missing"   // Nothing exists at the output path
+	RenderClean     RenderStatus = "rendered"  // Output matches the latest render
+	RenderOutdated  RenderStatus = "outdated"  // Output is untouched, but the template or vars changed
+	RenderModified  RenderStatus = "modified"  // Output was edited locally since trovl last wrote it
+	RenderUntracked RenderStatus = "untracked" // Output exists but was never written by trovl
+)
+
+var ErrDeclinedRender error = declinedError("user declined overwriting locally modified file, no action taken")
+
+// renderedFile is the name of the file in the state directory that tracks hashes of rendered outputs.
+const renderedFile = "rendered.json"
+
+// renderedHashes maps an output path to the hash of the content trovl last wrote there.
+type renderedHashes map[string]string
+
+func loadRenderedHashes() (renderedHashes, string, error) {
+	stateDir, err := utils.GetStateDir()
+	if err != nil {
+		return nil, "", fmt.Errorf("could not get state directory: %w", err)
+	}
+	path := filepath.Join(stateDir, renderedFile)
+
+	hashes := renderedHashes{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return hashes, path, nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("could not read rendered hashes: %w", err)
+	}
+	if err := json.Unmarshal(data, &hashes); err != nil {
+		return nil, "", fmt.Errorf("could not parse rendered hashes: %w", err)
+	}
+	return hashes, path, nil
+}
+
+// renderedMu serializes updates to the rendered hashes, as templates may be rendered concurrently.
+var renderedMu sync.Mutex
+
+// recordRenderedHash tracks hash as what was last written to outPath.
+func recordRenderedHash(outPath, hash string) error {
+	renderedMu.Lock()
+	defer renderedMu.Unlock()
+
+	hashes, path, err := loadRenderedHashes()
+	if err != nil {
+		return err
+	}
+	hashes[outPath] = hash
+	return hashes.save(path)
+}
+
+func (h renderedHashes) save(path string) error {
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("could not create state directory: %w", err)
+	}
+	return writeFileAtomic(vfs.OS, path, data, 0o644)
+}
+
+// renderTemplate executes the template at targetPath on fsys with data, returning the output and its hash.
+func renderTemplate(fsys vfs.FS, targetPath string, data any) ([]byte, string, error) {
+	text, err := fsys.ReadFile(targetPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not read template: %w", err)
+	}
+	tmpl, err := template.New(filepath.Base(targetPath)).
+		Option("missingkey=error").
+		Funcs(template.FuncMap{"env": os.Getenv}).
+		Parse(string(text))
+	if err != nil {
+		return nil, "", fmt.Errorf("could not parse template: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, "", fmt.Errorf("could not render template: %w", err)
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	return buf.Bytes(), hex.EncodeToString(sum[:]), nil
+}
+
+// Render executes the Go text/template at targetPath with data and writes the result to outPath.
+// The hash of what was written is tracked in the state directory, so re-rendering only rewrites
+// the output when it changed, and local edits to the output are detected before being overwritten.
+// In dry-runs, the output is only written to the state's in-memory filesystem.
+func Render(s *state.TrovlState, targetPath, outPath string, data any) error {
+	targetPath, err := s.CleanPath(targetPath, false)
+	if err != nil {
+		return fmt.Errorf("invalid path (target): %w", err)
+	}
+	outPath, err = s.CleanPath(outPath, false)
+	if err != nil {
+		return fmt.Errorf("invalid path (output): %w", err)
+	}
+
+	targetInfo, err := s.FS.Stat(targetPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("invalid target path '%v': %w", targetPath, ErrTargetMissing)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid target path '%v': %w", targetPath, err)
+	}
+	if targetInfo.IsDir() {
+		return fmt.Errorf("template target '%v' is a directory: %w", targetPath, utils.ErrIsDir)
+	}
+
+	content, hash, err := renderTemplate(s.FS, targetPath, data)
+	if err != nil {
+		return err
+	}
+
+	hashes, _, err := loadRenderedHashes()
+	if err != nil {
+		return err
+	}
+	hostPath := s.HostPath(outPath)
+
+	status, err := renderStatus(s.FS, outPath, hash, hashes)
+	if err != nil {
+		return err
+	}
+
+	switch status {
+	case RenderClean:
+		s.Logger.Info("Rendered file is up to date", "output", outPath)
+		if hashes[hostPath] == hash || s.Options.DryRun {
+			return nil
+		}
+		// adopt an identical, previously untracked file so later local edits are detected
+		return recordRenderedHash(hostPath, hash)
+	case RenderModified, RenderUntracked:
+		s.Logger.Warn("Existing file at output path was not written by trovl or has local edits", "output", outPath, "status", status)
+
+		shouldOverwrite := false
+		if s.Options.OverwriteYes {
+			shouldOverwrite = true
+		} else if s.Options.DryRun {
+			// nobody is asked in dry-runs, so assume the overwrite would be accepted
+			shouldOverwrite = !s.Options.OverwriteNo
+		} else if !s.Options.OverwriteNo {
+			shouldOverwrite, err = s.Confirm(state.Conflict{
+				Kind:     string(ActionRender),
+				Path:     outPath,
+				Target:   targetPath,
+				Question: "Overwrite with rendered template?",
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		s.Logger.Info("User's decision for overwriting", "overwrite", shouldOverwrite)
+		if !shouldOverwrite {
+			s.Logger.Warn("Declined overwriting existing file, no action taken")
+			return ErrDeclinedRender
+		}
+		s.LogOverwrite("Overwriting existing file", "existing_path", outPath)
+	}
+
+	s.LogLink("Rendering template", "target", targetPath, "output", outPath, "status", status)
+
+	if err := s.FS.MkdirAll(filepath.Dir(outPath), 0o755); err != nil {
+		return fmt.Errorf("failed to create parent directories: %w", err)
+	}
+	switch {
+	case s.Options.DryRun:
+		// nothing is backed up or recorded
+	case status == RenderMissing:
+		s.Record(journal.Action{Type: journal.RemoveFile, Path: hostPath, Hash: hash})
+	default:
+		backupPath, err := utils.BackupFile(hostPath, utils.BackupOptions{
+			Dir:             s.Options.BackupDir,
+			TimestampFormat: utils.FileTimeFormat,
+			Compress:        s.Options.BackupCompress,
+		})
+		if err != nil {
+			return fmt.Errorf("could not backup existing file: %w", err)
+		}
+		s.LogBackup("Backed up existing file", "backup", backupPath, "original", outPath)
+		s.Record(journal.Action{Type: journal.RestoreBackup, Path: hostPath, Backup: backupPath})
+	}
+
+	// renaming replaces an existing symlink itself, rather than writing into whatever it points to
+	if err := writeFileAtomic(s.FS, outPath, content, targetInfo.Mode().Perm()); err != nil {
+		return fmt.Errorf("could not write rendered file: %w", err)
+	}
+	if s.Options.DryRun {
+		return nil
+	}
+
+	if err := recordRenderedHash(hostPath, hash); err != nil {
+		return fmt.Errorf("could not record rendered hash: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file beside path and renames it over path, so the
+// file at path is never missing or partially written.
+func writeFileAtomic(fsys vfs.FS, path string, data []byte, perm fs.FileMode) error {
+	tmp := tempPath(path)
+	if err := fsys.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	if err := fsys.Rename(tmp, path); err != nil {
+		fsys.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// GetRenderStatus reports the state of the rendered output of targetPath at outPath on the state's
+// filesystem without modifying anything. ErrTargetMissing is returned if there is no template.
+func GetRenderStatus(s *state.TrovlState, targetPath, outPath string, data any) (RenderStatus, error) {
+	fsys := s.FS
+	targetPath, err := s.CleanPath(targetPath, false)
+	if err != nil {
+		return "", fmt.Errorf("invalid path (target): %w", err)
+	}
+	outPath, err = s.CleanPath(outPath, false)
+	if err != nil {
+		return "", fmt.Errorf("invalid path (output): %w", err)
+	}
+
+	if _, err := fsys.Stat(targetPath); errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("invalid target path '%v': %w", targetPath, ErrTargetMissing)
+	}
+	_, hash, err := renderTemplate(fsys, targetPath, data)
+	if err != nil {
+		return "", err
+	}
+
+	hashes, _, err := loadRenderedHashes()
+	if err != nil {
+		return "", err
+	}
+	return renderStatus(fsys, outPath, hash, hashes)
+}
+
+func renderStatus(fsys vfs.FS, outPath, wantHash string, hashes renderedHashes) (RenderStatus, error) {
+	info, err := utils.GetPathInfoFS(fsys, outPath)
+	if err != nil {
+		return "", fmt.Errorf("could not get output info: %w", err)
+	}
+	if !info.Exists {
+		return RenderMissing, nil
+	}
+	if info.IsDir {
+		return "", fmt.Errorf("existing file at output path is a directory: %w", ErrConflictDir)
+	}
+	if info.IsSymlink {
+		return RenderUntracked, nil
+	}
+
+	currHash, err := utils.HashFileFS(fsys, outPath)
+	if err != nil {
+		return "", fmt.Errorf("could not hash existing output: %w", err)
+	}
+
+	recorded, tracked := hashes[vfs.HostPath(fsys, outPath)]
+	switch {
+	case currHash == wantHash:
+		return RenderClean, nil
+	case !tracked:
+		return RenderUntracked, nil
+	case currHash != recorded:
+		return RenderModified, nil
+	default:
+		return RenderOutdated, nil
+	}
+}
@@ -0,0 +1,158 @@
+package links
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	"github.com/sneha-afk/trovl/internal/journal"
+	"github.com/sneha-afk/trovl/internal/state"
+	"github.com/sneha-afk/trovl/internal/utils"
+)
+
+// ErrUndoSkipped is returned when some actions of an operation were not reverted because the
+// filesystem has changed since in a way that reverting would lose data.
+var ErrUndoSkipped = errors.New("some changes were not reverted")
+
+// Undo reverts an operation by running its inverse actions in reverse order. Each action is only
+// taken if the path is still as the operation left it, otherwise it is skipped with a warning. An
+// action that fails does not stop the others; the actions that failed are returned in their
+// original order, so they can be kept to undo again.
+func Undo(s *state.TrovlState, op *journal.Operation) ([]journal.Action, error) {
+	var errs []error
+	var failed []journal.Action
+	skipped := 0
+
+	for _, a := range slices.Backward(op.Actions) {
+		reverted, err := undoAction(s, a)
+		if err != nil {
+			s.Logger.Error("Could not revert change", "type", a.Type, "path", a.Path, "error", err)
+			errs = append(errs, fmt.Errorf("could not revert %v of %v: %w", a.Type, a.Path, err))
+			failed = append(failed, a)
+			continue
+		}
+		if !reverted {
+			skipped++
+		}
+	}
+
+	if skipped > 0 {
+		errs = append(errs, fmt.Errorf("%w (%d of %d)", ErrUndoSkipped, skipped, len(op.Actions)))
+	}
+	slices.Reverse(failed)
+	return failed, errors.Join(errs...)
+}
+
+// undoAction runs a single inverse action, returning whether it was taken.
+func undoAction(s *state.TrovlState, a journal.Action) (bool, error) {
+	info, err := utils.GetPathInfoFS(s.FS, a.Path)
+	if err != nil {
+		return false, err
+	}
+
+	switch a.Type {
+	case journal.RemoveLink:
+		if !info.IsSymlink || info.TargetPath != a.Target {
+			s.Logger.Warn("Symlink changed since the operation, not removing", "link", a.Path, "expected_target", a.Target)
+			return false, nil
+		}
+		s.LogLink("Removing symlink", "link", a.Path, "target", a.Target)
+		if s.Options.DryRun {
+			return true, nil
+		}
+		if err := s.FS.Remove(a.Path); err != nil {
+			return false, err
+		}
+		s.Ledger.Remove(a.Path)
+		return true, nil
+
+	case journal.CreateLink:
+		if info.Exists {
+			s.Logger.Warn("Path is occupied, not recreating symlink", "link", a.Path, "target", a.Target)
+			return false, nil
+		}
+		s.LogLink("Recreating symlink", "link", a.Path, "target", a.Target)
+		if s.Options.DryRun {
+			return true, nil
+		}
+		if err := s.FS.MkdirAll(filepath.Dir(a.Path), 0755); err != nil {
+			return false, err
+		}
+		if err := s.FS.Symlink(a.Target, a.Path); err != nil {
+			return false, err
+		}
+		s.Ledger.Add(a.Path, a.Target)
+		return true, nil
+
+	case journal.RestoreBackup:
+		if info.Exists && (info.IsDir || !info.IsSymlink) {
+			s.Logger.Warn("Path is occupied by a file or directory, not restoring backup", "path", a.Path, "backup", a.Backup)
+			return false, nil
+		}
+		b, err := utils.LoadBackup(a.Backup)
+		if err != nil {
+			return false, fmt.Errorf("could not read backup: %w", err)
+		}
+		s.LogBackup("Restoring backup", "backup", a.Backup, "destination", a.Path)
+		if s.Options.DryRun {
+			return true, nil
+		}
+		if err := s.FS.MkdirAll(filepath.Dir(a.Path), 0755); err != nil {
+			return false, err
+		}
+		if err := utils.RestoreBackup(b, a.Path); err != nil {
+			return false, err
+		}
+		// whatever was there before trovl replaced it is not trovl's
+		s.Ledger.Remove(a.Path)
+		return true, nil
+
+	case journal.RemoveFile:
+		if !info.Exists {
+			return true, nil
+		}
+		if info.IsSymlink || info.IsDir {
+			s.Logger.Warn("Path changed since the operation, not removing", "path", a.Path)
+			return false, nil
+		}
+		hash, err := utils.HashFileFS(s.FS, a.Path)
+		if err != nil {
+			return false, err
+		}
+		if hash != a.Hash {
+			s.Logger.Warn("File was modified since the operation, not removing", "path", a.Path)
+			return false, nil
+		}
+		s.LogOverwrite("Removing written file", "path", a.Path)
+		if s.Options.DryRun {
+			return true, nil
+		}
+		return true, s.FS.Remove(a.Path)
+
+	case journal.RemoveDir:
+		if !info.Exists {
+			return true, nil
+		}
+		if info.IsSymlink || !info.IsDir {
+			s.Logger.Warn("Path changed since the operation, not removing", "path", a.Path)
+			return false, nil
+		}
+		entries, err := s.FS.ReadDir(a.Path)
+		if err != nil {
+			return false, err
+		}
+		if len(entries) > 0 {
+			s.Logger.Warn("Directory is no longer empty, not removing", "path", a.Path)
+			return false, nil
+		}
+		s.LogOverwrite("Removing created directory", "path", a.Path)
+		if s.Options.DryRun {
+			return true, nil
+		}
+		return true, s.FS.Remove(a.Path)
+
+	default:
+		return false, fmt.Errorf("unknown action type %q", a.Type)
+	}
+}
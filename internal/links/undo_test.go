@@ -0,0 +1,229 @@
+package links_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sneha-afk/trovl/internal/journal"
+	"github.com/sneha-afk/trovl/internal/links"
+	"github.com/sneha-afk/trovl/internal/state"
+)
+
+func TestUndo(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  *state.TrovlOptions
+		setup    func(tmp, targetPath, linkPath string)
+		run      func(st *state.TrovlState, targetPath, linkPath string) error
+		between  func(tmp, targetPath, linkPath string)
+		wantErr  error
+		validate func(t *testing.T, tmp, targetPath, linkPath string)
+	}{
+		{
+			name: "created symlink is removed",
+			setup: func(tmp, targetPath, linkPath string) {
+				os.WriteFile(targetPath, []byte("target"), 0644)
+			},
+			run: func(st *state.TrovlState, targetPath, linkPath string) error {
+				_, err := links.Add(st, targetPath, linkPath)
+				return err
+			},
+			validate: func(t *testing.T, tmp, targetPath, linkPath string) {
+				if _, err := os.Lstat(linkPath); !os.IsNotExist(err) {
+					t.Errorf("expected symlink to be removed, got %v", err)
+				}
+				if _, err := os.Stat(targetPath); err != nil {
+					t.Errorf("expected target to be untouched: %v", err)
+				}
+			},
+		},
+		{
+			name:    "backed up file is restored",
+			options: &state.TrovlOptions{BackupYes: true},
+			setup: func(tmp, targetPath, linkPath string) {
+				os.WriteFile(targetPath, []byte("target"), 0644)
+				os.WriteFile(linkPath, []byte("ordinary"), 0644)
+			},
+			run: func(st *state.TrovlState, targetPath, linkPath string) error {
+				_, err := links.Add(st, targetPath, linkPath)
+				return err
+			},
+			validate: func(t *testing.T, tmp, targetPath, linkPath string) {
+				info, err := os.Lstat(linkPath)
+				if err != nil || info.Mode()&os.ModeSymlink != 0 {
+					t.Fatalf("expected ordinary file to be restored, got %v", err)
+				}
+				if data, _ := os.ReadFile(linkPath); string(data) != "ordinary" {
+					t.Errorf("unexpected restored contents %q", data)
+				}
+			},
+		},
+		{
+			name:    "overwritten symlink is restored",
+			options: &state.TrovlOptions{OverwriteYes: true, Force: true},
+			setup: func(tmp, targetPath, linkPath string) {
+				os.WriteFile(targetPath, []byte("target"), 0644)
+				os.Symlink(filepath.Join(tmp, "elsewhere"), linkPath)
+			},
+			run: func(st *state.TrovlState, targetPath, linkPath string) error {
+				_, err := links.Add(st, targetPath, linkPath)
+				return err
+			},
+			validate: func(t *testing.T, tmp, targetPath, linkPath string) {
+				if got, err := os.Readlink(linkPath); err != nil || got != filepath.Join(tmp, "elsewhere") {
+					t.Errorf("expected previous symlink to be restored, got %q (%v)", got, err)
+				}
+			},
+		},
+		{
+			name:    "removed symlink is recreated",
+			options: &state.TrovlOptions{Force: true},
+			setup: func(tmp, targetPath, linkPath string) {
+				os.WriteFile(targetPath, []byte("target"), 0644)
+				os.Symlink(targetPath, linkPath)
+			},
+			run: func(st *state.TrovlState, targetPath, linkPath string) error {
+				_, err := links.RemoveByPath(st, linkPath)
+				return err
+			},
+			validate: func(t *testing.T, tmp, targetPath, linkPath string) {
+				if got, err := os.Readlink(linkPath); err != nil || got != targetPath {
+					t.Errorf("expected symlink to be recreated, got %q (%v)", got, err)
+				}
+			},
+		},
+		{
+			name:  "created target is removed",
+			setup: func(tmp, targetPath, linkPath string) {},
+			run: func(st *state.TrovlState, targetPath, linkPath string) error {
+				if err := links.PlaceTarget(st, links.Action{Kind: links.ActionCreateTarget, Link: targetPath}); err != nil {
+					return err
+				}
+				return links.PlaceTarget(st, links.Action{Kind: links.ActionCreateTarget, Link: filepath.Join(filepath.Dir(targetPath), "dir"), Type: links.LinkDirectory})
+			},
+			validate: func(t *testing.T, tmp, targetPath, linkPath string) {
+				for _, path := range []string{targetPath, filepath.Join(tmp, "dir")} {
+					if _, err := os.Lstat(path); !os.IsNotExist(err) {
+						t.Errorf("expected created target %v to be removed, got %v", path, err)
+					}
+				}
+			},
+		},
+		{
+			name:  "created target written to since is not removed",
+			setup: func(tmp, targetPath, linkPath string) {},
+			run: func(st *state.TrovlState, targetPath, linkPath string) error {
+				if err := links.PlaceTarget(st, links.Action{Kind: links.ActionCreateTarget, Link: targetPath}); err != nil {
+					return err
+				}
+				return links.PlaceTarget(st, links.Action{Kind: links.ActionCreateTarget, Link: filepath.Join(filepath.Dir(targetPath), "dir"), Type: links.LinkDirectory})
+			},
+			between: func(tmp, targetPath, linkPath string) {
+				os.WriteFile(targetPath, []byte("history"), 0644)
+				os.WriteFile(filepath.Join(tmp, "dir", "file"), []byte("data"), 0644)
+			},
+			wantErr: links.ErrUndoSkipped,
+			validate: func(t *testing.T, tmp, targetPath, linkPath string) {
+				if data, _ := os.ReadFile(targetPath); string(data) != "history" {
+					t.Errorf("expected written target to be left alone, got %q", data)
+				}
+				if _, err := os.Stat(filepath.Join(tmp, "dir", "file")); err != nil {
+					t.Errorf("expected non-empty directory to be left alone: %v", err)
+				}
+			},
+		},
+		{
+			name: "symlink changed since is not removed",
+			setup: func(tmp, targetPath, linkPath string) {
+				os.WriteFile(targetPath, []byte("target"), 0644)
+			},
+			run: func(st *state.TrovlState, targetPath, linkPath string) error {
+				_, err := links.Add(st, targetPath, linkPath)
+				return err
+			},
+			between: func(tmp, targetPath, linkPath string) {
+				os.Remove(linkPath)
+				os.Symlink(filepath.Join(tmp, "someone_else"), linkPath)
+			},
+			wantErr: links.ErrUndoSkipped,
+			validate: func(t *testing.T, tmp, targetPath, linkPath string) {
+				if got, _ := os.Readlink(linkPath); got != filepath.Join(tmp, "someone_else") {
+					t.Errorf("expected changed symlink to be left alone, got %q", got)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmp := t.TempDir()
+			t.Setenv("XDG_CACHE_HOME", tmp)
+			t.Setenv("XDG_STATE_HOME", tmp)
+			targetPath := filepath.Join(tmp, "target.txt")
+			linkPath := filepath.Join(tmp, "link.txt")
+			tt.setup(tmp, targetPath, linkPath)
+
+			st := state.DefaultState()
+			if tt.options != nil {
+				st.Options = tt.options
+			}
+			st.Journal = journal.New("test", nil)
+
+			if err := tt.run(st, targetPath, linkPath); err != nil {
+				t.Fatalf("unexpected error during operation: %v", err)
+			}
+			if tt.between != nil {
+				tt.between(tmp, targetPath, linkPath)
+			}
+
+			failed, err := links.Undo(st, st.Journal)
+			if len(failed) > 0 {
+				t.Errorf("Undo: unexpected failed actions %+v", failed)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Undo: want %v, got %v", tt.wantErr, err)
+			}
+			tt.validate(t, tmp, targetPath, linkPath)
+		})
+	}
+}
+
+func TestUndo_ContinuesPastFailures(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", tmp)
+	t.Setenv("XDG_STATE_HOME", tmp)
+	backupDir := filepath.Join(tmp, "backups")
+	targetPath := filepath.Join(tmp, "target.txt")
+	replaced := filepath.Join(tmp, "replaced.txt")
+	created := filepath.Join(tmp, "created.txt")
+	os.WriteFile(targetPath, []byte("target"), 0644)
+	os.WriteFile(replaced, []byte("ordinary"), 0644)
+
+	st := state.DefaultState()
+	st.Options = &state.TrovlOptions{BackupYes: true, BackupDir: backupDir}
+	st.Journal = journal.New("test", nil)
+	if _, err := links.Add(st, targetPath, replaced); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := links.Add(st, targetPath, created); err != nil {
+		t.Fatal(err)
+	}
+
+	// the backup is pruned before the operation is undone
+	if err := os.RemoveAll(backupDir); err != nil {
+		t.Fatal(err)
+	}
+
+	failed, err := links.Undo(st, st.Journal)
+	if err == nil {
+		t.Fatal("expected an error restoring a deleted backup")
+	}
+	if _, err := os.Lstat(created); !os.IsNotExist(err) {
+		t.Errorf("expected the symlink created after the failure to still be removed, got %v", err)
+	}
+	if len(failed) != 1 || failed[0].Type != journal.RestoreBackup || failed[0].Path != replaced {
+		t.Errorf("expected only the failed restore to be returned, got %+v", failed)
+	}
+}
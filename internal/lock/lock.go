@@ -0,0 +1,114 @@
+/*
+Package lock provides an advisory lock, so that only one trovl process modifies links and backups
+at a time.
+*/
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sneha-afk/trovl/internal/utils"
+)
+
+// FileName is the name of the lock file in the state directory.
+const FileName = "trovl.lock"
+
+// ErrLocked is returned when another process holds the lock.
+var ErrLocked = errors.New("another trovl process is running")
+
+// errBusy is returned by tryLock when the lock is held elsewhere.
+var errBusy = errors.New("lock is held")
+
+// pollInterval is how often a held lock is retried while waiting for it.
+const pollInterval = 100 * time.Millisecond
+
+// Lock is a held lock, which is released when the process exits if not before.
+type Lock struct {
+	f *os.File
+}
+
+// Path returns the path of the lock file in the state directory.
+func Path() (string, error) {
+	stateDir, err := utils.GetStateDir()
+	if err != nil {
+		return "", fmt.Errorf("could not get state directory: %w", err)
+	}
+	return filepath.Join(stateDir, FileName), nil
+}
+
+// Acquire takes the lock at path. If another process holds it, an error wrapping ErrLocked is
+// returned, unless wait is set, in which case Acquire waits until it is released or ctx is done.
+func Acquire(ctx context.Context, path string, wait bool) (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("could not create lock directory: %w", err)
+	}
+
+	for {
+		f, err := tryLock(path)
+		if err == nil {
+			l := &Lock{f: f}
+			l.writePID()
+			return l, nil
+		}
+		if !errors.Is(err, errBusy) {
+			return nil, fmt.Errorf("could not lock %v: %w", path, err)
+		}
+		if !wait {
+			return nil, lockedErr(path)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: %w", lockedErr(path), ctx.Err())
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// lockedErr describes which process holds the lock at path, if it is known.
+func lockedErr(path string) error {
+	if pid, ok := readPID(path); ok {
+		return fmt.Errorf("%w (pid %d)", ErrLocked, pid)
+	}
+	return ErrLocked
+}
+
+// writePID records the current process as the holder of the lock. This is only informational, so
+// failing to do so is not an error.
+func (l *Lock) writePID() {
+	if err := l.f.Truncate(0); err != nil {
+		return
+	}
+	_, _ = l.f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+}
+
+func readPID(path string) (int, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid, err == nil && pid > 0
+}
+
+// Release releases the lock. The lock file itself is left in place, as removing it could let
+// two processes lock different files.
+func (l *Lock) Release() error {
+	if l == nil || l.f == nil {
+		return nil
+	}
+	_ = l.f.Truncate(0)
+	err := unlock(l.f)
+	if closeErr := l.f.Close(); err == nil {
+		err = closeErr
+	}
+	l.f = nil
+	return err
+}
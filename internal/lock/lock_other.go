@@ -0,0 +1,14 @@
+//go:build !unix && !windows
+
+package lock
+
+import "os"
+
+// tryLock opens the lock file. Platforms without file locking are never locked.
+func tryLock(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+}
+
+func unlock(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,54 @@
+package lock_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sneha-afk/trovl/internal/lock"
+)
+
+func TestAcquire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", lock.FileName)
+	ctx := context.Background()
+
+	held, err := lock.Acquire(ctx, path, false)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	_, err = lock.Acquire(ctx, path, false)
+	if !errors.Is(err, lock.ErrLocked) {
+		t.Fatalf("Acquire() while held error = %v, want ErrLocked", err)
+	}
+	if want := fmt.Sprintf("pid %d", os.Getpid()); !strings.Contains(err.Error(), want) {
+		t.Errorf("Acquire() while held error = %q, want it to contain %q", err, want)
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, 150*time.Millisecond)
+	defer cancel()
+	if _, err := lock.Acquire(timeout, path, true); !errors.Is(err, lock.ErrLocked) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Acquire() waiting past deadline error = %v, want ErrLocked and DeadlineExceeded", err)
+	}
+
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		held.Release()
+	}()
+	waited, err := lock.Acquire(ctx, path, true)
+	if err != nil {
+		t.Fatalf("Acquire() waiting for release error = %v", err)
+	}
+	if err := waited.Release(); err != nil {
+		t.Errorf("Release() error = %v", err)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("lock file should be left in place: %v", err)
+	}
+}
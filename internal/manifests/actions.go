@@ -9,31 +9,74 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"os/user"
 	"runtime"
 	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/sneha-afk/trovl/internal/ignore"
 	"github.com/sneha-afk/trovl/internal/links"
 	"github.com/sneha-afk/trovl/internal/state"
+	"github.com/sneha-afk/trovl/internal/utils"
+	"github.com/sneha-afk/trovl/internal/vfs"
 )
 
 type PlatformOverride struct {
 	Link string `json:"link"`
 }
 
+// What create_target makes a missing target as
+const (
+	CreateTargetFile = "file" // An empty file
+	CreateTargetDir  = "dir"  // An empty directory
+)
+
+// Methods of placing a target at its link path
+const (
+	MethodSymlink  = "symlink"  // Default: the link path is a symlink to the target
+	MethodTemplate = "template" // The target is rendered as a Go text/template and written to the link path
+)
+
 type ManifestLink struct {
 	Target            string                      `json:"target"`
 	Link              string                      `json:"link"`
 	Platforms         []string                    `json:"platforms"`
 	Relative          bool                        `json:"relative"`
+	Method            string                      `json:"method,omitempty"`
+	Optional          bool                        `json:"optional,omitempty"`      // A missing target is skipped rather than an error
+	CreateTarget      string                      `json:"create_target,omitempty"` // A missing target is created empty first, see CreateTargetFile
 	PlatformOverrides map[string]PlatformOverride `json:"platform_overrides,omitempty"`
 }
 
 type Manifest struct {
-	Links []ManifestLink `json:"links"`
+	Vars   map[string]string `json:"vars,omitempty"`
+	Ignore []string          `json:"ignore,omitempty"` // Patterns left out of glob targets, in .trovlignore syntax
+	Links  []ManifestLink    `json:"links"`
+	Path   string            `json:"-"` // File the manifest was read from, if any
+}
+
+// HostFacts are details of the current machine that are available to templates as {{ .Host }}.
+type HostFacts struct {
+	Hostname string
+	OS       string
+	Arch     string
+	User     string
+	Home     string
+	WSL      bool
 }
 
+// TemplateData is what templated links are rendered with.
+type TemplateData struct {
+	Vars map[string]string
+	Host HostFacts
+}
+
+// ErrInvalidManifest is returned when a manifest cannot be read or does not follow the schema.
+var ErrInvalidManifest = errors.New("invalid manifest")
+
 var allSupportedPlatforms mapset.Set[string] = mapset.NewSet("windows", "linux", "darwin", "wsl")
 
 func isWSL() bool {
@@ -43,6 +86,22 @@ func isWSL() bool {
 	return false
 }
 
+// GetHostFacts returns the facts of the current machine, with the home directory the state's paths
+// are expanded against.
+func GetHostFacts(s *state.TrovlState) HostFacts {
+	facts := HostFacts{
+		OS:   runtime.GOOS,
+		Arch: runtime.GOARCH,
+		WSL:  isWSL(),
+	}
+	facts.Hostname, _ = os.Hostname()
+	facts.Home, _ = s.Options.PathEnv().HomeDir()
+	if u, err := user.Current(); err == nil {
+		facts.User = u.Username
+	}
+	return facts
+}
+
 func IsSupportedPlatform(platform string) bool {
 	platform = strings.ToLower(platform)
 	if platform == "all" {
@@ -54,14 +113,20 @@ func IsSupportedPlatform(platform string) bool {
 func New(path string) (*Manifest, error) {
 	manifestFile, err := os.ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("could not read manifest file: %v", err)
+		return nil, fmt.Errorf("%w: could not read manifest file: %w", ErrInvalidManifest, err)
 	}
+	return Parse(manifestFile, path)
+}
 
+// Parse reads a manifest from its JSON contents. path is where it came from, used in messages and
+// may be empty.
+func Parse(data []byte, path string) (*Manifest, error) {
 	m := &Manifest{}
-	if err := json.Unmarshal(manifestFile, &m); err != nil {
-		return nil, fmt.Errorf("could not unmarshal manifest: %v", err)
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%w: could not unmarshal manifest: %w", ErrInvalidManifest, err)
 	}
 	m.FillDefaults()
+	m.Path = path
 
 	return m, nil
 }
@@ -93,6 +158,27 @@ func (m *Manifest) UnmarshalJSON(data []byte) error {
 		if link.PlatformOverrides == nil {
 			link.PlatformOverrides = map[string]PlatformOverride{}
 		}
+	}
+
+	parsed := Manifest(temp)
+	if err := parsed.Validate(); err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Validate checks that every link follows the schema. Whether links overlap depends on how their paths
+// expand, so it is checked separately by CheckOverlaps.
+func (m *Manifest) Validate() error {
+	for _, pattern := range m.Ignore {
+		if err := ignore.Check(pattern); err != nil {
+			return fmt.Errorf("ignore: %w", err)
+		}
+	}
+
+	for i := range m.Links {
+		link := &m.Links[i]
 
 		if link.Target == "" {
 			return fmt.Errorf("links[%d]: missing target", i)
@@ -101,6 +187,31 @@ func (m *Manifest) UnmarshalJSON(data []byte) error {
 			return fmt.Errorf("links[%d]: missing link", i)
 		}
 
+		switch link.Method {
+		case "", MethodSymlink, MethodTemplate:
+		default:
+			return fmt.Errorf("links[%d]: unsupported method %q", i, link.Method)
+		}
+		if link.Method == MethodTemplate && utils.IsGlob(link.Target) {
+			return fmt.Errorf("links[%d]: a glob target cannot be rendered as a template", i)
+		}
+
+		switch link.CreateTarget {
+		case "", CreateTargetFile, CreateTargetDir:
+		default:
+			return fmt.Errorf("links[%d]: unsupported create_target %q (expected %q or %q)", i, link.CreateTarget, CreateTargetFile, CreateTargetDir)
+		}
+		if link.CreateTarget != "" {
+			switch {
+			case link.Optional:
+				return fmt.Errorf("links[%d]: create_target cannot be combined with optional", i)
+			case utils.IsGlob(link.Target):
+				return fmt.Errorf("links[%d]: create_target cannot be used with a glob target", i)
+			case link.CreateTarget == CreateTargetDir && link.Method == MethodTemplate:
+				return fmt.Errorf("links[%d]: a templated link needs a file target, not create_target %q", i, CreateTargetDir)
+			}
+		}
+
 		if slices.Contains(link.Platforms, "all") && len(link.Platforms) > 1 {
 			return fmt.Errorf("links[%d]: 'all' cannot be combined with other platforms", i)
 		}
@@ -125,45 +236,197 @@ func (m *Manifest) UnmarshalJSON(data []byte) error {
 			}
 		}
 	}
-
-	*m = Manifest(temp)
 	return nil
 }
 
+// linkForPlatform returns the link path to use on the current platform, and false if the link
+// does not apply to this platform at all.
+func (l *ManifestLink) linkForPlatform(isWSL bool) (string, bool) {
+	if override, ok := l.PlatformOverrides[runtime.GOOS]; ok {
+		// 1. If an override exists for this platform, it always wins
+		return override.Link, true
+	}
+
+	// 2. Determine whether this link applies to the current platform
+	if slices.Contains(l.Platforms, "all") || slices.Contains(l.Platforms, runtime.GOOS) || (isWSL && slices.Contains(l.Platforms, "wsl")) {
+		return l.Link, true
+	}
+	return "", false
+}
+
+func (m *Manifest) templateData(s *state.TrovlState) TemplateData {
+	return TemplateData{
+		Vars: m.Vars,
+		Host: GetHostFacts(s),
+	}
+}
+
+// Apply plans the actions the manifest involves, then executes them. With KeepGoing, links that
+// could be planned are still applied when others could not, and all errors are joined.
 func (m *Manifest) Apply(s *state.TrovlState) error {
-	var linkToUse string
-	var numLinks = len(m.Links)
+	if err := CheckOverlaps(s, m); err != nil {
+		return err
+	}
+	p := NewPlan()
+	planErr := m.Plan(s, p)
+	if planErr != nil && !s.Options.KeepGoing {
+		return planErr
+	}
+	return errors.Join(planErr, p.Execute(s))
+}
+
+func (l *ManifestLink) method() string {
+	if l.Method == "" {
+		return MethodSymlink
+	}
+	return l.Method
+}
+
+// LinkStatus is the state of a single manifest link on this machine.
+type LinkStatus struct {
+	Manifest string   `json:"manifest,omitempty"`
+	Index    int      `json:"index"`
+	Target   string   `json:"target"`
+	Link     string   `json:"link"`
+	Method   string   `json:"method"`
+	Status   string   `json:"status"`
+	Optional bool     `json:"optional,omitempty"` // The link is skipped if its target is missing, which is not drift
+	Chain    []string `json:"chain,omitempty"`    // Each symlink followed from the link, then where it ends, if it is a symlink
+}
+
+// Status reports the state of every link in the manifest that applies to the current platform,
+// without modifying anything. A glob target is reported as each link it expands to, along with any
+// orphaned link it left behind. Up to Jobs links are checked at once, and statuses are returned in
+// manifest order.
+func (m *Manifest) Status(s *state.TrovlState) ([]LinkStatus, error) {
 	var isWSL = isWSL()
+	var data = m.templateData(s)
+	var statuses = make([][]LinkStatus, len(m.Links))
+	var errs = make([]error, len(m.Links))
 
-	for i := range m.Links {
+	if s.Jobs() > 1 {
+		s = s.WithFS(vfs.Synchronized(s.FS))
+	}
+
+	forEach(len(m.Links), s.Jobs(), func(i int) bool {
+		if errs[i] = s.Err(); errs[i] != nil {
+			return false
+		}
 		link := &m.Links[i]
 
-		if override, ok := link.PlatformOverrides[runtime.GOOS]; ok {
-			// 1. If an override exists for this platform, it always wins
-			linkToUse = override.Link
-		} else {
-			// 2. Determine whether this link applies to the current platform
-			if slices.Contains(link.Platforms, "all") || slices.Contains(link.Platforms, runtime.GOOS) || (isWSL && slices.Contains(link.Platforms, "wsl")) {
-				linkToUse = link.Link
-			} else {
-				s.Logger.Warn(fmt.Sprintf("links[%d]: link does not apply to current platform, skipping", i), "linkIndex", i, "target", link.Target)
-				continue
-			}
+		linkToUse, ok := link.linkForPlatform(isWSL)
+		if !ok {
+			s.Logger.Debug(fmt.Sprintf("links[%d]: link does not apply to current platform, skipping", i), "linkIndex", i, "target", link.Target)
+			return true
 		}
 
-		err := links.Add(s, link.Target, linkToUse)
-		if errors.Is(err, links.ErrDeclinedOverwrite) || errors.Is(err, links.ErrDeclinedBackup) {
-			err = nil
-			continue
+		if utils.IsGlob(link.Target) {
+			statuses[i], errs[i] = globStatus(s, m, i, link.Target, linkToUse)
+			return errs[i] == nil
 		}
-		if err != nil {
-			return fmt.Errorf("links[%d]: %w", i, err)
+
+		st := LinkStatus{Manifest: m.Path, Index: i, Target: link.Target, Link: linkToUse, Method: link.method(), Optional: link.Optional}
+		if link.Method == MethodTemplate {
+			renderStatus, err := links.GetRenderStatus(s, link.Target, linkToUse, data)
+			switch {
+			case errors.Is(err, links.ErrTargetMissing):
+				st.Status = string(links.StatusTargetMissing)
+			case err != nil:
+				errs[i] = fmt.Errorf("links[%d]: %w", i, err)
+				return false
+			default:
+				st.Status = string(renderStatus)
+			}
+		} else if errs[i] = symlinkStatus(s, &st); errs[i] != nil {
+			return false
 		}
+		statuses[i] = []LinkStatus{st}
+		return true
+	})
 
-		if !s.Options.DryRun {
-			s.LogSuccess(fmt.Sprintf("Added symlink [%v/%v]", i+1, numLinks), "target", link.Target, "link", linkToUse)
+	var result []LinkStatus
+	for i, sts := range statuses {
+		if errs[i] != nil {
+			return result, errs[i]
 		}
+		result = append(result, sts...)
 	}
+	return result, nil
+}
 
+// symlinkStatus fills in the status of the symlink st describes, and the chain it resolves through.
+func symlinkStatus(s *state.TrovlState, st *LinkStatus) error {
+	linkStatus, chain, err := links.GetLinkStatus(s, st.Target, st.Link)
+	if err != nil {
+		return fmt.Errorf("links[%d]: %w", st.Index, err)
+	}
+	st.Status = string(linkStatus)
+	if chain.Links() {
+		st.Chain = chain.Paths()
+	}
+	switch {
+	case linkStatus == links.StatusLoop:
+		s.Logger.Warn(fmt.Sprintf("links[%d]: symlink loops back on itself", st.Index), "chain", chain.String())
+	case len(chain.Hops) > 1:
+		s.Logger.Info(fmt.Sprintf("links[%d]: symlink resolves through a chain", st.Index), "chain", chain.String())
+	}
 	return nil
 }
+
+// globStatus reports the status of each link a glob target expands to, followed by the links it
+// placed for matches that are gone since.
+func globStatus(s *state.TrovlState, m *Manifest, i int, target, linkPath string) ([]LinkStatus, error) {
+	g, err := newGlob(s, target, linkPath)
+	if err != nil {
+		return nil, fmt.Errorf("links[%d]: %w", i, err)
+	}
+	expanded, err := g.expand(s.FS, m.Ignore)
+	if err != nil {
+		return nil, fmt.Errorf("links[%d]: %w", i, err)
+	}
+	if len(expanded) == 0 {
+		s.Logger.Debug(fmt.Sprintf("links[%d]: target pattern matches nothing", i), "target", target)
+	}
+
+	var result []LinkStatus
+	for _, e := range expanded {
+		st := LinkStatus{Manifest: m.Path, Index: i, Target: e.target, Link: e.link, Method: MethodSymlink}
+		if err := symlinkStatus(s, &st); err != nil {
+			return nil, err
+		}
+		result = append(result, st)
+	}
+	for _, o := range g.orphans(s.FS, expanded) {
+		result = append(result, LinkStatus{
+			Manifest: m.Path,
+			Index:    i,
+			Target:   o.target,
+			Link:     o.link,
+			Method:   MethodSymlink,
+			Status:   string(links.StatusOrphaned),
+		})
+	}
+	return result, nil
+}
+
+// forEach calls fn with every index below n, from up to jobs goroutines at once. Once fn returns
+// false, no more indices are started.
+func forEach(n, jobs int, fn func(i int) bool) {
+	var stopped atomic.Bool
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for range min(max(jobs, 1), n) {
+		wg.Go(func() {
+			for i := range indices {
+				if !stopped.Load() && !fn(i) {
+					stopped.Store(true)
+				}
+			}
+		})
+	}
+	for i := 0; i < n && !stopped.Load(); i++ {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+}
@@ -1,12 +1,16 @@
 package manifests
 
 import (
+	"errors"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"testing"
 
+	"github.com/sneha-afk/trovl/internal/links"
+	"github.com/sneha-afk/trovl/internal/report"
 	"github.com/sneha-afk/trovl/internal/state"
 	"github.com/sneha-afk/trovl/internal/utils"
 )
@@ -31,10 +35,14 @@ var (
 	overrideForDifferentOS       string
 	overrideRemovesDefault       = `{"links":[{"target":"actual_file","link":"default_symlink","platforms":["all"],"platform_overrides":{"` + runtime.GOOS + `":{"link":"override_symlink"}}}]}`
 	nonexistentSource            = `{"links":[{"target":"nonexistent_file","link":"symlink"}]}`
+	optionalSource               = `{"links":[{"target":"nonexistent_file","link":"symlink","optional":true},{"target":"actual_file","link":"test_symlink"}]}`
+	createTargets                = `{"links":[{"target":"local/history","link":"history","create_target":"file"},{"target":"local/overrides","link":"overrides","create_target":"dir"}]}`
 	multipleOverridesWithCurrent = `{"links":[{"target":"actual_file","link":"default_symlink","platforms":["linux","darwin"],"platform_overrides":{"linux":{"link":"linux_symlink"},"darwin":{"link":"darwin_symlink"},"windows":{"link":"windows_symlink"}}}]}`
 
 	invalidPlatform           = `{"links":[{"target":"actual_file","link":"test_symlink", "platforms":["lolos"]}]}`
 	invalidPlatformInOverride = `{"links":[{"target":"actual_file","link":"default_symlink","platforms":["linux", "windows"],"platform_overrides":{"lolos":{"link":"override_symlink"}}}]}`
+	invalidCreateTarget       = `{"links":[{"target":"actual_file","link":"test_symlink","create_target":"fifo"}]}`
+	optionalCreateTarget      = `{"links":[{"target":"actual_file","link":"test_symlink","optional":true,"create_target":"file"}]}`
 	invalidJSONSyntax         = `{"links":[{"target":"test","link":}]}`
 	invalidJSONStructure      = `["not", "an", "object"]`
 	malformedJSON             = `{this is not json}`
@@ -181,6 +189,18 @@ func TestNew(t *testing.T) {
 			wantErr:     true,
 			errContains: "unsupported platform",
 		},
+		{
+			name:        "unsupported create_target",
+			content:     invalidCreateTarget,
+			wantErr:     true,
+			errContains: "unsupported create_target",
+		},
+		{
+			name:        "create_target with optional",
+			content:     optionalCreateTarget,
+			wantErr:     true,
+			errContains: "cannot be combined with optional",
+		},
 		{
 			name:        "invalid JSON syntax",
 			content:     invalidJSONSyntax,
@@ -248,6 +268,19 @@ func TestNew_FileErrors(t *testing.T) {
 		if !strings.Contains(err.Error(), "could not read manifest file") {
 			t.Errorf("unexpected error message: %v", err)
 		}
+		if !errors.Is(err, ErrInvalidManifest) || !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected error to match ErrInvalidManifest and fs.ErrNotExist, got %v", err)
+		}
+	})
+
+	t.Run("invalid JSON", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "manifest.json")
+		if err := os.WriteFile(path, []byte(malformedJSON), 0644); err != nil {
+			t.Fatalf("failed to create manifest file: %v", err)
+		}
+		if _, err := New(path); !errors.Is(err, ErrInvalidManifest) {
+			t.Errorf("expected error to match ErrInvalidManifest, got %v", err)
+		}
 	})
 
 	t.Run("directory instead of file", func(t *testing.T) {
@@ -456,6 +489,48 @@ func TestApply(t *testing.T) {
 			wantErr: true,
 			setup:   func(tmpDir string) {},
 		},
+		{
+			name:    "optional source doesn't exist",
+			content: optionalSource,
+			setup: func(tmpDir string) {
+				os.WriteFile(filepath.Join(tmpDir, "actual_file"), []byte("content"), 0644)
+			},
+			validate: func(t *testing.T, tmpDir string) {
+				if _, err := os.Lstat(filepath.Join(tmpDir, "symlink")); !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("expected the optional link to be skipped, got %v", err)
+				}
+				if _, err := os.Lstat(filepath.Join(tmpDir, "test_symlink")); err != nil {
+					t.Errorf("expected the links after it to be created: %v", err)
+				}
+			},
+		},
+		{
+			name:    "create_target creates missing targets",
+			content: createTargets,
+			validate: func(t *testing.T, tmpDir string) {
+				info, err := os.Stat(filepath.Join(tmpDir, "history"))
+				if err != nil || !info.Mode().IsRegular() || info.Size() != 0 {
+					t.Errorf("expected history to link to an empty file, got %v, %v", info, err)
+				}
+				info, err = os.Stat(filepath.Join(tmpDir, "overrides"))
+				if err != nil || !info.IsDir() {
+					t.Errorf("expected overrides to link to a directory, got %v, %v", info, err)
+				}
+			},
+		},
+		{
+			name:    "create_target leaves existing targets be",
+			content: createTargets,
+			setup: func(tmpDir string) {
+				os.MkdirAll(filepath.Join(tmpDir, "local"), 0755)
+				os.WriteFile(filepath.Join(tmpDir, "local", "history"), []byte("ls"), 0644)
+			},
+			validate: func(t *testing.T, tmpDir string) {
+				if data, err := os.ReadFile(filepath.Join(tmpDir, "history")); err != nil || string(data) != "ls" {
+					t.Errorf("expected history to link to the existing file, got %q, %v", data, err)
+				}
+			},
+		},
 		{
 			name:    "multiple overrides with current OS",
 			content: multipleOverridesWithCurrent,
@@ -615,6 +690,22 @@ func TestApply(t *testing.T) {
 				os.Mkdir(filepath.Join(tmpDir, "test_symlink"), 0755)
 			},
 		},
+		{
+			name:    "no backup/overwrite option set (eof during tests) - already correct link is a no-op",
+			content: validSingleLink,
+			wantErr: false,
+			options: &state.TrovlOptions{},
+			setup: func(tmpDir string) {
+				os.WriteFile(filepath.Join(tmpDir, "actual_file"), []byte("content"), 0644)
+				os.Symlink(filepath.Join(tmpDir, "actual_file"), filepath.Join(tmpDir, "test_symlink"))
+			},
+			validate: func(t *testing.T, tmpDir string) {
+				linkDest, err := os.Readlink(filepath.Join(tmpDir, "test_symlink"))
+				if err != nil || linkDest != filepath.Join(tmpDir, "actual_file") {
+					t.Errorf("expected symlink to be unchanged, got %q (%v)", linkDest, err)
+				}
+			},
+		},
 		{
 			name:    "no backup/overwrite option set (eof during tests) - errors on existing file",
 			content: validSingleLink,
@@ -710,3 +801,130 @@ func TestIsSupportedPlatform(t *testing.T) {
 		})
 	}
 }
+
+func TestApply_Template(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", tmpDir)
+	t.Setenv("XDG_CACHE_HOME", tmpDir)
+	t.Setenv("XDG_STATE_HOME", tmpDir)
+
+	templatePath := filepath.Join(tmpDir, "gitconfig.tmpl")
+	outPath := filepath.Join(tmpDir, "gitconfig")
+	manifestPath := filepath.Join(tmpDir, "manifest.json")
+
+	content := `{"vars":{"email":"me@example.com"},"links":[{"target":"` + filepath.ToSlash(templatePath) + `","link":"` + filepath.ToSlash(outPath) + `","method":"template"}]}`
+	if err := os.WriteFile(manifestPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to create manifest file: %v", err)
+	}
+	if err := os.WriteFile(templatePath, []byte("email = {{ .Vars.email }}\nos = {{ .Host.OS }}\n"), 0644); err != nil {
+		t.Fatalf("failed to create template: %v", err)
+	}
+
+	m, err := New(manifestPath)
+	if err != nil {
+		t.Fatalf("unexpected error from New(): %v", err)
+	}
+
+	getStatus := func() string {
+		statuses, err := m.Status(teststate)
+		if err != nil {
+			t.Fatalf("unexpected error from Status(): %v", err)
+		}
+		if len(statuses) != 1 {
+			t.Fatalf("expected 1 status, got %d", len(statuses))
+		}
+		return statuses[0].Status
+	}
+
+	if got := getStatus(); got != "missing" {
+		t.Errorf("before apply: expected status missing, got %s", got)
+	}
+
+	if err := m.Apply(teststate); err != nil {
+		t.Fatalf("unexpected error from Apply(): %v", err)
+	}
+
+	data, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatalf("rendered file not written: %v", err)
+	}
+	want := "email = me@example.com\nos = " + runtime.GOOS + "\n"
+	if string(data) != want {
+		t.Errorf("expected rendered content %q, got %q", want, string(data))
+	}
+	if info, err := os.Lstat(outPath); err != nil || info.Mode()&os.ModeSymlink != 0 {
+		t.Errorf("expected rendered output to be an ordinary file")
+	}
+
+	if got := getStatus(); got != "rendered" {
+		t.Errorf("after apply: expected status rendered, got %s", got)
+	}
+
+	if err := os.WriteFile(outPath, []byte("edited locally"), 0644); err != nil {
+		t.Fatalf("could not edit rendered file: %v", err)
+	}
+	if got := getStatus(); got != "modified" {
+		t.Errorf("after local edit: expected status modified, got %s", got)
+	}
+
+	// Declining to overwrite local edits is not an error, and leaves them as-is
+	if err := m.Apply(state.New(&state.TrovlOptions{OverwriteNo: true})); err != nil {
+		t.Fatalf("unexpected error from Apply(): %v", err)
+	}
+	if data, _ := os.ReadFile(outPath); string(data) != "edited locally" {
+		t.Errorf("expected local edits to be kept, got %q", string(data))
+	}
+
+	// A missing template is reported like a missing symlink target, rather than failing
+	if err := os.Remove(templatePath); err != nil {
+		t.Fatalf("could not remove template: %v", err)
+	}
+	if got := getStatus(); got != string(links.StatusTargetMissing) {
+		t.Errorf("without the template: expected status %v, got %s", links.StatusTargetMissing, got)
+	}
+}
+
+func TestApply_KeepGoing(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", tmpDir)
+	t.Setenv("XDG_STATE_HOME", tmpDir)
+
+	target := filepath.Join(tmpDir, "actual_file")
+	if err := os.WriteFile(target, []byte("content"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	m := &Manifest{Links: []ManifestLink{
+		{Target: filepath.Join(tmpDir, "nonexistent"), Link: filepath.Join(tmpDir, "broken"), Platforms: []string{"all"}},
+		{Target: target, Link: filepath.Join(tmpDir, "symlink1"), Platforms: []string{"all"}},
+		{Target: filepath.Join(tmpDir, "nonexistent2"), Link: filepath.Join(tmpDir, "broken2"), Platforms: []string{"all"}},
+		{Target: target, Link: filepath.Join(tmpDir, "symlink2"), Platforms: []string{"all"}},
+	}}
+
+	// without it, nothing is applied after the first failure
+	st := state.New(&state.TrovlOptions{})
+	if err := m.Apply(st); err == nil {
+		t.Fatalf("expected an error from Apply()")
+	}
+	if _, err := os.Lstat(filepath.Join(tmpDir, "symlink1")); !os.IsNotExist(err) {
+		t.Errorf("expected no links to be created after the first failure")
+	}
+
+	st = state.New(&state.TrovlOptions{KeepGoing: true})
+	err := m.Apply(st)
+	if err == nil {
+		t.Fatalf("expected an error from Apply()")
+	}
+	for _, want := range []string{"links[0]", "links[2]"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to include %s, got %v", want, err)
+		}
+	}
+	for _, name := range []string{"symlink1", "symlink2"} {
+		if _, err := os.Readlink(filepath.Join(tmpDir, name)); err != nil {
+			t.Errorf("expected %s to be created despite failures: %v", name, err)
+		}
+	}
+	if st.Summary[report.OutcomeCreated] != 2 || st.Summary[report.OutcomeFailed] != 2 {
+		t.Errorf("expected 2 created and 2 failed, got %v", st.Summary)
+	}
+}
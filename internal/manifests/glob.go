@@ -0,0 +1,147 @@
+package manifests
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/sneha-afk/trovl/internal/ignore"
+	"github.com/sneha-afk/trovl/internal/links"
+	"github.com/sneha-afk/trovl/internal/state"
+	"github.com/sneha-afk/trovl/internal/utils"
+	"github.com/sneha-afk/trovl/internal/vfs"
+)
+
+// globLink is one of the links a glob target stands for.
+type globLink struct {
+	target string
+	link   string
+}
+
+// glob is a link whose target is a pattern, with its paths cleaned.
+type glob struct {
+	pattern string // Target pattern, absolute
+	base    string // Directory every match is inside
+	linkDir string // Directory the links are placed in
+}
+
+func newGlob(s *state.TrovlState, target, linkPath string) (glob, error) {
+	pattern, err := s.CleanPath(target, false)
+	if err != nil {
+		return glob{}, fmt.Errorf("invalid path (target): %w", err)
+	}
+	linkDir, err := s.CleanPath(linkPath, false)
+	if err != nil {
+		return glob{}, fmt.Errorf("invalid path (symlink): %w", err)
+	}
+	return glob{pattern: pattern, base: utils.GlobBase(pattern), linkDir: linkDir}, nil
+}
+
+// expand returns a link for each match of the pattern on fsys, placed at the same path relative to
+// the link directory as the match has relative to the base of the pattern. Files ignored by the
+// ignore files of the repository the base is in, or by patterns, are left out.
+func (g glob) expand(fsys vfs.FS, patterns []string) ([]globLink, error) {
+	matcher := ignore.New(fsys, ignore.FindRoot(fsys, g.base), patterns)
+	matches, err := utils.GlobFS(fsys, g.pattern, matcher.Ignored)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target pattern %q: %w", g.pattern, err)
+	}
+	expanded := make([]globLink, 0, len(matches))
+	for _, match := range matches {
+		rel, err := filepath.Rel(g.base, match)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, globLink{target: match, link: filepath.Join(g.linkDir, rel)})
+	}
+	return expanded, nil
+}
+
+// depth returns how many directories below the link directory links may be placed, or -1 if any.
+func (g glob) depth() int {
+	rest := strings.TrimPrefix(strings.TrimPrefix(g.pattern, g.base), string(filepath.Separator))
+	segs := strings.Split(rest, string(filepath.Separator))
+	if slices.Contains(segs, "**") {
+		return -1
+	}
+	return len(segs) - 1
+}
+
+// orphans returns the symlinks in the link directory that point inside the base of the pattern, but
+// are not one of the expanded links, e.g. as the file they were placed for was removed since.
+func (g glob) orphans(fsys vfs.FS, expanded []globLink) []globLink {
+	current := make(map[string]bool, len(expanded))
+	for _, e := range expanded {
+		current[e.link] = true
+	}
+
+	var found []globLink
+	var walk func(dir string, depth int)
+	walk = func(dir string, depth int) {
+		entries, err := fsys.ReadDir(dir)
+		if err != nil {
+			return
+		}
+		for _, e := range entries {
+			path := filepath.Join(dir, e.Name())
+			if e.IsDir() {
+				if depth != 0 {
+					walk(path, depth-1)
+				}
+				continue
+			}
+			if e.Type()&fs.ModeSymlink == 0 || current[path] {
+				continue
+			}
+			contents, err := fsys.Readlink(path)
+			if err != nil {
+				continue
+			}
+			if target := utils.ResolveLinkTarget(path, contents); utils.IsWithin(target, g.base) {
+				found = append(found, globLink{target: target, link: path})
+			}
+		}
+	}
+	walk(g.linkDir, g.depth())
+	return found
+}
+
+// planGlob plans a link for each match of a glob target, adding each to the plan as it goes so
+// later matches are planned against what earlier ones leave behind. A pattern matching nothing is
+// planned as skipped.
+func (p *Plan) planGlob(s *state.TrovlState, m *Manifest, i int, target, linkPath string) error {
+	g, err := newGlob(s, target, linkPath)
+	if err != nil {
+		return err
+	}
+	expanded, err := g.expand(s.FS, m.Ignore)
+	if err != nil {
+		return err
+	}
+	if len(expanded) == 0 {
+		p.Actions = append(p.Actions, links.Action{
+			Kind:     links.ActionSkip,
+			Manifest: m.Path,
+			Index:    i,
+			Target:   target,
+			Link:     linkPath,
+			Reason:   "target pattern matches nothing",
+		})
+		return nil
+	}
+
+	for _, e := range expanded {
+		a, err := links.PlanLink(s, e.target, e.link)
+		if err != nil {
+			return fmt.Errorf("%v: %w", e.target, err)
+		}
+		a.Manifest = m.Path
+		a.Index = i
+		if err := p.add(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
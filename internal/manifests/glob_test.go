@@ -0,0 +1,158 @@
+package manifests
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/sneha-afk/trovl/internal/links"
+	"github.com/sneha-afk/trovl/internal/state"
+	"github.com/sneha-afk/trovl/internal/vfs"
+)
+
+func globFS(t *testing.T, dotfiles string, files ...string) *vfs.Overlay {
+	t.Helper()
+	fsys := vfs.NewMemFS()
+	for _, f := range files {
+		path := filepath.Join(dotfiles, f)
+		if err := fsys.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := fsys.WriteFile(path, []byte(f), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fsys
+}
+
+func TestPlan_Glob(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	root := string(filepath.Separator)
+	dotfiles := filepath.Join(root, "dotfiles")
+	bin := filepath.Join(root, "home", ".local", "bin")
+
+	st := state.New(&state.TrovlOptions{})
+	st.FS = globFS(t, dotfiles, filepath.Join("bin", "a"), filepath.Join("bin", "b"), filepath.Join("bin", "sub", "c"))
+
+	m := &Manifest{Links: []ManifestLink{
+		{Target: filepath.Join(dotfiles, "bin", "*"), Link: bin, Platforms: []string{"all"}},
+		{Target: filepath.Join(dotfiles, "**", "c"), Link: filepath.Join(root, "home", "nested"), Platforms: []string{"all"}},
+		{Target: filepath.Join(dotfiles, "*.py"), Link: bin, Platforms: []string{"all"}},
+	}}
+
+	p := NewPlan()
+	if err := m.Plan(st, p); err != nil {
+		t.Fatalf("unexpected error from Plan(): %v", err)
+	}
+
+	var got []links.Action
+	for _, a := range p.Actions {
+		if a.Kind != links.ActionMkdir {
+			got = append(got, a)
+		}
+	}
+	want := []struct {
+		kind   links.ActionKind
+		index  int
+		target string
+		link   string
+	}{
+		{links.ActionCreate, 0, filepath.Join(dotfiles, "bin", "a"), filepath.Join(bin, "a")},
+		{links.ActionCreate, 0, filepath.Join(dotfiles, "bin", "b"), filepath.Join(bin, "b")},
+		{links.ActionCreate, 0, filepath.Join(dotfiles, "bin", "sub"), filepath.Join(bin, "sub")},
+		{links.ActionCreate, 1, filepath.Join(dotfiles, "bin", "sub", "c"), filepath.Join(root, "home", "nested", "bin", "sub", "c")},
+		{links.ActionSkip, 2, filepath.Join(dotfiles, "*.py"), bin},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d actions, got %d: %+v", len(want), len(got), got)
+	}
+	for i, w := range want {
+		a := got[i]
+		if a.Kind != w.kind || a.Index != w.index || a.Target != w.target || a.Link != w.link {
+			t.Errorf("action %d = %v links[%d] %v -> %v, want %v links[%d] %v -> %v",
+				i, a.Kind, a.Index, a.Link, a.Target, w.kind, w.index, w.link, w.target)
+		}
+	}
+}
+
+func TestStatus_Glob(t *testing.T) {
+	root := string(filepath.Separator)
+	dotfiles := filepath.Join(root, "dotfiles")
+	bin := filepath.Join(root, "home", ".local", "bin")
+
+	fsys := globFS(t, dotfiles, filepath.Join("bin", "a"), filepath.Join("bin", "b"))
+	if err := fsys.MkdirAll(bin, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "gone"} {
+		if err := fsys.Symlink(filepath.Join(dotfiles, "bin", name), filepath.Join(bin, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// links to elsewhere are not orphans of the glob
+	if err := fsys.Symlink(filepath.Join(root, "usr", "bin", "env"), filepath.Join(bin, "env")); err != nil {
+		t.Fatal(err)
+	}
+
+	st := state.New(&state.TrovlOptions{})
+	st.FS = fsys
+
+	m := &Manifest{Links: []ManifestLink{{Target: filepath.Join(dotfiles, "bin", "*"), Link: bin, Platforms: []string{"all"}}}}
+	statuses, err := m.Status(st)
+	if err != nil {
+		t.Fatalf("unexpected error from Status(): %v", err)
+	}
+
+	want := map[string]links.LinkStatus{
+		filepath.Join(bin, "a"):    links.StatusLinked,
+		filepath.Join(bin, "b"):    links.StatusMissing,
+		filepath.Join(bin, "gone"): links.StatusOrphaned,
+	}
+	if len(statuses) != len(want) {
+		t.Fatalf("expected %d statuses, got %+v", len(want), statuses)
+	}
+	for _, st := range statuses {
+		if w, ok := want[st.Link]; !ok || st.Status != string(w) {
+			t.Errorf("status of %v = %q, want %q", st.Link, st.Status, w)
+		}
+	}
+}
+
+func TestPlan_GlobIgnore(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	root := string(filepath.Separator)
+	dotfiles := filepath.Join(root, "dotfiles")
+	bin := filepath.Join(root, "home", ".local", "bin")
+
+	fsys := globFS(t, dotfiles, "README.md", filepath.Join("bin", "a"), filepath.Join("bin", "a.swp"), filepath.Join("bin", "local-only"))
+	if err := fsys.WriteFile(filepath.Join(dotfiles, ".trovlignore"), []byte("*.swp\nREADME.md\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	st := state.New(&state.TrovlOptions{})
+	st.FS = fsys
+
+	m := &Manifest{
+		Ignore: []string{"local-*"},
+		Links: []ManifestLink{
+			{Target: filepath.Join(dotfiles, "*"), Link: filepath.Join(root, "home", "top"), Platforms: []string{"all"}},
+			{Target: filepath.Join(dotfiles, "bin", "*"), Link: bin, Platforms: []string{"all"}},
+		},
+	}
+	p := NewPlan()
+	if err := m.Plan(st, p); err != nil {
+		t.Fatalf("unexpected error from Plan(): %v", err)
+	}
+
+	var got []string
+	for _, a := range p.Actions {
+		if a.Kind == links.ActionCreate {
+			got = append(got, a.Target)
+		}
+	}
+	want := []string{filepath.Join(dotfiles, "bin"), filepath.Join(dotfiles, "bin", "a")}
+	if !slices.Equal(got, want) {
+		t.Errorf("planned links to %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,184 @@
+package manifests
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/sneha-afk/trovl/internal/state"
+	"github.com/sneha-afk/trovl/internal/utils"
+)
+
+// ErrOverlappingLinks is returned when links would interfere with each other if placed together,
+// e.g. the same link path declared twice, or a link placed inside another link to a directory.
+var ErrOverlappingLinks = errors.New("overlapping links")
+
+// declaration is a link as it applies to the current platform, with absolute paths.
+type declaration struct {
+	manifest string
+	index    int
+	target   string
+	link     string
+}
+
+func (d declaration) String() string {
+	return fmt.Sprintf("%vlinks[%d]", manifestPrefix(d.manifest), d.index)
+}
+
+// declarations returns the links of the manifest that apply to the current platform, leaving out
+// glob targets, whose links are only known once expanded. Links whose paths cannot be cleaned are
+// left out too, as they fail on their own when planned.
+func (m *Manifest) declarations(s *state.TrovlState) []declaration {
+	var isWSL = isWSL()
+	var decls []declaration
+
+	for i := range m.Links {
+		link := &m.Links[i]
+		linkToUse, ok := link.linkForPlatform(isWSL)
+		if !ok || utils.IsGlob(link.Target) {
+			continue
+		}
+		target, err := s.CleanPath(link.Target, false)
+		if err != nil {
+			continue
+		}
+		linkPath, err := s.CleanPath(linkToUse, false)
+		if err != nil {
+			continue
+		}
+		decls = append(decls, declaration{manifest: m.Path, index: i, target: target, link: linkPath})
+	}
+	return decls
+}
+
+// globOverlaps returns an error for each glob target of the manifest whose links would be placed
+// among its own matches, or whose matches are inside the directory its links are placed in. Globs
+// whose paths cannot be cleaned are left to fail when planned.
+func (m *Manifest) globOverlaps(s *state.TrovlState) []error {
+	var isWSL = isWSL()
+	var errs []error
+
+	for i := range m.Links {
+		link := &m.Links[i]
+		linkToUse, ok := link.linkForPlatform(isWSL)
+		if !ok || !utils.IsGlob(link.Target) {
+			continue
+		}
+		g, err := newGlob(s, link.Target, linkToUse)
+		if err != nil {
+			continue
+		}
+		if utils.IsWithin(g.linkDir, g.base) || utils.IsWithin(g.base, g.linkDir) {
+			d := declaration{manifest: m.Path, index: i}
+			errs = append(errs, fmt.Errorf("%w: %v: glob target %v and link directory %v contain each other", ErrOverlappingLinks, d, g.pattern, g.linkDir))
+		}
+	}
+	return errs
+}
+
+// CheckOverlaps checks that the links of the manifests, taken together, do not interfere with each
+// other: no link path is declared twice, no link is inside another link's path (which would be placed
+// through it, e.g. into the dotfiles a directory link points to), and no link's target is reached
+// through its own link path, directly or through a cycle of links. Glob targets must not place links
+// among their own matches. Every overlap found is returned. As paths depend on the environment, this
+// is checked when planning rather than parsing.
+func CheckOverlaps(s *state.TrovlState, ms ...*Manifest) error {
+	var decls []declaration
+	var errs []error
+	for _, m := range ms {
+		decls = append(decls, m.declarations(s)...)
+		errs = append(errs, m.globOverlaps(s)...)
+	}
+
+	byLink := make(map[string]int, len(decls))
+	for i, d := range decls {
+		if j, ok := byLink[d.link]; ok {
+			errs = append(errs, fmt.Errorf("%w: %v and %v: duplicate link path %v", ErrOverlappingLinks, decls[j], d, d.link))
+			continue
+		}
+		byLink[d.link] = i
+	}
+
+	// containing returns the declaration whose link path is path or its nearest parent, if any
+	containing := func(path string, inclusive bool) (int, bool) {
+		if !inclusive {
+			path = parentDir(path)
+		}
+		for ; path != ""; path = parentDir(path) {
+			if j, ok := byLink[path]; ok {
+				return j, true
+			}
+		}
+		return 0, false
+	}
+
+	// next[i] is the link the target of decls[i] is reached through, or -1
+	next := make([]int, len(decls))
+	for i, d := range decls {
+		if j, ok := containing(d.link, false); ok {
+			errs = append(errs, fmt.Errorf("%w: %v is nested inside the link path of %v (%v)", ErrOverlappingLinks, d, decls[j], decls[j].link))
+		}
+
+		next[i] = -1
+		if j, ok := containing(d.target, true); ok {
+			next[i] = j
+		}
+	}
+
+	errs = append(errs, cycles(decls, next)...)
+	return errors.Join(errs...)
+}
+
+// cycles returns an error for each cycle of links whose targets are reached through each other.
+func cycles(decls []declaration, next []int) []error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	var errs []error
+	seen := make([]int, len(decls))
+
+	for start := range decls {
+		var path []int
+		i := start
+		for i >= 0 && seen[i] == unvisited {
+			seen[i] = visiting
+			path = append(path, i)
+			i = next[i]
+		}
+
+		if i >= 0 && seen[i] == visiting {
+			// the walk came back around to a link on this path
+			var names []string
+			for k := len(path) - 1; k >= 0; k-- {
+				names = append([]string{decls[path[k]].String()}, names...)
+				if path[k] == i {
+					break
+				}
+			}
+			if len(names) == 1 {
+				d := decls[i]
+				errs = append(errs, fmt.Errorf("%w: %v: target %v is inside its own link path %v", ErrOverlappingLinks, d, d.target, d.link))
+			} else {
+				names = append(names, names[0])
+				errs = append(errs, fmt.Errorf("%w: %v form a cycle, each target is reached through the next link", ErrOverlappingLinks, strings.Join(names, " -> ")))
+			}
+		}
+
+		for _, k := range path {
+			seen[k] = visited
+		}
+	}
+	return errs
+}
+
+// parentDir returns the parent directory of path, or "" if path is a root.
+func parentDir(path string) string {
+	parent := filepath.Dir(path)
+	if parent == path {
+		return ""
+	}
+	return parent
+}
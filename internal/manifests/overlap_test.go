@@ -0,0 +1,170 @@
+package manifests
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sneha-afk/trovl/internal/state"
+	"github.com/sneha-afk/trovl/internal/utils"
+)
+
+func TestCheckOverlaps(t *testing.T) {
+	root := t.TempDir()
+	home := filepath.Join(root, "home")
+	dots := filepath.Join(root, "dotfiles")
+
+	link := func(target, path string) ManifestLink {
+		return ManifestLink{Target: filepath.Join(dots, target), Link: filepath.Join(home, path), Platforms: []string{"all"}}
+	}
+
+	tests := []struct {
+		name  string
+		links [][]ManifestLink // One manifest per element
+		want  []string         // Substrings of the error, none if it should succeed
+	}{
+		{
+			name: "independent links",
+			links: [][]ManifestLink{{
+				link("nvim", ".config/nvim"),
+				link("bashrc", ".bashrc"),
+				link("nvim-other", ".config/nvim-other"), // shares a prefix, but is not inside
+			}},
+		},
+		{
+			name: "duplicate link path",
+			links: [][]ManifestLink{{
+				link("bashrc", ".bashrc"),
+				link("zshrc", ".zshrc"),
+				link("bashrc.local", ".bashrc"),
+			}},
+			want: []string{"links[0] and links[2]: duplicate link path"},
+		},
+		{
+			name: "nested under a directory link",
+			links: [][]ManifestLink{{
+				link("nvim", ".config/nvim"),
+				link("init.lua", ".config/nvim/lua/init.lua"),
+			}},
+			want: []string{"links[1] is nested inside the link path of links[0]"},
+		},
+		{
+			name: "target inside its own link path",
+			links: [][]ManifestLink{{
+				{Target: filepath.Join(home, "app", "config"), Link: filepath.Join(home, "app"), Platforms: []string{"all"}},
+			}},
+			want: []string{"links[0]: target", "inside its own link path"},
+		},
+		{
+			name: "cycle",
+			links: [][]ManifestLink{{
+				{Target: filepath.Join(home, "b", "file"), Link: filepath.Join(home, "a"), Platforms: []string{"all"}},
+				link("unrelated", "c"),
+				{Target: filepath.Join(home, "a"), Link: filepath.Join(home, "b"), Platforms: []string{"all"}},
+			}},
+			want: []string{"links[0] -> links[2] -> links[0] form a cycle"},
+		},
+		{
+			name: "not applying to this platform",
+			links: [][]ManifestLink{{
+				link("bashrc", ".bashrc"),
+				{Target: filepath.Join(dots, "other"), Link: filepath.Join(home, ".bashrc"), Platforms: []string{"nonexistent"}},
+			}},
+		},
+		{
+			name: "glob next to its own links",
+			links: [][]ManifestLink{{
+				link(filepath.Join("bin", "*"), filepath.Join(".local", "bin")),
+				link("tool", filepath.Join(".local", "bin", "tool")), // placed among the glob's links, not through one
+			}},
+		},
+		{
+			name: "glob linking into its own matches",
+			links: [][]ManifestLink{{
+				{Target: filepath.Join(dots, "bin", "*"), Link: filepath.Join(dots, "bin", "linked"), Platforms: []string{"all"}},
+			}},
+			want: []string{"links[0]: glob target", "contain each other"},
+		},
+		{
+			name: "across manifests",
+			links: [][]ManifestLink{
+				{link("bashrc", ".bashrc")},
+				{link("zshrc", ".zshrc"), link("bashrc", ".bashrc")},
+			},
+			want: []string{"first: links[0] and second: links[1]: duplicate link path"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ms []*Manifest
+			for i, links := range tt.links {
+				ms = append(ms, &Manifest{Links: links, Path: []string{"first", "second"}[i]})
+			}
+			if len(ms) == 1 {
+				ms[0].Path = ""
+			}
+
+			err := CheckOverlaps(teststate, ms...)
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrOverlappingLinks) {
+				t.Fatalf("expected ErrOverlappingLinks, got %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("expected error to contain %q, got %v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestApply_Overlapping(t *testing.T) {
+	data := `{"links": [
+		{"target": "/dotfiles/nvim", "link": "/home/me/.config/nvim"},
+		{"target": "/dotfiles/init.lua", "link": "/home/me/.config/nvim/init.lua"}
+	]}`
+	m, err := Parse([]byte(data), "manifest.json")
+	if err != nil {
+		t.Fatalf("expected overlaps to be left to planning, got %v", err)
+	}
+
+	if err := m.Apply(teststate); !errors.Is(err, ErrOverlappingLinks) {
+		t.Errorf("expected error to match ErrOverlappingLinks, got %v", err)
+	}
+}
+
+func TestApply_InvalidPathKeepGoing(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", tmpDir)
+	t.Setenv("XDG_STATE_HOME", tmpDir)
+
+	target := filepath.Join(tmpDir, "actual_file")
+	if err := os.WriteFile(target, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := &Manifest{Links: []ManifestLink{
+		{Target: target, Link: filepath.Join(tmpDir, ".a"), Platforms: []string{"all"}},
+		{Target: target, Link: "${UNCLOSED/.b", Platforms: []string{"all"}},
+	}}
+
+	// a link whose path cannot be expanded is not an overlap, it fails on its own
+	if err := CheckOverlaps(teststate, m); err != nil {
+		t.Errorf("unexpected error from CheckOverlaps(): %v", err)
+	}
+
+	err := m.Apply(state.New(&state.TrovlOptions{KeepGoing: true}))
+	if !errors.Is(err, utils.ErrBadSubstitution) || errors.Is(err, ErrOverlappingLinks) {
+		t.Errorf("expected only the invalid link to fail, got %v", err)
+	}
+	if got, err := os.Readlink(filepath.Join(tmpDir, ".a")); err != nil || got != target {
+		t.Errorf("expected the valid link to be created, got %q, %v", got, err)
+	}
+}
@@ -0,0 +1,624 @@
+package manifests
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sneha-afk/trovl/internal/links"
+	"github.com/sneha-afk/trovl/internal/report"
+	"github.com/sneha-afk/trovl/internal/state"
+	"github.com/sneha-afk/trovl/internal/utils"
+	"github.com/sneha-afk/trovl/internal/vfs"
+)
+
+// PlanVersion is the version of the serialized plan format.
+const PlanVersion = 1
+
+// Plan is the ordered list of actions applying one or more manifests involves. It can be saved as
+// JSON and executed later, as long as the filesystem has not changed in the meantime.
+type Plan struct {
+	Version   int            `json:"version"`
+	CreatedAt time.Time      `json:"created_at"`
+	WorkDir   string         `json:"work_dir"`       // Relative paths in actions are relative to this
+	Root      string         `json:"root,omitempty"` // Root the links are placed inside, see TrovlOptions.Root
+	Home      string         `json:"home,omitempty"` // Home directory paths were expanded against, if not the user's
+	Actions   []links.Action `json:"actions"`
+
+	sim  *vfs.Overlay // The filesystem as the actions planned so far would leave it
+	done []bool       // Which actions Execute has carried out
+}
+
+// ErrInterrupted is returned by Execute when the state's context is canceled before every action
+// was carried out. The rest can be executed later with Remaining.
+var ErrInterrupted = errors.New("interrupted")
+
+// ErrPlanMismatch is returned when a plan is executed with a different Root or Home than it was
+// computed with, as its paths would then refer to other files.
+var ErrPlanMismatch = errors.New("plan was computed for a different root or home")
+
+func NewPlan() *Plan {
+	wd, _ := os.Getwd()
+	return &Plan{
+		Version:   PlanVersion,
+		CreatedAt: time.Now(),
+		WorkDir:   wd,
+	}
+}
+
+// ProtectRoots adds the directory of each manifest, or the git repository it is in, to the
+// directories no link is placed in, so applying a manifest cannot replace the files it is kept
+// with. A directory that is or contains the home directory is not added, nor is anything with a
+// Root, where links cannot reach the manifests anyway.
+func ProtectRoots(s *state.TrovlState, ms ...*Manifest) {
+	if s.Options.Root != "" {
+		return
+	}
+	home, _ := s.Options.PathEnv().HomeDir()
+	for _, m := range ms {
+		if m.Path == "" {
+			continue
+		}
+		dir, err := filepath.Abs(filepath.Dir(m.Path))
+		if err != nil {
+			continue
+		}
+		root := repoRoot(dir)
+		if home != "" && utils.IsWithin(home, root) {
+			continue
+		}
+		if !slices.Contains(s.Options.ManifestRoots, root) {
+			s.Options.ManifestRoots = append(s.Options.ManifestRoots, root)
+		}
+	}
+}
+
+// repoRoot returns the root of the git repository dir is in, or dir itself if it is in none.
+func repoRoot(dir string) string {
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Lstat(filepath.Join(d, ".git")); err == nil {
+			return d
+		}
+		if filepath.Dir(d) == d {
+			return dir
+		}
+	}
+}
+
+// Plan computes the actions applying the manifest involves, appending them to the plan.
+// Nothing is modified and the user is not prompted. Each link is planned against the filesystem as
+// the actions before it would leave it, e.g. a link path declared twice is planned as unchanged.
+// With KeepGoing, links that cannot be planned are reported as failed and the rest are still planned.
+func (m *Manifest) Plan(s *state.TrovlState, p *Plan) error {
+	var isWSL = isWSL()
+	var errs []error
+
+	if p.sim == nil {
+		p.sim = vfs.NewOverlay(s.FS)
+		p.Root, p.Home = s.Options.Root, s.Options.Home
+	}
+	ps := s.WithFS(p.sim)
+
+	for i := range m.Links {
+		if err := s.Err(); err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+		link := &m.Links[i]
+
+		linkToUse, ok := link.linkForPlatform(isWSL)
+		if !ok {
+			p.Actions = append(p.Actions, links.Action{
+				Kind:     links.ActionSkip,
+				Manifest: m.Path,
+				Index:    i,
+				Target:   link.Target,
+				Link:     link.Link,
+				Reason:   "does not apply to current platform",
+			})
+			continue
+		}
+
+		var a links.Action
+		var err error
+		if utils.IsGlob(link.Target) {
+			// a glob target fans out into a link per match, each added to the plan as it is planned
+			err = p.planGlob(ps, m, i, link.Target, linkToUse)
+		} else {
+			if link.CreateTarget != "" {
+				err = p.planCreateTarget(ps, m, i, link.Target, link.CreateTarget)
+			}
+			if err == nil && link.Method == MethodTemplate {
+				a, err = planRender(ps, link.Target, linkToUse, m.Vars)
+			} else if err == nil {
+				a, err = planSymlink(ps, link.Target, linkToUse)
+			}
+			if errors.Is(err, links.ErrTargetMissing) && link.Optional {
+				a = links.Action{Kind: links.ActionSkip, Target: link.Target, Link: linkToUse, Reason: "optional target does not exist"}
+				err = nil
+			}
+			if err == nil {
+				a.Manifest = m.Path
+				a.Index = i
+				err = p.add(a)
+			}
+		}
+		if err != nil {
+			failed := links.Action{Kind: links.ActionCreate, Manifest: m.Path, Index: i, Target: link.Target, Link: linkToUse}
+			if link.Method == MethodTemplate {
+				failed.Kind, failed.Template = links.ActionRender, true
+			}
+			s.Report(failed.Result(report.OutcomeFailed, err))
+
+			err = fmt.Errorf("%vlinks[%d]: %w", manifestPrefix(m.Path), i, err)
+
+			if !s.Options.KeepGoing {
+				return err
+			}
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func planSymlink(s *state.TrovlState, targetPath, symlinkPath string) (links.Action, error) {
+	targetPath, err := s.CleanPath(targetPath, s.Options.UseRelative)
+	if err != nil {
+		return links.Action{}, fmt.Errorf("invalid path (target): %w", err)
+	}
+	symlinkPath, err = s.CleanPath(symlinkPath, s.Options.UseRelative)
+	if err != nil {
+		return links.Action{}, fmt.Errorf("invalid path (symlink): %w", err)
+	}
+	return links.PlanLink(s, targetPath, symlinkPath)
+}
+
+// planCreateTarget adds an action creating the target of a link as an empty file or directory,
+// if it does not exist yet.
+func (p *Plan) planCreateTarget(s *state.TrovlState, m *Manifest, i int, targetPath, kind string) error {
+	targetPath, err := s.CleanPath(targetPath, false)
+	if err != nil {
+		return fmt.Errorf("invalid path (target): %w", err)
+	}
+	existing, err := links.TakeSnapshot(s.FS, targetPath)
+	if err != nil {
+		return fmt.Errorf("could not get target info: %w", err)
+	}
+	if existing.Exists {
+		return nil
+	}
+
+	a := links.Action{
+		Kind:     links.ActionCreateTarget,
+		Manifest: m.Path,
+		Index:    i,
+		Link:     targetPath,
+		Type:     links.LinkFile,
+		Existing: existing,
+	}
+	if kind == CreateTargetDir {
+		a.Type = links.LinkDirectory
+	}
+	return p.add(a)
+}
+
+func planRender(s *state.TrovlState, targetPath, outPath string, vars map[string]string) (links.Action, error) {
+	data := TemplateData{Vars: vars, Host: GetHostFacts(s)}
+	status, err := links.GetRenderStatus(s, targetPath, outPath, data)
+	if err != nil {
+		return links.Action{}, err
+	}
+
+	targetPath, _ = s.CleanPath(targetPath, false)
+	outPath, _ = s.CleanPath(outPath, false)
+	if err := links.CheckLinkPath(s, outPath); err != nil {
+		return links.Action{}, err
+	}
+	existing, err := links.TakeSnapshot(s.FS, outPath)
+	if err != nil {
+		return links.Action{}, fmt.Errorf("could not get output info: %w", err)
+	}
+
+	a := links.Action{
+		Kind:     links.ActionRender,
+		Target:   targetPath,
+		Link:     outPath,
+		Reason:   string(status),
+		Vars:     vars,
+		Template: true,
+		Existing: existing,
+	}
+	switch status {
+	case links.RenderClean:
+		a.Kind = links.ActionUnchanged
+	case links.RenderModified, links.RenderUntracked:
+		if s.Options.OverwriteNo {
+			a.Kind = links.ActionDeclined
+			a.Reason = fmt.Sprintf("existing file is %v, not overwriting", status)
+		} else {
+			a.Confirm = !s.Options.OverwriteYes
+		}
+	}
+	return a, nil
+}
+
+// add appends an action, preceded by creating its parent directory if that does not exist yet,
+// and simulates them so later actions are planned against their effects.
+func (p *Plan) add(a links.Action) error {
+	switch a.Kind {
+	case links.ActionCreate, links.ActionRender:
+		parent := filepath.Dir(a.Link)
+		info, err := utils.GetPathInfoFS(p.sim, parent)
+		if err != nil {
+			return fmt.Errorf("could not get parent directory info: %w", err)
+		}
+		if !info.Exists {
+			mkdir := links.Action{
+				Kind:     links.ActionMkdir,
+				Manifest: a.Manifest,
+				Index:    a.Index,
+				Link:     parent,
+			}
+			if err := links.Simulate(p.sim, mkdir); err != nil {
+				return err
+			}
+			p.Actions = append(p.Actions, mkdir)
+		}
+	}
+
+	if err := links.Simulate(p.sim, a); err != nil {
+		return err
+	}
+	p.Actions = append(p.Actions, a)
+	return nil
+}
+
+// Render writes a human-readable summary of the plan.
+func (p *Plan) Render(w io.Writer) {
+	counts := map[links.ActionKind]int{}
+	for _, a := range p.Actions {
+		counts[a.Kind]++
+
+		symbol := " "
+		switch a.Kind {
+		case links.ActionMkdir, links.ActionCreateTarget, links.ActionCreate:
+			symbol = "+"
+		case links.ActionReplaceLink, links.ActionBackupReplace, links.ActionRender:
+			symbol = "~"
+		case links.ActionUnchanged:
+			symbol = "="
+		case links.ActionDeclined, links.ActionSkip:
+			symbol = "-"
+		}
+
+		source := ""
+		if a.Index >= 0 {
+			source = fmt.Sprintf("  [%vlinks[%d]]", manifestPrefix(a.Manifest), a.Index)
+		}
+		confirm := ""
+		if a.Confirm {
+			confirm = " (will ask)"
+		}
+		fmt.Fprintf(w, "%s %-14s %s%s%s\n", symbol, a.Kind, a.Describe(), confirm, source)
+	}
+
+	changes := counts[links.ActionCreateTarget] + counts[links.ActionCreate] + counts[links.ActionReplaceLink] + counts[links.ActionBackupReplace] + counts[links.ActionRender]
+	fmt.Fprintf(w, "\nPlan: %d to change (%d create target, %d create, %d replace, %d back up and replace, %d render), %d unchanged, %d declined, %d skipped.\n",
+		changes, counts[links.ActionCreateTarget], counts[links.ActionCreate], counts[links.ActionReplaceLink], counts[links.ActionBackupReplace], counts[links.ActionRender],
+		counts[links.ActionUnchanged], counts[links.ActionDeclined], counts[links.ActionSkip])
+}
+
+// Report emits a record for each action as planned, without executing anything.
+func (p *Plan) Report(s *state.TrovlState) {
+	for _, a := range p.Actions {
+		if a.Preparatory() {
+			continue
+		}
+		outcome := report.OutcomePlanned
+		switch a.Kind {
+		case links.ActionUnchanged:
+			outcome = report.OutcomeUnchanged
+		case links.ActionDeclined:
+			outcome = report.OutcomeDeclined
+		case links.ActionSkip:
+			outcome = report.OutcomeSkipped
+		}
+		s.Report(a.Result(outcome, nil))
+	}
+}
+
+func manifestPrefix(path string) string {
+	if path == "" {
+		return ""
+	}
+	return path + ": "
+}
+
+// Save writes the plan as JSON to path.
+func (p *Plan) Save(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not marshal plan: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("could not write plan: %w", err)
+	}
+	return nil
+}
+
+// LoadPlan reads a plan previously written by Save.
+func LoadPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read plan file: %w", err)
+	}
+
+	p := &Plan{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("could not unmarshal plan: %w", err)
+	}
+	if p.Version != PlanVersion {
+		return nil, fmt.Errorf("unsupported plan version %d (expected %d)", p.Version, PlanVersion)
+	}
+	return p, nil
+}
+
+// Adopt sets the Root and Home of opts to those the plan was computed with, unless either is set
+// already. The state's filesystem must be reset afterwards, see TrovlState.ResetFS.
+func (p *Plan) Adopt(opts *state.TrovlOptions) {
+	if opts.Root == "" && opts.Home == "" {
+		opts.Root, opts.Home = p.Root, p.Home
+	}
+}
+
+// Verify checks that the plan is executed with the Root and Home it was computed with, and that
+// nothing it acts on on the state's filesystem has changed since. Paths an earlier action changes
+// are only checked once that action has been executed.
+func (p *Plan) Verify(s *state.TrovlState) error {
+	if p.Root != s.Options.Root || p.Home != s.Options.Home {
+		return fmt.Errorf("%w: computed with root %q and home %q, executed with root %q and home %q",
+			ErrPlanMismatch, p.Root, p.Home, s.Options.Root, s.Options.Home)
+	}
+	if wd, _ := os.Getwd(); p.WorkDir != "" && wd != p.WorkDir {
+		for _, a := range p.Actions {
+			if !filepath.IsAbs(a.Link) || (a.Target != "" && !filepath.IsAbs(a.Target)) {
+				return fmt.Errorf("plan has relative paths and was computed in %v, run it from there", p.WorkDir)
+			}
+		}
+	}
+
+	var errs []error
+	for _, a := range p.Actions {
+		if a.Kind == links.ActionUnchanged || a.Kind == links.ActionDeclined || a.Existing.Simulated {
+			continue
+		}
+		if err := a.Verify(s.FS); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// dependencies returns, for each action, the earlier actions that must be carried out before it:
+// those on the same path, on a parent directory of its link or target (which may be reached through
+// a link placed earlier), or on a path inside its link. Actions without any can run in any order.
+func (p *Plan) dependencies() [][]int {
+	lastAt := map[string]int{}    // Last action on exactly this path
+	lastUnder := map[string]int{} // Last action on a path inside this directory
+	deps := make([][]int, len(p.Actions))
+
+	abs := func(path string) string {
+		if path == "" || filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(p.WorkDir, path)
+	}
+	ancestors := func(path string, fn func(dir string)) {
+		for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+			fn(dir)
+			if filepath.Dir(dir) == dir {
+				return
+			}
+		}
+	}
+
+	for i, a := range p.Actions {
+		link, target := abs(a.Link), abs(a.Target)
+		depend := func(m map[string]int, path string) {
+			if j, ok := m[path]; ok && !slices.Contains(deps[i], j) {
+				deps[i] = append(deps[i], j)
+			}
+		}
+
+		depend(lastAt, link)
+		depend(lastUnder, link)
+		ancestors(link, func(dir string) { depend(lastAt, dir) })
+		if target != "" {
+			depend(lastAt, target)
+			ancestors(target, func(dir string) { depend(lastAt, dir) })
+		}
+
+		lastAt[link] = i
+		ancestors(link, func(dir string) { lastUnder[dir] = i })
+	}
+	return deps
+}
+
+// Execute carries out the actions of the plan, up to Jobs of them at once. Actions that depend on
+// each other (see dependencies) are carried out in order, and results are reported in plan order.
+// Declined actions are not errors. Without KeepGoing, no more actions are started once one fails.
+// With KeepGoing, every action is attempted and the errors of those that failed are joined.
+// If the state's context is canceled, the actions being carried out are finished and an error
+// wrapping ErrInterrupted is returned.
+func (p *Plan) Execute(s *state.TrovlState) error {
+	var numActions = len(p.Actions)
+	var deps = p.dependencies()
+	var jobs = s.Jobs()
+
+	es := s
+	if jobs > 1 {
+		es = s.WithFS(vfs.Synchronized(s.FS))
+	}
+
+	p.done = make([]bool, numActions)
+	results := make([]error, numActions)
+	finished := make([]chan struct{}, numActions)
+	for i := range finished {
+		finished[i] = make(chan struct{})
+	}
+
+	stopped := make(chan struct{})
+	var stopOnce sync.Once
+	stop := func() { stopOnce.Do(func() { close(stopped) }) }
+
+	queue := make(chan int)
+	go func() {
+		defer close(queue)
+		for i := range numActions {
+			select {
+			case queue <- i:
+			case <-stopped:
+				// never started
+				for j := i; j < numActions; j++ {
+					close(finished[j])
+				}
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for range min(jobs, numActions) {
+		wg.Go(func() {
+			for i := range queue {
+				for _, j := range deps[i] {
+					<-finished[j]
+				}
+				p.run(es, i, results, stopped, stop)
+				close(finished[i])
+			}
+		})
+	}
+
+	var errs []error
+	for i, a := range p.Actions {
+		<-finished[i]
+		if !p.done[i] {
+			continue
+		}
+		err := results[i]
+
+		// parent directories and targets are reported through the links they are created for
+		if !a.Preparatory() || err != nil {
+			s.Report(a.Result(a.Outcome(s, err), err))
+		}
+
+		if errors.Is(err, links.ErrUnchanged) {
+			s.Logger.Info(fmt.Sprintf("Link unchanged [%v/%v]", i+1, numActions), "target", a.Target, "link", a.Link)
+			continue
+		}
+		if errors.Is(err, links.ErrDeclined) {
+			continue
+		}
+		if err != nil {
+			if a.Index >= 0 {
+				err = fmt.Errorf("%vlinks[%d]: %w", manifestPrefix(a.Manifest), a.Index, err)
+			}
+			if s.Options.KeepGoing {
+				s.Logger.Error(fmt.Sprintf("Failed to apply [%v/%v], continuing", i+1, numActions), "error", err)
+			}
+			errs = append(errs, err)
+			continue
+		}
+
+		if a.Kind == links.ActionSkip {
+			s.Logger.Warn(fmt.Sprintf("links[%d]: %v, skipping", a.Index, a.Reason), "linkIndex", a.Index, "target", a.Target)
+		} else if !s.Options.DryRun {
+			s.LogSuccess(fmt.Sprintf("Applied [%v/%v]", i+1, numActions), "action", a.Kind, "target", a.Target, "link", a.Link)
+		}
+	}
+	wg.Wait()
+
+	if err := s.Err(); err != nil && slices.Contains(p.done, false) {
+		errs = append(errs, p.interrupted(s, err))
+	}
+	return errors.Join(errs...)
+}
+
+// run carries out the i-th action unless execution was stopped, recording its result. Failures stop
+// execution unless KeepGoing, as does the state's context being canceled.
+func (p *Plan) run(s *state.TrovlState, i int, results []error, stopped <-chan struct{}, stop func()) {
+	if ctx := s.Err(); ctx != nil {
+		stop()
+		return
+	}
+	select {
+	case <-stopped:
+		return
+	default:
+	}
+
+	var err error
+	switch a := p.Actions[i]; a.Kind {
+	case links.ActionMkdir:
+		s.LogLink(a.Describe())
+		err = s.FS.MkdirAll(a.Link, 0755)
+	case links.ActionCreateTarget:
+		if err = a.Verify(s.FS); err == nil {
+			s.LogLink(a.Describe())
+			err = links.PlaceTarget(s, a)
+		}
+	case links.ActionRender:
+		if err = a.Verify(s.FS); err == nil {
+			err = links.Render(s, a.Target, a.Link, TemplateData{Vars: a.Vars, Host: GetHostFacts(s)})
+		}
+	default:
+		err = links.Execute(s, a)
+	}
+
+	if ctxErr := s.Err(); ctxErr != nil && errors.Is(err, ctxErr) {
+		// interrupted while asking the user, so nothing was done
+		stop()
+		return
+	}
+	results[i], p.done[i] = err, true
+	if err != nil && !errors.Is(err, links.ErrUnchanged) && !errors.Is(err, links.ErrDeclined) && !s.Options.KeepGoing {
+		stop()
+	}
+}
+
+// interrupted reports every action not carried out as not attempted, and returns the error
+// Execute stops with.
+func (p *Plan) interrupted(s *state.TrovlState, cause error) error {
+	rest := p.Remaining().Actions
+	for _, a := range rest {
+		if !a.Preparatory() {
+			s.Report(a.Result(report.OutcomeInterrupted, nil))
+		}
+	}
+	s.Logger.Warn(fmt.Sprintf("Interrupted, %d of %d actions were not carried out", len(rest), len(p.Actions)))
+	return fmt.Errorf("%w before %v: %w", ErrInterrupted, rest[0].Describe(), cause)
+}
+
+// Remaining returns a plan of the actions Execute has not carried out, e.g. as it was interrupted.
+func (p *Plan) Remaining() *Plan {
+	rest := &Plan{
+		Version:   p.Version,
+		CreatedAt: p.CreatedAt,
+		WorkDir:   p.WorkDir,
+		Root:      p.Root,
+		Home:      p.Home,
+	}
+	for i, a := range p.Actions {
+		if i >= len(p.done) || !p.done[i] {
+			rest.Actions = append(rest.Actions, a)
+		}
+	}
+	return rest
+}
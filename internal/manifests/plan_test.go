@@ -0,0 +1,532 @@
+package manifests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/sneha-afk/trovl/internal/links"
+	"github.com/sneha-afk/trovl/internal/report"
+	"github.com/sneha-afk/trovl/internal/state"
+	"github.com/sneha-afk/trovl/internal/vfs"
+)
+
+func TestPlan(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", tmpDir)
+	t.Setenv("XDG_STATE_HOME", tmpDir)
+
+	target := filepath.Join(tmpDir, "actual_file")
+	other := filepath.Join(tmpDir, "other_file")
+	for _, f := range []string{target, other} {
+		if err := os.WriteFile(f, []byte("content"), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+	if err := os.Symlink(target, filepath.Join(tmpDir, "correct")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+	if err := os.Symlink(other, filepath.Join(tmpDir, "elsewhere")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "ordinary"), []byte("mine"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	link := func(path string) ManifestLink {
+		return ManifestLink{Target: target, Link: filepath.Join(tmpDir, path), Platforms: []string{"all"}}
+	}
+	m := &Manifest{Links: []ManifestLink{
+		link("new"),
+		link(filepath.Join("nested", "dir", "new")),
+		link(filepath.Join("nested", "dir", "new2")),
+		link("correct"),
+		link("elsewhere"),
+		link("ordinary"),
+		{Target: target, Link: filepath.Join(tmpDir, "skipped"), Platforms: []string{differentOS}},
+	}, Path: "manifest.json"}
+
+	tests := []struct {
+		name  string
+		opts  state.TrovlOptions
+		kinds []links.ActionKind
+	}{
+		{
+			name: "prompts left to apply",
+			opts: state.TrovlOptions{Force: true},
+			kinds: []links.ActionKind{
+				links.ActionCreate, links.ActionMkdir, links.ActionCreate, links.ActionCreate,
+				links.ActionUnchanged, links.ActionReplaceLink, links.ActionBackupReplace, links.ActionSkip,
+			},
+		},
+		{
+			name: "declined by options",
+			opts: state.TrovlOptions{OverwriteNo: true, BackupNo: true},
+			kinds: []links.ActionKind{
+				links.ActionCreate, links.ActionMkdir, links.ActionCreate, links.ActionCreate,
+				links.ActionUnchanged, links.ActionDeclined, links.ActionDeclined, links.ActionSkip,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPlan()
+			if err := m.Plan(state.New(&tt.opts), p); err != nil {
+				t.Fatalf("unexpected error from Plan(): %v", err)
+			}
+			if len(p.Actions) != len(tt.kinds) {
+				t.Fatalf("expected %d actions, got %d: %+v", len(tt.kinds), len(p.Actions), p.Actions)
+			}
+			for i, a := range p.Actions {
+				if a.Kind != tt.kinds[i] {
+					t.Errorf("actions[%d]: expected %s, got %s", i, tt.kinds[i], a.Kind)
+				}
+				if a.Manifest != "manifest.json" {
+					t.Errorf("actions[%d]: expected manifest to be recorded, got %q", i, a.Manifest)
+				}
+				wantConfirm := !tt.opts.OverwriteYes && !tt.opts.BackupYes && (a.Kind == links.ActionReplaceLink || a.Kind == links.ActionBackupReplace)
+				if a.Confirm != wantConfirm {
+					t.Errorf("actions[%d]: expected confirm %v, got %v", i, wantConfirm, a.Confirm)
+				}
+			}
+		})
+	}
+
+	if _, err := os.Lstat(filepath.Join(tmpDir, "new")); !os.IsNotExist(err) {
+		t.Errorf("planning should not modify the filesystem")
+	}
+}
+
+func TestPlan_Sequential(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	root := string(filepath.Separator)
+	dotfiles := filepath.Join(root, "dotfiles")
+	home := filepath.Join(root, "home")
+
+	fsys := vfs.NewMemFS()
+	if err := fsys.MkdirAll(dotfiles, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsys.MkdirAll(home, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b"} {
+		if err := fsys.WriteFile(filepath.Join(dotfiles, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	link := func(target, path string) ManifestLink {
+		return ManifestLink{Target: filepath.Join(dotfiles, target), Link: filepath.Join(home, path), Platforms: []string{"all"}}
+	}
+	m := &Manifest{Links: []ManifestLink{
+		link("a", filepath.Join("new", "a")),
+		link("b", filepath.Join("new", "b")), // the parent directory is already planned
+		link("a", filepath.Join("new", "a")), // declared twice
+		link("b", filepath.Join("new", "a")), // declared again, pointing elsewhere
+	}}
+
+	st := state.New(&state.TrovlOptions{})
+	st.FS = fsys
+
+	p := NewPlan()
+	if err := m.Plan(st, p); err != nil {
+		t.Fatalf("unexpected error from Plan(): %v", err)
+	}
+
+	want := []links.ActionKind{
+		links.ActionMkdir, links.ActionCreate, links.ActionCreate, links.ActionUnchanged, links.ActionReplaceLink,
+	}
+	if len(p.Actions) != len(want) {
+		t.Fatalf("expected %d actions, got %d: %+v", len(want), len(p.Actions), p.Actions)
+	}
+	for i, a := range p.Actions {
+		if a.Kind != want[i] {
+			t.Errorf("actions[%d]: expected %s, got %s", i, want[i], a.Kind)
+		}
+		if wantSimulated := i >= 3; a.Existing.Simulated != wantSimulated {
+			t.Errorf("actions[%d]: expected simulated %v, got %v", i, wantSimulated, a.Existing.Simulated)
+		}
+	}
+
+	if _, err := fsys.Lstat(filepath.Join(home, "new")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("planning should not modify the filesystem")
+	}
+}
+
+func TestPlan_LinkRoots(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	root := string(filepath.Separator)
+	dotfiles := filepath.Join(root, "dotfiles")
+	home := filepath.Join(root, "home")
+
+	fsys := vfs.NewMemFS()
+	if err := fsys.MkdirAll(dotfiles, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsys.WriteFile(filepath.Join(dotfiles, "a"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Manifest{Links: []ManifestLink{
+		{Target: filepath.Join(dotfiles, "a"), Link: filepath.Join(home, "a"), Platforms: []string{"all"}},
+		{Target: filepath.Join(dotfiles, "a"), Link: filepath.Join(root, "opt", "a"), Platforms: []string{"all"}},
+		{Target: filepath.Join(dotfiles, "a"), Link: filepath.Join(root, "opt", "b"), Platforms: []string{"all"}, Method: MethodTemplate},
+	}}
+
+	st := state.New(&state.TrovlOptions{LinkRoots: []string{home}, KeepGoing: true})
+	st.FS = fsys
+
+	p := NewPlan()
+	err := m.Plan(st, p)
+	if !errors.Is(err, links.ErrOutsideRoots) {
+		t.Fatalf("expected ErrOutsideRoots from Plan(), got %v", err)
+	}
+	if len(p.Actions) != 2 || p.Actions[1].Link != filepath.Join(home, "a") {
+		t.Errorf("expected only the link inside the link roots to be planned, got %+v", p.Actions)
+	}
+
+	st.Options.AllowOutsideHome = true
+	if err := m.Plan(st, NewPlan()); err != nil {
+		t.Errorf("unexpected error from Plan() with AllowOutsideHome: %v", err)
+	}
+}
+
+func TestPlan_SaveLoadRoot(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", tmpDir)
+	t.Setenv("XDG_STATE_HOME", tmpDir)
+
+	root := filepath.Join(tmpDir, "image")
+	if err := os.MkdirAll(filepath.Join(root, "dotfiles"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "dotfiles", "rc"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	home := filepath.Join(tmpDir, "me") // outside the root, so a link placed on the host would show
+	m := &Manifest{Links: []ManifestLink{{Target: "/dotfiles/rc", Link: "~/.rc", Platforms: []string{"all"}}}}
+
+	planPath := filepath.Join(tmpDir, "plan.json")
+	p := NewPlan()
+	if err := m.Plan(state.New(&state.TrovlOptions{Root: root, Home: home}), p); err != nil {
+		t.Fatalf("unexpected error from Plan(): %v", err)
+	}
+	if err := p.Save(planPath); err != nil {
+		t.Fatalf("unexpected error from Save(): %v", err)
+	}
+	loaded, err := LoadPlan(planPath)
+	if err != nil {
+		t.Fatalf("unexpected error from LoadPlan(): %v", err)
+	}
+
+	other := state.New(&state.TrovlOptions{Root: filepath.Join(tmpDir, "other")})
+	if err := loaded.Verify(other); !errors.Is(err, ErrPlanMismatch) {
+		t.Errorf("expected ErrPlanMismatch with another root, got %v", err)
+	}
+
+	st := state.New(&state.TrovlOptions{})
+	loaded.Adopt(st.Options)
+	st.ResetFS()
+	if err := loaded.Verify(st); err != nil {
+		t.Fatalf("unexpected error from Verify(): %v", err)
+	}
+	if err := loaded.Execute(st); err != nil {
+		t.Fatalf("unexpected error from Execute(): %v", err)
+	}
+	if got, err := os.Readlink(filepath.Join(root, home, ".rc")); err != nil || got != "/dotfiles/rc" {
+		t.Errorf("expected the link inside the root, got %q, %v", got, err)
+	}
+	if _, err := os.Lstat(filepath.Join(home, ".rc")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected nothing to be placed on the host, got %v", err)
+	}
+}
+
+func TestPlan_SaveLoadExecute(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", tmpDir)
+	t.Setenv("XDG_STATE_HOME", tmpDir)
+
+	target := filepath.Join(tmpDir, "actual_file")
+	if err := os.WriteFile(target, []byte("content"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	symlink := filepath.Join(tmpDir, "sub", "symlink")
+	m := &Manifest{Links: []ManifestLink{{Target: target, Link: symlink, Platforms: []string{"all"}}}}
+
+	planPath := filepath.Join(tmpDir, "plan.json")
+	p := NewPlan()
+	if err := m.Plan(teststate, p); err != nil {
+		t.Fatalf("unexpected error from Plan(): %v", err)
+	}
+	if err := p.Save(planPath); err != nil {
+		t.Fatalf("unexpected error from Save(): %v", err)
+	}
+
+	loaded, err := LoadPlan(planPath)
+	if err != nil {
+		t.Fatalf("unexpected error from LoadPlan(): %v", err)
+	}
+	if len(loaded.Actions) != len(p.Actions) {
+		t.Fatalf("expected %d actions after loading, got %d", len(p.Actions), len(loaded.Actions))
+	}
+	if err := loaded.Verify(teststate); err != nil {
+		t.Fatalf("unexpected error from Verify(): %v", err)
+	}
+	if err := loaded.Execute(teststate); err != nil {
+		t.Fatalf("unexpected error from Execute(): %v", err)
+	}
+	if dest, err := os.Readlink(symlink); err != nil || dest != target {
+		t.Errorf("expected symlink to %v, got %v (err: %v)", target, dest, err)
+	}
+
+	// the same plan is now stale, as something exists where it expected nothing
+	if err := loaded.Verify(teststate); !errors.Is(err, links.ErrPlanStale) {
+		t.Errorf("expected ErrPlanStale, got %v", err)
+	}
+
+	if err := os.WriteFile(planPath, []byte(`{"version":99,"actions":[]}`), 0644); err != nil {
+		t.Fatalf("failed to write plan: %v", err)
+	}
+	if _, err := LoadPlan(planPath); err == nil {
+		t.Errorf("expected error loading a plan of an unsupported version")
+	}
+}
+
+func TestPlan_ExecuteInterrupted(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", tmpDir)
+	t.Setenv("XDG_STATE_HOME", tmpDir)
+
+	target := filepath.Join(tmpDir, "actual_file")
+	ordinary := filepath.Join(tmpDir, "ordinary")
+	for _, f := range []string{target, ordinary} {
+		if err := os.WriteFile(f, []byte("content"), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	link := func(path string) ManifestLink {
+		return ManifestLink{Target: target, Link: path, Platforms: []string{"all"}}
+	}
+	m := &Manifest{Links: []ManifestLink{
+		link(filepath.Join(tmpDir, "first")),
+		link(ordinary),
+		link(filepath.Join(tmpDir, "last")),
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	st := state.New(&state.TrovlOptions{})
+	st.Context = ctx
+	st.Resolver = state.ResolverFunc(func(state.Conflict) (bool, error) {
+		// interrupted while the user is asked about the ordinary file
+		cancel()
+		return true, nil
+	})
+
+	p := NewPlan()
+	if err := m.Plan(st, p); err != nil {
+		t.Fatalf("unexpected error from Plan(): %v", err)
+	}
+	if err := p.Execute(st); !errors.Is(err, ErrInterrupted) {
+		t.Fatalf("expected ErrInterrupted, got %v", err)
+	}
+
+	if _, err := os.Lstat(filepath.Join(tmpDir, "first")); err != nil {
+		t.Errorf("expected the link before the interruption to be placed: %v", err)
+	}
+	if data, err := os.ReadFile(ordinary); err != nil || string(data) != "content" {
+		t.Errorf("expected the file being asked about to be left alone, got %q (%v)", data, err)
+	}
+	if _, err := os.Lstat(filepath.Join(tmpDir, "last")); !os.IsNotExist(err) {
+		t.Errorf("expected the link after the interruption not to be placed")
+	}
+	if got := st.Summary[report.OutcomeInterrupted]; got != 2 {
+		t.Errorf("expected 2 links reported as interrupted, got %d", got)
+	}
+
+	rest := p.Remaining()
+	if len(rest.Actions) != 2 || rest.Actions[0].Link != ordinary {
+		t.Fatalf("expected the remaining actions to start at the interrupted one, got %+v", rest.Actions)
+	}
+	if err := rest.Verify(teststate); err != nil {
+		t.Errorf("expected the remaining actions to still apply: %v", err)
+	}
+}
+
+func TestPlan_Dependencies(t *testing.T) {
+	root := string(filepath.Separator)
+	path := func(elem ...string) string { return filepath.Join(append([]string{root}, elem...)...) }
+
+	p := &Plan{Actions: []links.Action{
+		{Kind: links.ActionMkdir, Link: path("home", "a")},
+		{Kind: links.ActionCreate, Target: path("dots", "1"), Link: path("home", "a", "1")},
+		{Kind: links.ActionCreate, Target: path("dots", "2"), Link: path("home", "b")},
+		{Kind: links.ActionReplaceLink, Target: path("dots", "3"), Link: path("home", "a", "1")},
+		{Kind: links.ActionCreate, Target: path("home", "b", "x"), Link: path("home", "c")},
+		{Kind: links.ActionReplaceLink, Target: path("dots", "4"), Link: path("home", "a")},
+	}}
+
+	want := [][]int{
+		nil,
+		{0},    // inside the directory created before it
+		nil,    // independent
+		{1, 0}, // same path, replacing the link created before it
+		{2},    // target reached through a link placed before it
+		{0, 3}, // same path as the directory, which an earlier link is inside of
+	}
+	got := p.dependencies()
+	for i := range want {
+		if !slices.Equal(got[i], want[i]) {
+			t.Errorf("actions[%d]: expected dependencies %v, got %v", i, want[i], got[i])
+		}
+	}
+}
+
+type recorder struct{ records []report.Record }
+
+func (r *recorder) Report(rec report.Record) { r.records = append(r.records, rec) }
+func (r *recorder) Close() error             { return nil }
+
+func TestPlan_ExecuteParallel(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", tmpDir)
+	t.Setenv("XDG_STATE_HOME", tmpDir)
+
+	dotfiles := filepath.Join(tmpDir, "dotfiles")
+	if err := os.MkdirAll(dotfiles, 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	m := &Manifest{}
+	for i := range 50 {
+		target := filepath.Join(dotfiles, fmt.Sprint(i))
+		if err := os.WriteFile(target, []byte("content"), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+		link := filepath.Join(tmpDir, "home", fmt.Sprint(i%5), fmt.Sprint(i%3), fmt.Sprint(i))
+		m.Links = append(m.Links, ManifestLink{Target: target, Link: link, Platforms: []string{"all"}})
+	}
+	// declared again pointing elsewhere, which must happen after the first is placed
+	m.Links = append(m.Links, ManifestLink{Target: m.Links[1].Target, Link: m.Links[0].Link, Platforms: []string{"all"}})
+
+	rec := &recorder{}
+	st := state.NewWithLogger(&state.TrovlOptions{Jobs: 8, OverwriteYes: true}, nil, rec)
+
+	p := NewPlan()
+	if err := m.Plan(st, p); err != nil {
+		t.Fatalf("unexpected error from Plan(): %v", err)
+	}
+	if err := p.Execute(st); err != nil {
+		t.Fatalf("unexpected error from Execute(): %v", err)
+	}
+
+	for i, l := range m.Links[1:50] {
+		if dest, err := os.Readlink(l.Link); err != nil || dest != l.Target {
+			t.Errorf("links[%d]: expected symlink to %v, got %v (err: %v)", i+1, l.Target, dest, err)
+		}
+	}
+	if dest, _ := os.Readlink(m.Links[0].Link); dest != m.Links[1].Target {
+		t.Errorf("expected the link declared last to win, got %v", dest)
+	}
+
+	if len(rec.records) != len(m.Links) {
+		t.Fatalf("expected %d records, got %d", len(m.Links), len(rec.records))
+	}
+	for i, r := range rec.records {
+		if r.Index != i {
+			t.Errorf("expected records in plan order, record %d is for links[%d]", i, r.Index)
+		}
+	}
+}
+
+func TestPlan_MissingTargets(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	root := string(filepath.Separator)
+	dotfiles := filepath.Join(root, "dotfiles")
+	home := filepath.Join(root, "home")
+
+	st := state.New(&state.TrovlOptions{})
+	st.FS = vfs.NewMemFS()
+
+	m := &Manifest{Links: []ManifestLink{
+		{Target: filepath.Join(dotfiles, "local", "history"), Link: filepath.Join(home, ".history"), Platforms: []string{"all"}, CreateTarget: CreateTargetFile},
+		{Target: filepath.Join(dotfiles, "work"), Link: filepath.Join(home, ".work"), Platforms: []string{"all"}, Optional: true},
+	}}
+
+	p := NewPlan()
+	if err := m.Plan(st, p); err != nil {
+		t.Fatalf("unexpected error from Plan(): %v", err)
+	}
+
+	want := []links.ActionKind{links.ActionCreateTarget, links.ActionMkdir, links.ActionCreate, links.ActionSkip}
+	if len(p.Actions) != len(want) {
+		t.Fatalf("expected %d actions, got %+v", len(want), p.Actions)
+	}
+	for i, kind := range want {
+		if p.Actions[i].Kind != kind {
+			t.Errorf("action %d is %v, want %v", i, p.Actions[i].Kind, kind)
+		}
+	}
+	if a := p.Actions[0]; a.Link != m.Links[0].Target || a.Type != links.LinkFile {
+		t.Errorf("expected an empty file to be created at the target, got %+v", a)
+	}
+
+	var out strings.Builder
+	p.Render(&out)
+	if want := "Plan: 2 to change (1 create target, 1 create,"; !strings.Contains(out.String(), want) {
+		t.Errorf("expected the plan summary to contain %q, got:\n%v", want, out.String())
+	}
+
+	statuses, err := m.Status(st)
+	if err != nil {
+		t.Fatalf("unexpected error from Status(): %v", err)
+	}
+	if len(statuses) != 2 || !statuses[1].Optional || statuses[1].Status != string(links.StatusTargetMissing) {
+		t.Errorf("expected the optional link to be reported as an optional missing target, got %+v", statuses)
+	}
+}
+
+func TestProtectRoots(t *testing.T) {
+	tmp := t.TempDir()
+	home := filepath.Join(tmp, "home")
+	repo := filepath.Join(tmp, "dotfiles")
+	loose := filepath.Join(tmp, "loose")
+	for _, dir := range []string{filepath.Join(repo, ".git"), filepath.Join(repo, "trovl"), loose, filepath.Join(home, ".git")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ms := []*Manifest{
+		{Path: filepath.Join(repo, "trovl", "manifest.json")},
+		{Path: filepath.Join(loose, "manifest.json")},
+		{Path: filepath.Join(home, ".config", "manifest.json")},
+		{},
+	}
+
+	st := state.New(&state.TrovlOptions{Home: home})
+	ProtectRoots(st, ms...)
+	if want := []string{repo, loose}; !slices.Equal(st.Options.ManifestRoots, want) {
+		t.Errorf("ManifestRoots = %v, want %v", st.Options.ManifestRoots, want)
+	}
+	if err := links.CheckLinkPath(st, filepath.Join(repo, "vimrc")); !errors.Is(err, links.ErrProtectedPath) {
+		t.Errorf("CheckLinkPath() inside the manifest's repo error = %v, want ErrProtectedPath", err)
+	}
+
+	rooted := state.New(&state.TrovlOptions{Home: home, Root: tmp})
+	ProtectRoots(rooted, ms...)
+	if len(rooted.Options.ManifestRoots) > 0 {
+		t.Errorf("expected nothing to be protected with a root, got %v", rooted.Options.ManifestRoots)
+	}
+}
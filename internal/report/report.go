@@ -0,0 +1,169 @@
+/*
+Package report emits the results of commands, one record per link, separately from diagnostic logs.
+Results are written to stdout as text for people, or as JSON/NDJSON for scripts wrapping trovl.
+*/
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type Format string
+
+const (
+	FormatText   Format = "text"   // One human-readable line per record
+	FormatJSON   Format = "json"   // A single JSON array of every record, written once the command finishes
+	FormatNDJSON Format = "ndjson" // One JSON object per line, written as soon as each record is known
+)
+
+var Formats = []Format{FormatText, FormatJSON, FormatNDJSON}
+
+// ParseFormat validates the name of an output format. An empty name is the text format.
+func ParseFormat(name string) (Format, error) {
+	if name == "" {
+		return FormatText, nil
+	}
+	for _, f := range Formats {
+		if string(f) == strings.ToLower(name) {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("invalid output format %q (expected one of: text, json, ndjson)", name)
+}
+
+// Outcome is what happened to a link.
+type Outcome string
+
+const (
+	OutcomeCreated     Outcome = "created"     // The link (or rendered file) was placed
+	OutcomeRemoved     Outcome = "removed"     // The link was removed
+	OutcomeUnchanged   Outcome = "unchanged"   // The link was already as it should be
+	OutcomeDeclined    Outcome = "declined"    // A conflict exists and the user or options said to leave it be
+	OutcomeSkipped     Outcome = "skipped"     // The link does not apply, e.g. to the current platform
+	OutcomeFailed      Outcome = "failed"      // See Error
+	OutcomePlanned     Outcome = "planned"     // Nothing was done as this is a plan or dry-run
+	OutcomeInterrupted Outcome = "interrupted" // Not attempted, as the operation was interrupted first
+)
+
+// Record is the result for a single link.
+type Record struct {
+	Index    int     `json:"index"`              // Index of the link in its manifest, -1 if none
+	ID       string  `json:"id"`                 // Identifies the link: its manifest and index, or its path
+	Manifest string  `json:"manifest,omitempty"` // Manifest the link came from, if any
+	Target   string  `json:"target,omitempty"`
+	Link     string  `json:"link"`
+	Action   string  `json:"action"`
+	Outcome  Outcome `json:"outcome"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// Summary counts records by their outcome.
+type Summary map[Outcome]int
+
+// summaryOrder is the order outcomes are listed in a summary. The first five are always listed.
+var summaryOrder = []Outcome{OutcomeCreated, OutcomeUnchanged, OutcomeSkipped, OutcomeDeclined, OutcomeFailed, OutcomeRemoved, OutcomePlanned, OutcomeInterrupted}
+
+func (s Summary) Add(r Record) {
+	s[r.Outcome]++
+}
+
+// Failed reports whether any record had failed.
+func (s Summary) Failed() bool {
+	return s[OutcomeFailed] > 0
+}
+
+// String lists the counts, e.g. "3 created, 1 unchanged, 0 skipped, 0 declined, 1 failed".
+func (s Summary) String() string {
+	var parts []string
+	for i, o := range summaryOrder {
+		if i >= 5 && s[o] == 0 {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%d %s", s[o], o))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Reporter receives records as a command produces them. Close must be called once the command
+// finishes, as some formats only write then.
+type Reporter interface {
+	Report(r Record)
+	Close() error
+}
+
+// New returns a reporter writing records in the given format to w.
+func New(format Format, w io.Writer) (Reporter, error) {
+	switch format {
+	case FormatText, "":
+		return &textReporter{w: w}, nil
+	case FormatJSON:
+		return &jsonReporter{w: w, records: []Record{}}, nil
+	case FormatNDJSON:
+		return &ndjsonReporter{enc: json.NewEncoder(w)}, nil
+	default:
+		return nil, fmt.Errorf("invalid output format %q", format)
+	}
+}
+
+type textReporter struct {
+	w io.Writer
+}
+
+func (t *textReporter) Report(r Record) {
+	line := fmt.Sprintf("%-9s %-14s %s", r.Outcome, r.Action, r.Link)
+	if r.Target != "" {
+		line += " -> " + r.Target
+	}
+	if r.Manifest != "" {
+		line += fmt.Sprintf("  [%s]", r.ID)
+	}
+	if r.Error != "" {
+		line += ": " + r.Error
+	}
+	fmt.Fprintln(t.w, line)
+}
+
+func (t *textReporter) Close() error { return nil }
+
+type jsonReporter struct {
+	w       io.Writer
+	records []Record
+	closed  bool
+}
+
+func (j *jsonReporter) Report(r Record) {
+	j.records = append(j.records, r)
+}
+
+func (j *jsonReporter) Close() error {
+	if j.closed {
+		return nil
+	}
+	j.closed = true
+
+	enc := json.NewEncoder(j.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(j.records)
+}
+
+type ndjsonReporter struct {
+	enc *json.Encoder
+}
+
+func (n *ndjsonReporter) Report(r Record) {
+	// Encode writes a trailing newline, making each record its own line
+	_ = n.enc.Encode(r)
+}
+
+func (n *ndjsonReporter) Close() error { return nil }
+
+// Discard is a reporter that drops every record.
+var Discard Reporter = discard{}
+
+type discard struct{}
+
+func (discard) Report(Record) {}
+func (discard) Close() error  { return nil }
@@ -0,0 +1,141 @@
+package report_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/sneha-afk/trovl/internal/report"
+)
+
+var records = []report.Record{
+	{Index: 0, ID: "m.json:links[0]", Manifest: "m.json", Target: "/dotfiles/vimrc", Link: "/home/me/.vimrc", Action: "create", Outcome: report.OutcomeCreated},
+	{Index: -1, ID: "/home/me/.bashrc", Link: "/home/me/.bashrc", Action: "create", Outcome: report.OutcomeFailed, Error: "invalid target path"},
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    report.Format
+		wantErr bool
+	}{
+		{"", report.FormatText, false},
+		{"text", report.FormatText, false},
+		{"JSON", report.FormatJSON, false},
+		{"ndjson", report.FormatNDJSON, false},
+		{"yaml", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := report.ParseFormat(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error: %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestReporters(t *testing.T) {
+	tests := []struct {
+		format report.Format
+		check  func(t *testing.T, out string)
+	}{
+		{
+			format: report.FormatText,
+			check: func(t *testing.T, out string) {
+				lines := strings.Split(strings.TrimSpace(out), "\n")
+				if len(lines) != len(records) {
+					t.Fatalf("expected %d lines, got %d: %q", len(records), len(lines), out)
+				}
+				if !strings.HasPrefix(lines[0], "created") || !strings.Contains(lines[0], "/home/me/.vimrc -> /dotfiles/vimrc") {
+					t.Errorf("unexpected first line: %q", lines[0])
+				}
+				if !strings.HasSuffix(lines[1], ": invalid target path") {
+					t.Errorf("expected error at the end of second line, got %q", lines[1])
+				}
+			},
+		},
+		{
+			format: report.FormatJSON,
+			check: func(t *testing.T, out string) {
+				var got []report.Record
+				if err := json.Unmarshal([]byte(out), &got); err != nil {
+					t.Fatalf("output is not a JSON array of records: %v", err)
+				}
+				if len(got) != len(records) || got[0] != records[0] || got[1] != records[1] {
+					t.Errorf("expected %+v, got %+v", records, got)
+				}
+			},
+		},
+		{
+			format: report.FormatNDJSON,
+			check: func(t *testing.T, out string) {
+				lines := strings.Split(strings.TrimSpace(out), "\n")
+				if len(lines) != len(records) {
+					t.Fatalf("expected %d lines, got %d: %q", len(records), len(lines), out)
+				}
+				for i, line := range lines {
+					var got report.Record
+					if err := json.Unmarshal([]byte(line), &got); err != nil {
+						t.Fatalf("line %d is not a JSON record: %v", i, err)
+					}
+					if got != records[i] {
+						t.Errorf("line %d: expected %+v, got %+v", i, records[i], got)
+					}
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			var buf bytes.Buffer
+			r, err := report.New(tt.format, &buf)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, rec := range records {
+				r.Report(rec)
+			}
+			if err := r.Close(); err != nil {
+				t.Fatalf("unexpected error closing reporter: %v", err)
+			}
+			tt.check(t, buf.String())
+		})
+	}
+}
+
+func TestJSONReporter_Empty(t *testing.T) {
+	var buf bytes.Buffer
+	r, _ := report.New(report.FormatJSON, &buf)
+	r.Close()
+	if strings.TrimSpace(buf.String()) != "[]" {
+		t.Errorf("expected an empty array with no records, got %q", buf.String())
+	}
+}
+
+func TestSummary(t *testing.T) {
+	s := report.Summary{}
+	if got, want := s.String(), "0 created, 0 unchanged, 0 skipped, 0 declined, 0 failed"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if s.Failed() {
+		t.Errorf("expected an empty summary not to have failed")
+	}
+
+	for _, r := range records {
+		s.Add(r)
+	}
+	s.Add(report.Record{Outcome: report.OutcomeRemoved})
+	if got, want := s.String(), "1 created, 0 unchanged, 0 skipped, 0 declined, 1 failed, 1 removed"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if !s.Failed() {
+		t.Errorf("expected summary to have failed")
+	}
+}
@@ -4,11 +4,18 @@ Package state defines the global state and options used by all commands.
 package state
 
 import (
+	"context"
 	"log/slog"
 	"os"
+	"sync"
 
 	"github.com/lmittmann/tint"
 	"github.com/mattn/go-isatty"
+	"github.com/sneha-afk/trovl/internal/journal"
+	"github.com/sneha-afk/trovl/internal/ledger"
+	"github.com/sneha-afk/trovl/internal/report"
+	"github.com/sneha-afk/trovl/internal/utils"
+	"github.com/sneha-afk/trovl/internal/vfs"
 )
 
 const LogTimeFormat = "15:04:05"
@@ -32,21 +39,55 @@ func colorize(text, color string) string {
 }
 
 type TrovlOptions struct {
-	Verbose      bool
-	Debug        bool
-	DryRun       bool
-	UseRelative  bool
-	OverwriteYes bool
-	OverwriteNo  bool
-	BackupDir    string
-	BackupYes    bool
-	BackupNo     bool
+	Verbose          bool
+	Debug            bool
+	DryRun           bool
+	UseRelative      bool
+	OverwriteYes     bool
+	OverwriteNo      bool
+	BackupDir        string
+	BackupYes        bool
+	BackupNo         bool
+	BackupCompress   bool
+	Output           string   // Format of results written to stdout, see report.Format
+	KeepGoing        bool     // Attempt every link even if some fail
+	Jobs             int      // Number of links applied or checked at once, 1 or less to go one at a time
+	Force            bool     // Remove or overwrite symlinks even if trovl did not place them
+	DotfilesRoots    []string // Symlinks pointing inside these directories are treated as trovl's own
+	ManifestRoots    []string // Directories holding the manifests being applied, where no link is placed
+	ExpectTarget     string   // Only remove symlinks pointing here, empty to remove any
+	LinkRoots        []string // Links are only placed inside these directories, empty for anywhere
+	AllowOutsideHome bool     // Place links outside LinkRoots anyway
+	Root             string   // Place links inside this directory as if it were mounted as /, empty for the real root
+	Home             string   // Home directory ~ and $HOME expand to in paths, empty for the user's
+	StrictVars       bool     // Variables in paths that are not set are an error, rather than empty
+}
+
+// PathEnv returns what paths are expanded against with these options.
+func (o *TrovlOptions) PathEnv() utils.PathEnv {
+	return utils.PathEnv{Home: o.Home, StrictVars: o.StrictVars}
 }
 
 type TrovlState struct {
-	Options *TrovlOptions
-	Logger  *slog.Logger
-	Level   *slog.LevelVar
+	Options  *TrovlOptions
+	Logger   *slog.Logger
+	Level    *slog.LevelVar
+	Journal  *journal.Operation // Record of the current operation, nil when not recording
+	Reporter report.Reporter    // Receives the result for each link, separately from logs
+	Summary  report.Summary     // Counts of the results reported so far
+	Resolver Resolver           // Decides changes that need confirmation, nil to prompt on stdin
+	Context  context.Context    // Cancels long-running operations between links, nil if never
+	FS       vfs.FS             // Where links are placed, an in-memory overlay of the real filesystem in dry-runs
+	Ledger   *ledger.Ledger     // Symlinks trovl placed, nil if unknown (then none are considered trovl's)
+
+	plain bool   // Log messages are not tagged with colors
+	mu    *mutex // Shared by copies of the state, so links can be worked on concurrently
+}
+
+// mutex serializes what concurrent workers share: reporting and journaling results, and prompting.
+type mutex struct {
+	results sync.Mutex
+	prompt  sync.Mutex
 }
 
 func New(opts *TrovlOptions) *TrovlState {
@@ -77,10 +118,21 @@ func New(opts *TrovlOptions) *TrovlState {
 		},
 	}))
 
+	// an invalid format is rejected when parsing flags, fall back to text otherwise
+	format, err := report.ParseFormat(opts.Output)
+	if err != nil {
+		format = report.FormatText
+	}
+	reporter, _ := report.New(format, os.Stdout)
+
 	state := TrovlState{
-		Options: opts,
-		Logger:  logger,
-		Level:   lvl,
+		Options:  opts,
+		Logger:   logger,
+		Level:    lvl,
+		Reporter: reporter,
+		Summary:  report.Summary{},
+		FS:       defaultFS(opts),
+		mu:       &mutex{},
 	}
 	state.SetLogLevel()
 	return &state
@@ -90,6 +142,79 @@ func DefaultState() *TrovlState {
 	return New(&TrovlOptions{})
 }
 
+// NewWithLogger creates a state that logs to logger and reports results to reporter, rather than
+// to the terminal. Log messages are not colored. A nil logger or reporter discards everything.
+func NewWithLogger(opts *TrovlOptions, logger *slog.Logger, reporter report.Reporter) *TrovlState {
+	if opts == nil {
+		opts = &TrovlOptions{}
+	}
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+	if reporter == nil {
+		reporter = report.Discard
+	}
+	return &TrovlState{
+		Options:  opts,
+		Logger:   logger,
+		Level:    &slog.LevelVar{},
+		Reporter: reporter,
+		Summary:  report.Summary{},
+		FS:       defaultFS(opts),
+		plain:    true,
+		mu:       &mutex{},
+	}
+}
+
+// defaultFS is the real filesystem, or an overlay of it in dry-runs so nothing is modified while
+// the effects of each action are still seen by the next.
+func defaultFS(opts *TrovlOptions) vfs.FS {
+	fsys := vfs.OS
+	if opts.Root != "" {
+		fsys = vfs.Rooted(fsys, opts.Root)
+	}
+	if opts.DryRun {
+		return vfs.NewOverlay(fsys)
+	}
+	return fsys
+}
+
+// ResetFS sets the filesystem links are placed on from the options again, e.g. after Root changed.
+func (s *TrovlState) ResetFS() {
+	s.FS = defaultFS(s.Options)
+}
+
+// HostPath returns where path is on the host, which differs from path when placing links inside a
+// Root. The journal and ledger record host paths, so they can be undone from anywhere.
+func (s *TrovlState) HostPath(path string) string {
+	return vfs.HostPath(s.FS, path)
+}
+
+// CleanPath cleans and expands path against the state's options, see utils.PathEnv.CleanPath.
+func (s *TrovlState) CleanPath(path string, useRelative bool) (string, error) {
+	return s.Options.PathEnv().CleanPath(path, useRelative)
+}
+
+// WithFS returns a copy of the state that places links on fsys instead.
+func (s *TrovlState) WithFS(fsys vfs.FS) *TrovlState {
+	c := *s
+	c.FS = fsys
+	return &c
+}
+
+// Jobs returns how many links can be worked on at once.
+func (s *TrovlState) Jobs() int {
+	return max(s.Options.Jobs, 1)
+}
+
+// Err returns why the state's context was canceled, or nil if it was not (or there is none).
+func (s *TrovlState) Err() error {
+	if s.Context == nil {
+		return nil
+	}
+	return s.Context.Err()
+}
+
 // SetLogLevel should be called after setting or changing the log level
 func (s *TrovlState) SetLogLevel() {
 	switch {
@@ -102,22 +227,53 @@ func (s *TrovlState) SetLogLevel() {
 	}
 }
 
+// Record adds an inverse action to the current operation's journal, if one is being recorded.
+func (s *TrovlState) Record(a journal.Action) {
+	if s.Journal == nil {
+		return
+	}
+	s.mu.results.Lock()
+	defer s.mu.results.Unlock()
+	s.Journal.Record(a)
+}
+
+// Report emits the result for a single link.
+func (s *TrovlState) Report(r report.Record) {
+	s.mu.results.Lock()
+	defer s.mu.results.Unlock()
+	if s.Summary != nil {
+		s.Summary.Add(r)
+	}
+	if s.Reporter == nil {
+		return
+	}
+	s.Reporter.Report(r)
+}
+
+// tag prefixes msg with a tag, colored unless the state is plain.
+func (s *TrovlState) tag(tag, color, msg string) string {
+	if s.plain {
+		return tag + " " + msg
+	}
+	return colorize(tag, color) + " " + msg
+}
+
 func (s *TrovlState) LogLink(msg string, args ...any) {
-	taggedMsg := colorize("[LINK]", ColorLink) + " " + msg
+	taggedMsg := s.tag("[LINK]", ColorLink, msg)
 	s.Logger.Info(taggedMsg, args...)
 }
 
 func (s *TrovlState) LogBackup(msg string, args ...any) {
-	taggedMsg := colorize("[BACKUP]", ColorBackup) + " " + msg
+	taggedMsg := s.tag("[BACKUP]", ColorBackup, msg)
 	s.Logger.Info(taggedMsg, args...)
 }
 
 func (s *TrovlState) LogOverwrite(msg string, args ...any) {
-	taggedMsg := colorize("[OVERWRITE]", ColorOverwrite) + " " + msg
+	taggedMsg := s.tag("[OVERWRITE]", ColorOverwrite, msg)
 	s.Logger.Info(taggedMsg, args...)
 }
 
 func (s *TrovlState) LogSuccess(msg string, args ...any) {
-	taggedMsg := colorize("[SUCCESS]", ColorSuccess) + " " + msg
+	taggedMsg := s.tag("[SUCCESS]", ColorSuccess, msg)
 	s.Logger.Info(taggedMsg, args...)
 }
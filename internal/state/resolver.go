@@ -0,0 +1,56 @@
+package state
+
+import (
+	"fmt"
+	"unicode"
+)
+
+// Conflict is a change that needs confirmation before it is made, e.g. replacing an existing file.
+type Conflict struct {
+	Kind     string // Kind of action, e.g. "replace_link", "backup_replace" or "render"
+	Path     string // Path of the link or rendered output
+	Target   string
+	Current  string // What a conflicting symlink currently points to, if any
+	Question string // Question to ask a person
+}
+
+// Resolver decides whether a change that needs confirmation goes ahead.
+type Resolver interface {
+	Resolve(c Conflict) (bool, error)
+}
+
+// ResolverFunc adapts a function to a Resolver.
+type ResolverFunc func(c Conflict) (bool, error)
+
+func (f ResolverFunc) Resolve(c Conflict) (bool, error) { return f(c) }
+
+// PromptResolver asks the user the conflict's question on stdin, defaulting to no.
+var PromptResolver Resolver = ResolverFunc(promptYesNo)
+
+func promptYesNo(c Conflict) (bool, error) {
+	fmt.Print(c.Question + " [y/N] > ")
+
+	var input = 'n'
+	if _, err := fmt.Scanf("%c\n", &input); err != nil {
+		return false, fmt.Errorf("could not read input, no action taken: %w", err)
+	}
+	return unicode.ToLower(input) == 'y', nil
+}
+
+// Confirm asks the state's resolver whether a change goes ahead, or the user on stdin if there is none.
+// If the state's context was canceled while asking, the change does not go ahead.
+// Only one change is confirmed at a time, even when links are applied concurrently.
+func (s *TrovlState) Confirm(c Conflict) (bool, error) {
+	s.mu.prompt.Lock()
+	defer s.mu.prompt.Unlock()
+
+	resolver := s.Resolver
+	if resolver == nil {
+		resolver = PromptResolver
+	}
+	ok, err := resolver.Resolve(c)
+	if ctxErr := s.Err(); ctxErr != nil {
+		return false, ctxErr
+	}
+	return ok, err
+}
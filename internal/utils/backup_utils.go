@@ -0,0 +1,398 @@
+package utils
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// backupMetaExt is appended to a backup's path to name the JSON file describing it.
+const backupMetaExt = ".meta.json"
+
+type BackupOptions struct {
+	Dir             string // Root backup directory (default: $XDG_CACHE_HOME/trovl/backups)
+	TimestampFormat string // Format of the timestamp in backup filenames
+	Compress        bool   // gzip the contents of ordinary files
+}
+
+// Backup describes a single backed up file. It is stored beside the backup as <path>.meta.json.
+type Backup struct {
+	Original   string      `json:"original"`              // Absolute path the file was backed up from
+	Path       string      `json:"-"`                     // Where the backup is stored
+	Time       time.Time   `json:"time"`                  // When the backup was taken
+	Hash       string      `json:"hash"`                  // SHA-256 of the original contents
+	Mode       fs.FileMode `json:"mode"`                  // Mode of the original file
+	Compressed bool        `json:"compressed,omitempty"`  // Whether the backup is gzipped
+	LinkTarget string      `json:"link_target,omitempty"` // If the original was a symlink, what it pointed to
+}
+
+// GetBackupDir returns the default backup directory, $XDG_CACHE_HOME/trovl/backups.
+// Note: does NOT guarantee the directory has been created yet.
+func GetBackupDir() (string, error) {
+	cacheDir, err := GetCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("could not get cache directory: %w", err)
+	}
+	return filepath.Join(cacheDir, "backups"), nil
+}
+
+// backupSubdir mirrors the absolute path of the original file's directory under the backup
+// directory, so files with the same name from different directories never collide.
+func backupSubdir(backupDir, originalPath string) string {
+	dir := filepath.Dir(originalPath)
+	vol := filepath.VolumeName(dir)
+	rest := strings.TrimLeft(strings.TrimPrefix(dir, vol), `/\`)
+	// C: -> C, \\server\share -> server_share
+	vol = strings.Trim(strings.NewReplacer(":", "", `\`, "_", "/", "_").Replace(vol), "_")
+	return filepath.Join(backupDir, vol, rest)
+}
+
+// BackupFile copies a file into the backup directory, and returns the path it was stored to.
+// Backups are laid out by the original file's path: backing up /home/me/.bashrc stores
+// <backup dir>/home/me/.bashrc_backup_<timestamp>. If a backup of the same path with identical
+// contents already exists, no new copy is made and the existing backup's path is returned.
+// Default backup directory: $XDG_CACHE_HOME/trovl/backups
+func BackupFile(path string, opts BackupOptions) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if opts.Dir == "" {
+		if opts.Dir, err = GetBackupDir(); err != nil {
+			return "", err
+		}
+	}
+	if opts.TimestampFormat == "" {
+		opts.TimestampFormat = FileTimeFormat
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "", fmt.Errorf("could not read file to backup: %w", err)
+	}
+	isSymlink := info.Mode()&fs.ModeSymlink != 0
+
+	var linkTarget string
+	if isSymlink {
+		if linkTarget, err = os.Readlink(path); err != nil {
+			return "", fmt.Errorf("could not read symlink to backup: %w", err)
+		}
+	}
+
+	hash := "symlink:" + linkTarget
+	if !isSymlink {
+		if hash, err = HashFile(path); err != nil {
+			return "", fmt.Errorf("could not hash file to backup: %w", err)
+		}
+	}
+
+	subdir := backupSubdir(opts.Dir, path)
+	existing, err := listBackupsIn(subdir)
+	if err != nil {
+		return "", err
+	}
+	for _, b := range existing {
+		if b.Original == path && b.Hash == hash {
+			return b.Path, nil
+		}
+	}
+
+	if err := os.MkdirAll(subdir, 0755); err != nil {
+		return "", fmt.Errorf("could not create backup parent directory: %w", err)
+	}
+
+	now := time.Now()
+	base := filepath.Base(path)
+	ext := filepath.Ext(base)
+	name := strings.TrimSuffix(base, ext)
+	compress := opts.Compress && !isSymlink
+	if compress {
+		ext += ".gz"
+	}
+
+	// files backed up within the same second get a numbered suffix
+	backupPath := filepath.Join(subdir, fmt.Sprintf("%s_backup_%s%s", name, now.Format(opts.TimestampFormat), ext))
+	for n := 2; ; n++ {
+		if _, err := os.Lstat(backupPath); errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		backupPath = filepath.Join(subdir, fmt.Sprintf("%s_backup_%s_%d%s", name, now.Format(opts.TimestampFormat), n, ext))
+	}
+
+	if compress {
+		err = gzipFile(path, backupPath, info)
+	} else {
+		err = CopyFile(path, backupPath)
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not backup file: %w", err)
+	}
+
+	b := Backup{
+		Original:   path,
+		Path:       backupPath,
+		Time:       now,
+		Hash:       hash,
+		Mode:       info.Mode(),
+		Compressed: compress,
+		LinkTarget: linkTarget,
+	}
+	if err := writeBackupMeta(b); err != nil {
+		os.Remove(backupPath)
+		return "", fmt.Errorf("could not record backup: %w", err)
+	}
+	return backupPath, nil
+}
+
+// gzipFile writes src compressed to dst, with the mode bits and (where permitted) ownership of src.
+// As in CopyFile, it is written to a temporary file beside dst and renamed over it, so a failure
+// never leaves a truncated dst behind.
+func gzipFile(src, dst string, info fs.FileInfo) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+	// no-op once renamed into place
+	defer os.Remove(tmpPath)
+
+	zw := gzip.NewWriter(tmpFile)
+	zw.Name = filepath.Base(src)
+	zw.ModTime = info.ModTime()
+	if _, err := io.Copy(zw, srcFile); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	// best-effort: only privileged users can give away ownership
+	_ = copyOwner(tmpFile, info)
+	// after changing the owner, which clears the setuid and setgid bits
+	if err := tmpFile.Chmod(fileMode(info.Mode())); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, dst)
+}
+
+func writeBackupMeta(b Backup) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(b.Path+backupMetaExt, data, 0644)
+}
+
+func readBackupMeta(metaPath string) (Backup, error) {
+	data, err := os.ReadFile(metaPath)
+	if err != nil {
+		return Backup{}, err
+	}
+	var b Backup
+	if err := json.Unmarshal(data, &b); err != nil {
+		return Backup{}, fmt.Errorf("invalid backup metadata %v: %w", metaPath, err)
+	}
+	b.Path = strings.TrimSuffix(metaPath, backupMetaExt)
+	return b, nil
+}
+
+// LoadBackup reads the description of the backup stored at backupPath.
+func LoadBackup(backupPath string) (Backup, error) {
+	return readBackupMeta(backupPath + backupMetaExt)
+}
+
+// listBackupsIn lists the backups directly inside dir, which may not exist.
+func listBackupsIn(dir string) ([]Backup, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read backup directory: %w", err)
+	}
+
+	var backups []Backup
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), backupMetaExt) {
+			continue
+		}
+		b, err := readBackupMeta(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, b)
+	}
+	return backups, nil
+}
+
+// ListBackups returns every backup under backupDir, newest first.
+func ListBackups(backupDir string) ([]Backup, error) {
+	var backups []Backup
+	err := filepath.WalkDir(backupDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path == backupDir {
+				return filepath.SkipAll
+			}
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, backupMetaExt) {
+			return nil
+		}
+		b, err := readBackupMeta(path)
+		if err != nil {
+			return err
+		}
+		backups = append(backups, b)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list backups: %w", err)
+	}
+
+	slices.SortFunc(backups, func(a, b Backup) int {
+		return b.Time.Compare(a.Time)
+	})
+	return backups, nil
+}
+
+// FindBackup looks up a backup by either its own path, or the path of the original file, in which
+// case the newest backup of that file is returned.
+func FindBackup(backupDir, path string) (Backup, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return Backup{}, err
+	}
+
+	backups, err := ListBackups(backupDir)
+	if err != nil {
+		return Backup{}, err
+	}
+	for _, b := range backups {
+		if b.Path == path || b.Original == path {
+			return b, nil
+		}
+	}
+	return Backup{}, fmt.Errorf("no backup found for %v: %w", path, fs.ErrNotExist)
+}
+
+// RestoreBackup puts the contents of a backup at dest, atomically replacing anything (other than a
+// directory) already there. Backed up symlinks are restored as symlinks to their previous target.
+func RestoreBackup(b Backup, dest string) error {
+	if b.LinkTarget != "" {
+		return copySymlink(b.Path, dest)
+	}
+	if !b.Compressed {
+		return CopyFile(b.Path, dest)
+	}
+
+	srcFile, err := os.Open(b.Path)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	zr, err := gzip.NewReader(srcFile)
+	if err != nil {
+		return fmt.Errorf("could not read compressed backup: %w", err)
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := io.Copy(tmpFile, zr); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("could not decompress backup: %w", err)
+	}
+	if err := tmpFile.Chmod(fileMode(b.Mode)); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmpPath, zr.ModTime, zr.ModTime); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, dest)
+}
+
+// RemoveBackup deletes a backup and its metadata.
+func RemoveBackup(b Backup) error {
+	if err := os.Remove(b.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return os.Remove(b.Path + backupMetaExt)
+}
+
+// SelectPrunable returns the backups that a prune should delete. For each original path, the
+// newest keep backups are always retained; of the rest, only those older than olderThan are
+// selected. A zero keep or olderThan disables that condition.
+func SelectPrunable(backups []Backup, keep int, olderThan time.Duration, now time.Time) []Backup {
+	sorted := slices.Clone(backups)
+	slices.SortStableFunc(sorted, func(a, b Backup) int {
+		return b.Time.Compare(a.Time)
+	})
+
+	var prunable []Backup
+	seen := map[string]int{}
+	for _, b := range sorted {
+		seen[b.Original]++
+		if keep > 0 && seen[b.Original] <= keep {
+			continue
+		}
+		if olderThan > 0 && now.Sub(b.Time) < olderThan {
+			continue
+		}
+		prunable = append(prunable, b)
+	}
+	return prunable
+}
+
+// ParseAge parses a duration as time.ParseDuration does, with the additional units of
+// days ("30d") and weeks ("2w").
+func ParseAge(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			v, err := strconv.ParseFloat(n, 64)
+			if err != nil || v < 0 {
+				return 0, fmt.Errorf("invalid age %q", s)
+			}
+			return time.Duration(v * float64(unit)), nil
+		}
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q", s)
+	}
+	return d, nil
+}
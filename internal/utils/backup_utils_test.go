@@ -0,0 +1,275 @@
+package utils_test
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sneha-afk/trovl/internal/utils"
+)
+
+func TestBackupFile(t *testing.T) {
+	t.Run("laid out by original path", func(t *testing.T) {
+		tmp := t.TempDir()
+		backupDir := filepath.Join(tmp, "backups")
+
+		a := filepath.Join(tmp, "a", "config")
+		b := filepath.Join(tmp, "b", "config")
+		os.MkdirAll(filepath.Dir(a), 0755)
+		os.MkdirAll(filepath.Dir(b), 0755)
+		os.WriteFile(a, []byte("a"), 0644)
+		os.WriteFile(b, []byte("b"), 0644)
+
+		opts := utils.BackupOptions{Dir: backupDir}
+		pathA, err := utils.BackupFile(a, opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		pathB, err := utils.BackupFile(b, opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if pathA == pathB {
+			t.Fatalf("backups of files with the same name collided: %v", pathA)
+		}
+		if !strings.Contains(filepath.ToSlash(pathA), "/a/config_backup_") {
+			t.Errorf("expected backup to mirror original path, got %v", pathA)
+		}
+		if data, _ := os.ReadFile(pathB); string(data) != "b" {
+			t.Errorf("unexpected backup contents %q", data)
+		}
+
+		backups, err := utils.ListBackups(backupDir)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(backups) != 2 {
+			t.Fatalf("expected 2 backups, got %d", len(backups))
+		}
+		for _, bk := range backups {
+			if bk.Original != a && bk.Original != b {
+				t.Errorf("unexpected original path %v", bk.Original)
+			}
+		}
+	})
+
+	t.Run("identical contents are deduplicated", func(t *testing.T) {
+		tmp := t.TempDir()
+		backupDir := filepath.Join(tmp, "backups")
+		file := filepath.Join(tmp, "file.txt")
+		os.WriteFile(file, []byte("same"), 0644)
+
+		opts := utils.BackupOptions{Dir: backupDir}
+		first, err := utils.BackupFile(file, opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		second, err := utils.BackupFile(file, opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if first != second {
+			t.Errorf("expected identical backup to be reused, got %v and %v", first, second)
+		}
+
+		// changed contents within the same second must not overwrite the first backup
+		os.WriteFile(file, []byte("different"), 0644)
+		third, err := utils.BackupFile(file, opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if third == first {
+			t.Errorf("expected a new backup for changed contents")
+		}
+		if data, _ := os.ReadFile(first); string(data) != "same" {
+			t.Errorf("first backup was modified: %q", data)
+		}
+	})
+
+	t.Run("compressed", func(t *testing.T) {
+		tmp := t.TempDir()
+		file := filepath.Join(tmp, "file.txt")
+		os.WriteFile(file, []byte("compress me"), 0644)
+		os.Chmod(file, 0640)
+
+		backupPath, err := utils.BackupFile(file, utils.BackupOptions{Dir: filepath.Join(tmp, "backups"), Compress: true})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.HasSuffix(backupPath, ".txt.gz") {
+			t.Errorf("expected .gz extension, got %v", backupPath)
+		}
+
+		f, err := os.Open(backupPath)
+		if err != nil {
+			t.Fatalf("could not open backup: %v", err)
+		}
+		defer f.Close()
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("backup is not gzipped: %v", err)
+		}
+		data, _ := io.ReadAll(zr)
+		if string(data) != "compress me" {
+			t.Errorf("unexpected decompressed contents %q", data)
+		}
+
+		info, err := os.Stat(backupPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0640 {
+			t.Errorf("expected backup to keep mode 0640, got %v", info.Mode().Perm())
+		}
+		entries, _ := os.ReadDir(filepath.Dir(backupPath))
+		for _, e := range entries {
+			if strings.Contains(e.Name(), ".tmp-") {
+				t.Errorf("temporary file %v was left behind", e.Name())
+			}
+		}
+	})
+}
+
+func TestSelectPrunable(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	var backups []utils.Backup
+	for i := range 5 {
+		backups = append(backups, utils.Backup{Original: "/a", Path: "a" + string(rune('0'+i)), Time: now.Add(-time.Duration(i*10) * day)})
+	}
+	backups = append(backups, utils.Backup{Original: "/b", Path: "b0", Time: now.Add(-100 * day)})
+
+	tests := []struct {
+		name      string
+		keep      int
+		olderThan time.Duration
+		want      []string
+	}{
+		{name: "keep only", keep: 2, want: []string{"a2", "a3", "a4"}},
+		{name: "older than only", olderThan: 25 * day, want: []string{"a3", "a4", "b0"}},
+		{name: "keep and older than", keep: 4, olderThan: 25 * day, want: []string{"a4"}},
+		{name: "keep more than exist", keep: 10, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := utils.SelectPrunable(backups, tt.keep, tt.olderThan, now)
+
+			var paths []string
+			for _, b := range got {
+				paths = append(paths, b.Path)
+			}
+			if strings.Join(paths, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("got %v, want %v", paths, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{in: "30d", want: 30 * 24 * time.Hour},
+		{in: "2w", want: 14 * 24 * time.Hour},
+		{in: "12h", want: 12 * time.Hour},
+		{in: "1.5d", want: 36 * time.Hour},
+		{in: "-1d", wantErr: true},
+		{in: "soon", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := utils.ParseAge(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestoreBackup(t *testing.T) {
+	tests := []struct {
+		name     string
+		compress bool
+		setup    func(path string)
+		validate func(t *testing.T, path string)
+	}{
+		{
+			name: "ordinary file",
+			setup: func(path string) {
+				os.WriteFile(path, []byte("original"), 0600)
+			},
+			validate: func(t *testing.T, path string) {
+				if data, _ := os.ReadFile(path); string(data) != "original" {
+					t.Errorf("unexpected restored contents %q", data)
+				}
+			},
+		},
+		{
+			name:     "compressed file keeps its mode",
+			compress: true,
+			setup: func(path string) {
+				os.WriteFile(path, []byte("original"), 0600)
+				os.Chmod(path, 0600)
+			},
+			validate: func(t *testing.T, path string) {
+				if data, _ := os.ReadFile(path); string(data) != "original" {
+					t.Errorf("unexpected restored contents %q", data)
+				}
+				if info, err := os.Stat(path); runtime.GOOS != "windows" && (err != nil || info.Mode().Perm() != 0600) {
+					t.Errorf("expected mode 0600 to be restored, got %v (%v)", info.Mode().Perm(), err)
+				}
+			},
+		},
+		{
+			name: "symlink is restored as a symlink",
+			setup: func(path string) {
+				os.Symlink("previous/target", path)
+			},
+			validate: func(t *testing.T, path string) {
+				if got, err := os.Readlink(path); err != nil || got != "previous/target" {
+					t.Errorf("expected symlink to previous target, got %q (%v)", got, err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmp := t.TempDir()
+			backupDir := filepath.Join(tmp, "backups")
+			path := filepath.Join(tmp, "file")
+			tt.setup(path)
+
+			if _, err := utils.BackupFile(path, utils.BackupOptions{Dir: backupDir, Compress: tt.compress}); err != nil {
+				t.Fatalf("could not backup: %v", err)
+			}
+
+			// replace the original, as a new symlink would
+			os.Remove(path)
+			os.Symlink(filepath.Join(tmp, "new_target"), path)
+
+			b, err := utils.FindBackup(backupDir, path)
+			if err != nil {
+				t.Fatalf("could not find backup by original path: %v", err)
+			}
+			if err := utils.RestoreBackup(b, path); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			tt.validate(t, path)
+		})
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package utils
+
+import (
+	"io"
+	"os"
+)
+
+// copyContents copies the contents of src into dst.
+func copyContents(dst, src *os.File) error {
+	_, err := io.Copy(dst, src)
+	return err
+}
@@ -0,0 +1,202 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnsetVariable is returned when a path refers to a variable that is not set, either through
+// ${VAR:?message} or with PathEnv.StrictVars.
+var ErrUnsetVariable = errors.New("variable is not set")
+
+// ErrBadSubstitution is returned when a ${...} expression in a path cannot be parsed.
+var ErrBadSubstitution = errors.New("bad substitution")
+
+// lookupEnv looks up an environment variable, with the home directory variables replaced by Home
+// when it is set.
+func (e PathEnv) lookupEnv(name string) (string, bool) {
+	if e.Home != "" && (name == "HOME" || (GOOS == "windows" && strings.EqualFold(name, "USERPROFILE"))) {
+		return e.Home, true
+	}
+	return os.LookupEnv(name)
+}
+
+// ExpandVars expands $VAR and ${VAR} in s, along with these POSIX shell forms:
+//
+//	${VAR:-word}  word if VAR is unset or empty (${VAR-word}: only if unset)
+//	${VAR:+word}  word if VAR is set and not empty (${VAR+word}: if set)
+//	${VAR:?msg}   an error with msg if VAR is unset or empty (${VAR?msg}: only if unset)
+//
+// Words are expanded themselves, including a leading ~. Variables that are not set expand to an
+// empty string, or are an error with StrictVars. A $ not followed by a name is kept as is.
+func (e PathEnv) ExpandVars(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] != '$' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			i++
+			continue
+		}
+
+		if s[i+1] == '{' {
+			end := closingBrace(s, i+1)
+			if end < 0 {
+				return "", fmt.Errorf("%w: missing } in %q", ErrBadSubstitution, s)
+			}
+			value, err := e.expandBraced(s[i+2 : end])
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(value)
+			i = end + 1
+			continue
+		}
+
+		n := nameLen(s[i+1:])
+		if n == 0 {
+			b.WriteByte('$')
+			i++
+			continue
+		}
+		value, err := e.param(s[i+1 : i+1+n])
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(value)
+		i += 1 + n
+	}
+	return b.String(), nil
+}
+
+// closingBrace returns the index of the } matching the { at open, or -1.
+func closingBrace(s string, open int) int {
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// nameLen returns the length of the variable name s starts with, 0 if none.
+func nameLen(s string) int {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9' {
+			continue
+		}
+		return i
+	}
+	return len(s)
+}
+
+// param returns the value of the variable name.
+func (e PathEnv) param(name string) (string, error) {
+	value, ok := e.lookupEnv(name)
+	if !ok && e.StrictVars {
+		return "", fmt.Errorf("%w: %v", ErrUnsetVariable, name)
+	}
+	return value, nil
+}
+
+// expandBraced expands the contents of ${...}.
+func (e PathEnv) expandBraced(expr string) (string, error) {
+	n := nameLen(expr)
+	if n == 0 {
+		return "", fmt.Errorf("%w: ${%v}", ErrBadSubstitution, expr)
+	}
+	name, rest := expr[:n], expr[n:]
+	if rest == "" {
+		return e.param(name)
+	}
+
+	nullIsUnset := strings.HasPrefix(rest, ":")
+	op := strings.TrimPrefix(rest, ":")
+	if op == "" {
+		return "", fmt.Errorf("%w: ${%v}", ErrBadSubstitution, expr)
+	}
+	word := op[1:]
+
+	value, set := e.lookupEnv(name)
+	if nullIsUnset && value == "" {
+		set = false
+	}
+
+	switch op[0] {
+	case '-':
+		if set {
+			return value, nil
+		}
+		return e.expandWord(word)
+	case '+':
+		if !set {
+			return "", nil
+		}
+		return e.expandWord(word)
+	case '?':
+		if set {
+			return value, nil
+		}
+		msg, err := e.ExpandVars(word)
+		if err != nil {
+			return "", err
+		}
+		if msg == "" {
+			return "", fmt.Errorf("%w: %v", ErrUnsetVariable, name)
+		}
+		return "", fmt.Errorf("%w: %v: %v", ErrUnsetVariable, name, msg)
+	default:
+		return "", fmt.Errorf("%w: ${%v}", ErrBadSubstitution, expr)
+	}
+}
+
+// expandWord expands the word of a ${VAR:-word} expression, including a leading ~.
+func (e PathEnv) expandWord(word string) (string, error) {
+	expanded, err := e.ExpandVars(word)
+	if err != nil {
+		return "", err
+	}
+	return e.ExpandTilde(expanded)
+}
+
+// ExpandTilde expands a leading ~ (the home directory, see HomeDir) or ~user (the home directory
+// of user) in path. A ~ anywhere else is kept as is.
+func (e PathEnv) ExpandTilde(path string) (string, error) {
+	if !strings.HasPrefix(path, "~") {
+		return path, nil
+	}
+	name, rest := path[1:], ""
+	if i := strings.IndexAny(name, `/\`); i >= 0 {
+		name, rest = name[:i], name[i+1:]
+	}
+
+	var home string
+	if name == "" {
+		h, err := e.HomeDir()
+		if err != nil {
+			return "", err
+		}
+		home = h
+	} else {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", fmt.Errorf("could not expand ~%v: %w", name, err)
+		}
+		home = u.HomeDir
+	}
+	if rest == "" {
+		return home, nil
+	}
+	return filepath.Join(home, rest), nil
+}
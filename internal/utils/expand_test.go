@@ -0,0 +1,88 @@
+package utils_test
+
+import (
+	"errors"
+	"os/user"
+	"path/filepath"
+	"testing"
+
+	"github.com/sneha-afk/trovl/internal/utils"
+)
+
+func TestExpandVars(t *testing.T) {
+	home := filepath.Join(t.TempDir(), "home")
+	t.Setenv("SET", "value")
+	t.Setenv("EMPTY", "")
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	tests := []struct {
+		name   string
+		in     string
+		strict bool
+		want   string
+		errIs  error
+	}{
+		{name: "plain", in: "$SET/${SET}", want: "value/value"},
+		{name: "unset is empty", in: "a${NOPE_TROVL}b", want: "ab"},
+		{name: "default when empty", in: "${EMPTY:-fallback}", want: "fallback"},
+		{name: "default only when unset", in: "${EMPTY-fallback}", want: ""},
+		{name: "default not used when set", in: "${SET:-fallback}", want: "value"},
+		{name: "default with tilde and path", in: "${XDG_CONFIG_HOME:-~/.config}/nvim", want: filepath.Join(home, ".config") + "/nvim"},
+		{name: "nested default", in: "${NOPE_TROVL:-${SET}/x}", want: "value/x"},
+		{name: "alternative when set", in: "${SET:+yes}", want: "yes"},
+		{name: "alternative when unset", in: "${NOPE_TROVL:+yes}", want: ""},
+		{name: "alternative when empty but set", in: "${EMPTY+yes}", want: "yes"},
+		{name: "error when unset", in: "${NOPE_TROVL:?set NOPE_TROVL first}", errIs: utils.ErrUnsetVariable},
+		{name: "error value when set", in: "${SET:?unused}", want: "value"},
+		{name: "home override", in: "$HOME/x", want: home + "/x"},
+		{name: "dollar without name", in: "a$/b$", want: "a$/b$"},
+		{name: "missing brace", in: "${SET", errIs: utils.ErrBadSubstitution},
+		{name: "unknown operator", in: "${SET%x}", errIs: utils.ErrBadSubstitution},
+		{name: "no name", in: "${:-x}", errIs: utils.ErrBadSubstitution},
+		{name: "strict unset", in: "$NOPE_TROVL/x", strict: true, errIs: utils.ErrUnsetVariable},
+		{name: "strict set but empty", in: "${EMPTY}x", strict: true, want: "x"},
+		{name: "strict with default", in: "${NOPE_TROVL:-d}", strict: true, want: "d"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := utils.PathEnv{Home: home, StrictVars: tt.strict}
+			got, err := env.ExpandVars(tt.in)
+			if tt.errIs != nil {
+				if !errors.Is(err, tt.errIs) {
+					t.Errorf("ExpandVars(%q) error = %v, want %v", tt.in, err, tt.errIs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ExpandVars(%q) unexpected error: %v", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("ExpandVars(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandTilde(t *testing.T) {
+	me, err := user.Current()
+	if err != nil {
+		t.Fatalf("cannot get current user: %v", err)
+	}
+
+	var env utils.PathEnv
+	got, err := env.ExpandTilde("~" + me.Username + "/.vimrc")
+	if err != nil {
+		t.Fatalf("ExpandTilde() unexpected error: %v", err)
+	}
+	if want := filepath.Join(me.HomeDir, ".vimrc"); got != want {
+		t.Errorf("ExpandTilde() = %q, want %q", got, want)
+	}
+
+	if _, err := env.ExpandTilde("~no-such-user-trovl/x"); err == nil {
+		t.Error("ExpandTilde() of an unknown user succeeded, want an error")
+	}
+	if got, _ := env.ExpandTilde("foo/~/bar"); got != "foo/~/bar" {
+		t.Errorf("ExpandTilde() of a ~ mid-path = %q, want it unchanged", got)
+	}
+}
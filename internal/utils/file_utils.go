@@ -1,6 +1,9 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -11,6 +14,8 @@ import (
 	"runtime"
 	"strings"
 	"time"
+
+	"github.com/sneha-afk/trovl/internal/vfs"
 )
 
 type PathInfo struct {
@@ -20,11 +25,29 @@ type PathInfo struct {
 	TargetPath string // If this is a symlink, what is is pointing to?
 }
 
+// ErrIsDir is returned when a file was expected but a directory was found.
+var ErrIsDir = errors.New("is a directory")
+
 // GOOS defined locally for easy testing
 var GOOS = runtime.GOOS
 
 var FileTimeFormat = "2006-01-02_15-04-05"
 
+// PathEnv is what paths are expanded against. The zero value expands against the process
+// environment and the user's home directory.
+type PathEnv struct {
+	Home       string // Home directory ~ and $HOME expand to, instead of the user's, e.g. a scratch home
+	StrictVars bool   // Variables that are not set are an error, rather than an empty string
+}
+
+// HomeDir returns the home directory paths are relative to: Home if set, otherwise the user's.
+func (e PathEnv) HomeDir() (string, error) {
+	if e.Home != "" {
+		return e.Home, nil
+	}
+	return os.UserHomeDir()
+}
+
 var (
 	// Group 1: %VAR% content | Group 2: ${env:VAR} content | Group 3: $env:VAR content
 	winEnvRegex = regexp.MustCompile(`(?i)%([A-Z_]\w*)%|\$\{(?:env):([A-Z_]\w*)\}|\$(?:env):([A-Z_]\w*)`)
@@ -95,9 +118,14 @@ func ExpandPowerShellVars(s string) (string, error) {
 	return result, nil
 }
 
+// CleanPath cleans raw against the process environment, see PathEnv.CleanPath.
+func CleanPath(raw string, useRelative bool) (string, error) {
+	return PathEnv{}.CleanPath(raw, useRelative)
+}
+
 // CleanPath defaults to using an absolute filepath, only relative if specified
 // Guaranteed that filepath.Clean has been called before returning
-func CleanPath(raw string, useRelative bool) (string, error) {
+func (e PathEnv) CleanPath(raw string, useRelative bool) (string, error) {
 	if raw == "" {
 		if useRelative {
 			return ".", nil
@@ -115,20 +143,15 @@ func CleanPath(raw string, useRelative bool) (string, error) {
 		normalized = expanded
 	}
 
-	// 2. Expand env vars
-	ret := os.ExpandEnv(normalized)
+	// 2. Expand env vars, including ${VAR:-default} and the like
+	ret, err := e.ExpandVars(normalized)
+	if err != nil {
+		return "", err
+	}
 
-	// 3. Handle tilde expansion
-	if strings.HasPrefix(ret, "~") {
-		home, err := os.UserHomeDir()
-		if err != nil {
-			return "", err
-		}
-		if ret == "~" {
-			ret = home
-		} else if ret[1] == '/' || ret[1] == '\\' {
-			ret = filepath.Join(home, ret[2:])
-		}
+	// 3. Handle tilde expansion, of ~ and ~user
+	if ret, err = e.ExpandTilde(ret); err != nil {
+		return "", err
 	}
 
 	// 4. Handle empty string special case
@@ -151,9 +174,14 @@ func CleanPath(raw string, useRelative bool) (string, error) {
 }
 
 func GetPathInfo(path string) (PathInfo, error) {
-	info, err := os.Lstat(path)
+	return GetPathInfoFS(vfs.OS, path)
+}
+
+// GetPathInfoFS is GetPathInfo on the given filesystem.
+func GetPathInfoFS(fsys vfs.FS, path string) (PathInfo, error) {
+	info, err := fsys.Lstat(path)
 	if err != nil {
-		if os.IsNotExist(err) {
+		if errors.Is(err, fs.ErrNotExist) {
 			return PathInfo{Exists: false}, nil
 		}
 		return PathInfo{}, err
@@ -166,7 +194,7 @@ func GetPathInfo(path string) (PathInfo, error) {
 	}
 
 	if pi.IsSymlink {
-		target, err := os.Readlink(path)
+		target, err := fsys.Readlink(path)
 		if err != nil {
 			return pi, err
 		}
@@ -176,21 +204,71 @@ func GetPathInfo(path string) (PathInfo, error) {
 	return pi, nil
 }
 
-// ValidateSymlink first ensures the symlink is indeed one at all, and that it is pointing
-// to a valid target file that exists.
+// ResolveLinkTarget returns the cleaned, absolute form of a symlink's contents. Relative contents
+// are resolved against the directory containing the symlink, as the OS does.
+func ResolveLinkTarget(symlinkPath, linkContents string) string {
+	if !filepath.IsAbs(linkContents) {
+		linkContents = filepath.Join(filepath.Dir(symlinkPath), linkContents)
+	}
+	if abs, err := filepath.Abs(linkContents); err == nil {
+		return abs
+	}
+	return filepath.Clean(linkContents)
+}
+
+// IsWithin reports whether path is dir or inside it. Both should be clean and absolute.
+func IsWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// SameLinkTarget reports whether a symlink at symlinkPath with the given contents points to the
+// same file as a symlink at that path with the desired contents would. Paths are first compared in
+// canonical form, then by following both to the file they ultimately refer to (e.g. through a chain
+// of symlinks), if it exists.
+func SameLinkTarget(symlinkPath, currContents, desiredContents string) bool {
+	return SameLinkTargetFS(vfs.OS, symlinkPath, currContents, desiredContents)
+}
+
+// SameLinkTargetFS is SameLinkTarget on the given filesystem.
+func SameLinkTargetFS(fsys vfs.FS, symlinkPath, currContents, desiredContents string) bool {
+	curr := ResolveLinkTarget(symlinkPath, currContents)
+	desired := ResolveLinkTarget(symlinkPath, desiredContents)
+	if curr == desired {
+		return true
+	}
+
+	currInfo, err := fsys.Stat(curr)
+	if err != nil {
+		return false
+	}
+	desiredInfo, err := fsys.Stat(desired)
+	if err != nil {
+		return false
+	}
+	return fsys.SameFile(currInfo, desiredInfo)
+}
+
+// ValidateSymlink first ensures the symlink is indeed one at all, and that following it (and any
+// symlinks it leads to) ends at a file that exists.
 func ValidateSymlink(symlinkPath string) (bool, error) {
 	symlinkInfo, err := GetPathInfo(symlinkPath)
-	if err != nil && !(symlinkInfo.Exists && symlinkInfo.IsSymlink) {
+	if err != nil {
 		return false, err
 	}
+	if !symlinkInfo.IsSymlink {
+		return false, fmt.Errorf("%v is not a symlink", symlinkPath)
+	}
 
-	targetPath, err := os.Readlink(symlinkPath)
+	chain, err := ResolveChain(symlinkPath)
 	if err != nil {
-		return false, fmt.Errorf("target file is not readable: %v", err)
+		return false, fmt.Errorf("could not validate target: %w", err)
 	}
-
-	if targetInfo, err := GetPathInfo(targetPath); !targetInfo.Exists || err != nil {
-		return false, fmt.Errorf("could not validate target: %v", err)
+	if !chain.Exists {
+		return false, fmt.Errorf("could not validate target %v: %w", chain.Final, fs.ErrNotExist)
 	}
 
 	return true, nil
@@ -231,48 +309,127 @@ func GetConfigDir() (string, error) {
 	return filepath.Join(homeDir, ".config", "trovl"), nil
 }
 
+// GetStateDir returns the path to the trovl state directory, used for data that should persist
+// between runs (e.g., hashes of rendered templates).
+// It prioritizes $XDG_STATE_HOME if defined, otherwise falls back to ~/.local/state (on all OSes)
+// Note: this does NOT guarantee that the directory exists yet.
+func GetStateDir() (string, error) {
+	xdgState := os.Getenv("XDG_STATE_HOME")
+	if xdgState != "" {
+		return filepath.Join(xdgState, "trovl"), nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".local", "state", "trovl"), nil
+}
+
+// HashFile returns the hex-encoded SHA-256 digest of a file's contents.
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// HashFileFS is HashFile on the given filesystem.
+func HashFileFS(fsys vfs.FS, path string) (string, error) {
+	data, err := fsys.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// fileMode returns the permission bits of mode along with its setuid, setgid and sticky bits, which
+// are kept when a file is copied or restored.
+func fileMode(mode fs.FileMode) fs.FileMode {
+	return mode & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+}
+
+// CopyFile copies src to dst, preserving its mode bits, modification time and (where permitted)
+// ownership. If src is a symlink, dst is created as a symlink with the same contents rather than a
+// copy of what it points to. The copy is written to a temporary file beside dst and renamed over it,
+// so dst is never left partially written.
 func CopyFile(src, dst string) error {
+	srcInfo, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if srcInfo.IsDir() {
+		return fmt.Errorf("cannot copy %v: %w", src, ErrIsDir)
+	}
+
+	if srcInfo.Mode()&fs.ModeSymlink != 0 {
+		return copySymlink(src, dst)
+	}
+
 	srcFile, err := os.Open(src)
 	if err != nil {
 		return err
 	}
 	defer srcFile.Close()
 
-	dstFile, err := os.Create(dst)
+	tmpFile, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp-*")
 	if err != nil {
 		return err
 	}
-	defer dstFile.Close()
+	tmpPath := tmpFile.Name()
+	// no-op once renamed into place
+	defer os.Remove(tmpPath)
 
-	_, err = io.Copy(dstFile, srcFile)
-	if err != nil {
-		return fmt.Errorf("could not copy file: %v", err)
+	if err := copyContents(tmpFile, srcFile); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("could not copy file: %w", err)
+	}
+	// best-effort: only privileged users can give away ownership
+	_ = copyOwner(tmpFile, srcInfo)
+	// after changing the owner, which clears the setuid and setgid bits
+	if err := tmpFile.Chmod(fileMode(srcInfo.Mode())); err != nil {
+		tmpFile.Close()
+		return err
 	}
 
-	err = dstFile.Sync()
-	if err != nil {
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
 		return err
 	}
-	return nil
-}
 
-// BackupFile copies a file into the cache directory, and returns the path it was stored to.
-// Default backup directory: $XDG_CACHE_HOME/trovl/backups
-func BackupFile(path, backupDir, timestampFormat string) (string, error) {
-	currTimeStr := time.Now().Format(timestampFormat)
-	base := filepath.Base(path)
-	ext := filepath.Ext(base)
-	name := strings.TrimSuffix(base, ext)
+	mtime := srcInfo.ModTime()
+	if err := os.Chtimes(tmpPath, mtime, mtime); err != nil {
+		return err
+	}
 
-	backupFilename := fmt.Sprintf("%s_backup_%s%s", name, currTimeStr, ext)
-	backupPath := filepath.Join(backupDir, backupFilename)
+	return os.Rename(tmpPath, dst)
+}
 
-	if err := os.MkdirAll(filepath.Dir(backupPath), 0755); err != nil {
-		return "", fmt.Errorf("could not create backup parent directory: %v", err)
+// copySymlink recreates the symlink at src as dst, pointing to the same (possibly relative) path.
+func copySymlink(src, dst string) error {
+	linkTarget, err := os.Readlink(src)
+	if err != nil {
+		return err
 	}
 
-	if err := CopyFile(path, backupPath); err != nil {
-		return "", fmt.Errorf("could not backup file: %v", err)
+	tmpPath := filepath.Join(filepath.Dir(dst), fmt.Sprintf(".%s.tmp-%d", filepath.Base(dst), time.Now().UnixNano()))
+	if err := os.Symlink(linkTarget, tmpPath); err != nil {
+		return err
 	}
-	return backupPath, nil
+	if err := os.Rename(tmpPath, dst); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
 }
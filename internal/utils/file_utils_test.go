@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"testing"
+	"time"
 
 	"github.com/sneha-afk/trovl/internal/utils"
 )
@@ -296,6 +297,36 @@ func TestCleanPath_AllCases(t *testing.T) {
 	}
 }
 
+func TestCleanPath_Home(t *testing.T) {
+	home := filepath.Join(t.TempDir(), "fakehome")
+	env := utils.PathEnv{Home: home}
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "~", want: home},
+		{in: "~/.vimrc", want: filepath.Join(home, ".vimrc")},
+		{in: "$HOME/.config/nvim", want: filepath.Join(home, ".config", "nvim")},
+		{in: "${HOME}", want: home},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := env.CleanPath(tt.in, false)
+			if err != nil {
+				t.Fatalf("CleanPath(%q) unexpected error: %v", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("CleanPath(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+
+	if got, _ := env.HomeDir(); got != home {
+		t.Errorf("HomeDir() = %q, want %q", got, home)
+	}
+}
+
 func TestGetPathInfo(t *testing.T) {
 	tmp := t.TempDir()
 
@@ -350,3 +381,127 @@ func TestGetPathInfo(t *testing.T) {
 		})
 	}
 }
+
+func TestCopyFile(t *testing.T) {
+	t.Run("preserves contents, mode and mtime", func(t *testing.T) {
+		tmp := t.TempDir()
+		src := filepath.Join(tmp, "script.sh")
+		dst := filepath.Join(tmp, "copy.sh")
+
+		if err := os.WriteFile(src, []byte("#!/bin/sh\necho hi\n"), 0755); err != nil {
+			t.Fatalf("setup: %v", err)
+		}
+		if err := os.Chmod(src, 0750|os.ModeSetuid); err != nil {
+			t.Fatalf("setup: %v", err)
+		}
+		mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		if err := os.Chtimes(src, mtime, mtime); err != nil {
+			t.Fatalf("setup: %v", err)
+		}
+
+		if err := utils.CopyFile(src, dst); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		data, err := os.ReadFile(dst)
+		if err != nil || string(data) != "#!/bin/sh\necho hi\n" {
+			t.Errorf("contents not copied: %q, %v", data, err)
+		}
+
+		info, err := os.Stat(dst)
+		if err != nil {
+			t.Fatalf("could not stat copy: %v", err)
+		}
+		if runtime.GOOS != "windows" && info.Mode()&(os.ModePerm|os.ModeSetuid) != 0750|os.ModeSetuid {
+			t.Errorf("mode not preserved: got %v", info.Mode())
+		}
+		if !info.ModTime().Equal(mtime) {
+			t.Errorf("mtime not preserved: got %v, want %v", info.ModTime(), mtime)
+		}
+
+		// no temporary files left behind
+		entries, _ := os.ReadDir(tmp)
+		if len(entries) != 2 {
+			t.Errorf("expected only src and dst in directory, got %d entries", len(entries))
+		}
+	})
+
+	t.Run("overwrites existing destination", func(t *testing.T) {
+		tmp := t.TempDir()
+		src := filepath.Join(tmp, "src")
+		dst := filepath.Join(tmp, "dst")
+		os.WriteFile(src, []byte("new"), 0644)
+		os.WriteFile(dst, []byte("old contents"), 0644)
+
+		if err := utils.CopyFile(src, dst); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if data, _ := os.ReadFile(dst); string(data) != "new" {
+			t.Errorf("expected destination to be replaced, got %q", data)
+		}
+	})
+
+	t.Run("copies symlinks as symlinks", func(t *testing.T) {
+		tmp := t.TempDir()
+		src := filepath.Join(tmp, "link")
+		dst := filepath.Join(tmp, "link_copy")
+
+		if err := os.Symlink("relative/target", src); err != nil {
+			t.Fatalf("setup: %v", err)
+		}
+
+		if err := utils.CopyFile(src, dst); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		got, err := os.Readlink(dst)
+		if err != nil {
+			t.Fatalf("expected copy to be a symlink: %v", err)
+		}
+		if got != "relative/target" {
+			t.Errorf("expected symlink contents to be preserved, got %q", got)
+		}
+	})
+
+	t.Run("error: directory", func(t *testing.T) {
+		tmp := t.TempDir()
+		if err := utils.CopyFile(tmp, filepath.Join(tmp, "copy")); err == nil {
+			t.Error("expected error copying a directory")
+		}
+	})
+}
+
+func TestSameLinkTarget(t *testing.T) {
+	tmp := t.TempDir()
+	target := filepath.Join(tmp, "target.txt")
+	os.WriteFile(target, []byte("target"), 0644)
+	os.Mkdir(filepath.Join(tmp, "sub"), 0755)
+
+	// a symlink to the target, for chains
+	via := filepath.Join(tmp, "via")
+	os.Symlink(target, via)
+
+	link := filepath.Join(tmp, "link")
+
+	tests := []struct {
+		name    string
+		curr    string
+		desired string
+		want    bool
+	}{
+		{name: "identical", curr: target, desired: target, want: true},
+		{name: "relative to link directory", curr: "target.txt", desired: target, want: true},
+		{name: "uncleaned", curr: filepath.Join(tmp, "sub", "..", "target.txt"), desired: target, want: true},
+		{name: "through a chain", curr: via, desired: target, want: true},
+		{name: "different file", curr: filepath.Join(tmp, "other"), desired: target, want: false},
+		{name: "both missing but different", curr: "a", desired: "b", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := utils.SameLinkTarget(link, tt.curr, tt.desired); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
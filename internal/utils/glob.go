@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/sneha-afk/trovl/internal/vfs"
+)
+
+// IsGlob reports whether path is a pattern, i.e. contains *, ? or [.
+func IsGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// GlobBase returns the leading directories of pattern that contain no pattern characters, which
+// every match is inside.
+func GlobBase(pattern string) string {
+	sep := string(filepath.Separator)
+	segs := strings.Split(pattern, sep)
+	i := slices.IndexFunc(segs, IsGlob)
+	if i < 0 {
+		return pattern
+	}
+	base := strings.Join(segs[:i], sep)
+	if base == filepath.VolumeName(base) {
+		base += sep
+	}
+	return base
+}
+
+// GlobFS returns the paths on fsys matching pattern, sorted, as filepath.Glob does. Additionally,
+// a ** path segment matches any number of directories, including none; patterns containing one
+// only match files, so nested directories are not matched along with their contents.
+// Directories that cannot be read are skipped, as are the entries skip returns true for (if not
+// nil), along with everything below them.
+func GlobFS(fsys vfs.FS, pattern string, skip func(path string, isDir bool) bool) ([]string, error) {
+	sep := string(filepath.Separator)
+	base := GlobBase(pattern)
+	rest := strings.TrimPrefix(strings.TrimPrefix(pattern, base), sep)
+	if rest == "" {
+		if _, err := fsys.Lstat(pattern); err != nil {
+			return nil, nil
+		}
+		return []string{pattern}, nil
+	}
+
+	segs := strings.Split(rest, sep)
+	for _, seg := range segs {
+		if _, err := filepath.Match(seg, ""); err != nil {
+			return nil, err
+		}
+	}
+	filesOnly := slices.Contains(segs, "**")
+
+	seen := map[string]bool{}
+	var matches []string
+	var walk func(dir string, segs []string)
+	walk = func(dir string, segs []string) {
+		if len(segs) == 0 {
+			if seen[dir] {
+				return
+			}
+			if filesOnly {
+				if info, err := fsys.Stat(dir); err != nil || info.IsDir() {
+					return
+				}
+			}
+			seen[dir] = true
+			matches = append(matches, dir)
+			return
+		}
+
+		entries, err := fsys.ReadDir(dir)
+		if err != nil {
+			return
+		}
+		if skip != nil {
+			entries = slices.DeleteFunc(entries, func(e fs.DirEntry) bool {
+				return skip(filepath.Join(dir, e.Name()), e.IsDir())
+			})
+		}
+		if segs[0] == "**" {
+			walk(dir, segs[1:])
+			for _, e := range entries {
+				// symlinked directories are not descended into, so links cannot lead around in a loop
+				if e.IsDir() {
+					walk(filepath.Join(dir, e.Name()), segs)
+				} else if len(segs) == 1 {
+					// a trailing ** matches every file below
+					walk(filepath.Join(dir, e.Name()), nil)
+				}
+			}
+			return
+		}
+		for _, e := range entries {
+			if ok, _ := filepath.Match(segs[0], e.Name()); !ok {
+				continue
+			}
+			child := filepath.Join(dir, e.Name())
+			if len(segs) > 1 {
+				if info, err := fsys.Stat(child); err != nil || !info.IsDir() {
+					continue
+				}
+			}
+			walk(child, segs[1:])
+		}
+	}
+	walk(base, segs)
+
+	slices.Sort(matches)
+	return matches, nil
+}
@@ -0,0 +1,77 @@
+package utils_test
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/sneha-afk/trovl/internal/utils"
+	"github.com/sneha-afk/trovl/internal/vfs"
+)
+
+func TestGlobBase(t *testing.T) {
+	slash := string(filepath.Separator)
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{filepath.Join(slash, "dotfiles", "bin", "*"), filepath.Join(slash, "dotfiles", "bin")},
+		{filepath.Join(slash, "dotfiles", "**", "*.sh"), filepath.Join(slash, "dotfiles")},
+		{filepath.Join(slash, "*"), slash},
+		{filepath.Join(slash, "dotfiles", "vimrc"), filepath.Join(slash, "dotfiles", "vimrc")},
+	}
+
+	for _, tt := range tests {
+		if got := utils.GlobBase(tt.pattern); got != tt.want {
+			t.Errorf("GlobBase(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestGlobFS(t *testing.T) {
+	root := filepath.Join(string(filepath.Separator), "dotfiles")
+	path := func(elem ...string) string { return filepath.Join(append([]string{root}, elem...)...) }
+
+	fsys := vfs.NewMemFS()
+	for _, f := range []string{path("bin", "a"), path("bin", "b.sh"), path("bin", "sub", "c.sh"), path("bin", "sub", "deep", "d.sh")} {
+		if err := fsys.MkdirAll(filepath.Dir(f), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := fsys.WriteFile(f, []byte("#!/bin/sh"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a symlinked directory looping back up is not descended into by **
+	if err := fsys.Symlink(path("bin"), path("bin", "sub", "loop")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		pattern string
+		want    []string
+		wantErr bool
+	}{
+		{name: "star", pattern: path("bin", "*"), want: []string{path("bin", "a"), path("bin", "b.sh"), path("bin", "sub")}},
+		{name: "extension", pattern: path("bin", "*.sh"), want: []string{path("bin", "b.sh")}},
+		{name: "nested star", pattern: path("*", "sub", "*.sh"), want: []string{path("bin", "sub", "c.sh")}},
+		{name: "double star", pattern: path("**", "*.sh"), want: []string{path("bin", "b.sh"), path("bin", "sub", "c.sh"), path("bin", "sub", "deep", "d.sh")}},
+		{name: "trailing double star", pattern: path("bin", "sub", "**"), want: []string{path("bin", "sub", "c.sh"), path("bin", "sub", "deep", "d.sh")}},
+		{name: "no matches", pattern: path("bin", "*.py"), want: nil},
+		{name: "missing base", pattern: path("nope", "*"), want: nil},
+		{name: "literal", pattern: path("bin", "a"), want: []string{path("bin", "a")}},
+		{name: "bad pattern", pattern: path("bin", "[a"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := utils.GlobFS(fsys, tt.pattern, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GlobFS(%q) error = %v, wantErr %v", tt.pattern, err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("GlobFS(%q) = %q, want %q", tt.pattern, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package utils
+
+import (
+	"io/fs"
+	"os"
+)
+
+// copyOwner is a no-op on platforms without Unix-style ownership.
+func copyOwner(f *os.File, info fs.FileInfo) error {
+	return nil
+}
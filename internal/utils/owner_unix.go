@@ -0,0 +1,18 @@
+//go:build unix
+
+package utils
+
+import (
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// copyOwner sets the owner and group of f to those described by info.
+func copyOwner(f *os.File, info fs.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return f.Chown(int(stat.Uid), int(stat.Gid))
+}
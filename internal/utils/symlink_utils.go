@@ -0,0 +1,145 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/sneha-afk/trovl/internal/vfs"
+)
+
+// MaxLinkHops is how many symlinks ResolveChain follows before giving up, matching common OS limits.
+const MaxLinkHops = 40
+
+// ErrLinkLoop is returned when a chain of symlinks loops back on itself, or is too long to follow.
+var ErrLinkLoop = errors.New("too many levels of symbolic links")
+
+// LinkChain is where a path ultimately leads after following every symlink along the way.
+type LinkChain struct {
+	Hops   []string // Each symlink followed in order, starting with the path resolved if it is one
+	Final  string   // Path the chain ends at, which is not a symlink
+	Exists bool     // Whether Final exists, false if the chain is dangling
+	IsDir  bool     // Whether Final is a directory
+}
+
+// Links reports whether the chain follows at least one symlink.
+func (c LinkChain) Links() bool {
+	return len(c.Hops) > 0
+}
+
+// Reaches reports whether path is one of the symlinks followed or where the chain ends.
+func (c LinkChain) Reaches(path string) bool {
+	return path == c.Final || slices.Contains(c.Hops, path)
+}
+
+// Paths returns the hops followed by where the chain ends, if it ends (i.e. does not loop).
+func (c LinkChain) Paths() []string {
+	paths := slices.Clone(c.Hops)
+	if c.Final != "" {
+		paths = append(paths, c.Final)
+	}
+	return paths
+}
+
+func (c LinkChain) String() string {
+	return strings.Join(c.Paths(), " -> ")
+}
+
+// ResolveChain follows the symlink at path, and any symlink it points to, until reaching a path
+// that is not a symlink. Relative symlinks are resolved against the directory containing them, as
+// the OS does. A chain that loops, or has more than MaxLinkHops symlinks, returns ErrLinkLoop
+// along with the hops followed so far.
+func ResolveChain(path string) (LinkChain, error) {
+	return ResolveChainFS(vfs.OS, path)
+}
+
+// ResolveChainFS is ResolveChain on the given filesystem.
+func ResolveChainFS(fsys vfs.FS, path string) (LinkChain, error) {
+	var chain LinkChain
+	curr, err := filepath.Abs(path)
+	if err != nil {
+		return chain, err
+	}
+
+	for {
+		info, err := fsys.Lstat(curr)
+		if errors.Is(err, fs.ErrNotExist) {
+			chain.Final = curr
+			return chain, nil
+		}
+		if err != nil {
+			return chain, err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			chain.Final, chain.Exists, chain.IsDir = curr, true, info.IsDir()
+			return chain, nil
+		}
+
+		if slices.Contains(chain.Hops, curr) {
+			return chain, fmt.Errorf("%w: %v loops back to %v", ErrLinkLoop, chain, curr)
+		}
+		chain.Hops = append(chain.Hops, curr)
+		if len(chain.Hops) > MaxLinkHops {
+			return chain, fmt.Errorf("%w: more than %d symlinks followed from %v", ErrLinkLoop, MaxLinkHops, path)
+		}
+
+		contents, err := fsys.Readlink(curr)
+		if err != nil {
+			return chain, err
+		}
+		curr = ResolveLinkTarget(curr, contents)
+	}
+}
+
+// EvalSymlinksFS returns path with every symlink along it resolved on fsys, like
+// filepath.EvalSymlinks, except that the part of path that does not exist is kept as it is. Following
+// more than MaxLinkHops symlinks returns ErrLinkLoop.
+func EvalSymlinksFS(fsys vfs.FS, path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	sep := string(filepath.Separator)
+	vol := filepath.VolumeName(path)
+	resolved, rest := vol+sep, strings.Split(path[len(vol):], sep)
+
+	for hops := 0; len(rest) > 0; {
+		name := rest[0]
+		rest = rest[1:]
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, name)
+		info, err := fsys.Lstat(next)
+		if errors.Is(err, fs.ErrNotExist) {
+			return filepath.Join(append([]string{next}, rest...)...), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		if hops++; hops > MaxLinkHops {
+			return "", fmt.Errorf("%w: more than %d symlinks followed from %v", ErrLinkLoop, MaxLinkHops, path)
+		}
+		contents, err := fsys.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		target := ResolveLinkTarget(next, contents)
+		vol = filepath.VolumeName(target)
+		resolved, rest = vol+sep, append(strings.Split(target[len(vol):], sep), rest...)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,160 @@
+package utils_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/sneha-afk/trovl/internal/utils"
+	"github.com/sneha-afk/trovl/internal/vfs"
+)
+
+func TestResolveChain(t *testing.T) {
+	tmp := t.TempDir()
+	path := func(name string) string { return filepath.Join(tmp, name) }
+
+	if err := os.WriteFile(path("file"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(path("dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	symlinks := map[string]string{
+		"direct":        path("file"),
+		"dir/relative":  filepath.Join("..", "direct"), // resolved against dir, not the working directory
+		"to-dir":        "dir",
+		"dangling":      path("missing"),
+		"loop-a":        "loop-b",
+		"loop-b":        "loop-a",
+		"self":          "self",
+		"chain-to-loop": "loop-a",
+	}
+	for link, target := range symlinks {
+		if err := os.Symlink(target, path(link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		wantHops []string
+		want     utils.LinkChain
+		wantErr  error
+	}{
+		{name: "not a symlink", path: "file", want: utils.LinkChain{Final: path("file"), Exists: true}},
+		{name: "direct", path: "direct", wantHops: []string{"direct"}, want: utils.LinkChain{Final: path("file"), Exists: true}},
+		{
+			name: "relative chain", path: "dir/relative", wantHops: []string{"dir/relative", "direct"},
+			want: utils.LinkChain{Final: path("file"), Exists: true},
+		},
+		{name: "to a directory", path: "to-dir", wantHops: []string{"to-dir"}, want: utils.LinkChain{Final: path("dir"), Exists: true, IsDir: true}},
+		{name: "dangling", path: "dangling", wantHops: []string{"dangling"}, want: utils.LinkChain{Final: path("missing")}},
+		{name: "missing", path: "missing", want: utils.LinkChain{Final: path("missing")}},
+		{name: "loop", path: "loop-a", wantHops: []string{"loop-a", "loop-b"}, wantErr: utils.ErrLinkLoop},
+		{name: "self loop", path: "self", wantHops: []string{"self"}, wantErr: utils.ErrLinkLoop},
+		{name: "leading into a loop", path: "chain-to-loop", wantHops: []string{"chain-to-loop", "loop-a", "loop-b"}, wantErr: utils.ErrLinkLoop},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain, err := utils.ResolveChain(path(tt.path))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+
+			var wantHops []string
+			for _, hop := range tt.wantHops {
+				wantHops = append(wantHops, path(hop))
+			}
+			if !slices.Equal(chain.Hops, wantHops) {
+				t.Errorf("expected hops %v, got %v", wantHops, chain.Hops)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if chain.Final != tt.want.Final || chain.Exists != tt.want.Exists || chain.IsDir != tt.want.IsDir {
+				t.Errorf("expected %+v, got %+v", tt.want, chain)
+			}
+		})
+	}
+}
+
+func TestResolveChain_TooManyHops(t *testing.T) {
+	tmp := t.TempDir()
+	link := func(i int) string { return filepath.Join(tmp, fmt.Sprint(i)) }
+
+	if err := os.WriteFile(link(utils.MaxLinkHops+1), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i := utils.MaxLinkHops; i >= 0; i-- {
+		if err := os.Symlink(link(i+1), link(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if chain, err := utils.ResolveChain(link(1)); err != nil || !chain.Exists || len(chain.Hops) != utils.MaxLinkHops {
+		t.Errorf("expected %d hops to be followed, got %+v (err: %v)", utils.MaxLinkHops, chain, err)
+	}
+	if _, err := utils.ResolveChain(link(0)); !errors.Is(err, utils.ErrLinkLoop) {
+		t.Errorf("expected ErrLinkLoop for more than %d hops, got %v", utils.MaxLinkHops, err)
+	}
+}
+
+func TestEvalSymlinksFS(t *testing.T) {
+	tmp := t.TempDir()
+	path := func(name string) string { return filepath.Join(tmp, name) }
+
+	if err := os.MkdirAll(path(filepath.Join("real", "sub")), 0755); err != nil {
+		t.Fatal(err)
+	}
+	symlinks := map[string]string{
+		"abs":      path("real"),
+		"rel":      "real",
+		"chain":    "abs",
+		"up":       filepath.Join("real", "sub", ".."),
+		"loop-a":   "loop-b",
+		"loop-b":   "loop-a",
+		"dangling": path("missing"),
+	}
+	for link, target := range symlinks {
+		if err := os.Symlink(target, path(link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the temporary directory itself may be behind a symlink, e.g. on macOS
+	realDir, err := filepath.EvalSymlinks(path("real"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr error
+	}{
+		{name: "no symlinks", path: path("real/sub"), want: filepath.Join(realDir, "sub")},
+		{name: "absolute parent", path: path("abs/sub/file"), want: filepath.Join(realDir, "sub", "file")},
+		{name: "relative parent", path: path("rel/sub"), want: filepath.Join(realDir, "sub")},
+		{name: "chain of parents", path: path("chain/missing/file"), want: filepath.Join(realDir, "missing", "file")},
+		{name: "dot-dot in a symlink", path: path("up/sub"), want: filepath.Join(realDir, "sub")},
+		{name: "dangling", path: path("dangling/file"), want: filepath.Join(filepath.Dir(realDir), "missing", "file")},
+		{name: "loop", path: path("loop-a/file"), wantErr: utils.ErrLinkLoop},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := utils.EvalSymlinksFS(vfs.OS, tt.path)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr == nil && got != tt.want {
+				t.Errorf("EvalSymlinksFS(%v) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
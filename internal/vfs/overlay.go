@@ -0,0 +1,456 @@
+package vfs
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// maxHops bounds how many symlinks are followed when resolving a path, as the OS does.
+const maxHops = 40
+
+type entryKind int
+
+const (
+	entryRemoved entryKind = iota
+	entryFile
+	entryDir
+	entrySymlink
+)
+
+type entry struct {
+	kind   entryKind
+	data   []byte
+	target string
+	mode   fs.FileMode
+}
+
+// Overlay is an in-memory layer of changes over a base FS. Reads fall through to the base for
+// paths the overlay has not changed, and writes only ever modify the overlay, so the base is never
+// modified. This is used to simulate a sequence of actions without carrying them out.
+type Overlay struct {
+	base    FS
+	entries map[string]*entry // Keyed by absolute path, with symlinks in parent directories resolved
+}
+
+// NewOverlay returns an empty overlay over base.
+func NewOverlay(base FS) *Overlay {
+	return &Overlay{base: base, entries: map[string]*entry{}}
+}
+
+// NewMemFS returns an empty in-memory filesystem, containing only the root directory.
+func NewMemFS() *Overlay {
+	return NewOverlay(emptyFS{})
+}
+
+// Changed reports whether the overlay has changed what is at name.
+func (o *Overlay) Changed(name string) bool {
+	p, err := o.resolveParents(name)
+	if err != nil {
+		return true
+	}
+	_, ok := o.entries[p]
+	return ok
+}
+
+func pathError(op, name string, err error) error {
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// resolveParents returns the absolute form of name with any symlinks the overlay created in its
+// parent directories resolved, so entries are found no matter which path they are reached through.
+func (o *Overlay) resolveParents(name string) (string, error) {
+	p, err := filepath.Abs(name)
+	if err != nil {
+		return "", err
+	}
+
+	for hops := 0; ; hops++ {
+		if hops > maxHops {
+			return "", syscall.ELOOP
+		}
+		resolved, done, err := o.resolveOnce(p)
+		if err != nil || done {
+			return resolved, err
+		}
+		p = resolved
+	}
+}
+
+// resolveOnce resolves the first overlay symlink among the parent directories of p, if any.
+func (o *Overlay) resolveOnce(p string) (string, bool, error) {
+	vol := filepath.VolumeName(p)
+	parts := strings.Split(strings.TrimPrefix(p[len(vol):], string(filepath.Separator)), string(filepath.Separator))
+
+	prefix := vol + string(filepath.Separator)
+	for i, part := range parts[:len(parts)-1] {
+		prefix = filepath.Join(prefix, part)
+		e, ok := o.entries[prefix]
+		if !ok {
+			continue
+		}
+		switch e.kind {
+		case entryRemoved:
+			return "", false, fs.ErrNotExist
+		case entryFile:
+			return "", false, syscall.ENOTDIR
+		case entrySymlink:
+			target := e.target
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(prefix), target)
+			}
+			rest := filepath.Join(parts[i+1:]...)
+			return filepath.Join(target, rest), false, nil
+		}
+	}
+	return filepath.Clean(p), true, nil
+}
+
+func (o *Overlay) Lstat(name string) (fs.FileInfo, error) {
+	p, err := o.resolveParents(name)
+	if err != nil {
+		return nil, pathError("lstat", name, err)
+	}
+	e, ok := o.entries[p]
+	if !ok {
+		return o.base.Lstat(p)
+	}
+	if e.kind == entryRemoved {
+		return nil, pathError("lstat", name, fs.ErrNotExist)
+	}
+	return &memInfo{name: filepath.Base(p), e: e}, nil
+}
+
+// follow resolves name through symlinks to the path of what it ultimately refers to.
+func (o *Overlay) follow(name string) (string, error) {
+	p := name
+	for range maxHops {
+		info, err := o.Lstat(p)
+		if err != nil {
+			return p, err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			return o.resolveParents(p)
+		}
+		target, err := o.Readlink(p)
+		if err != nil {
+			return p, err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(p), target)
+		}
+		p = target
+	}
+	return p, syscall.ELOOP
+}
+
+func (o *Overlay) Stat(name string) (fs.FileInfo, error) {
+	p, err := o.follow(name)
+	if err != nil {
+		var pe *fs.PathError
+		if errors.As(err, &pe) {
+			return nil, pathError("stat", name, pe.Err)
+		}
+		return nil, pathError("stat", name, err)
+	}
+	return o.Lstat(p)
+}
+
+func (o *Overlay) Readlink(name string) (string, error) {
+	p, err := o.resolveParents(name)
+	if err != nil {
+		return "", pathError("readlink", name, err)
+	}
+	e, ok := o.entries[p]
+	if !ok {
+		return o.base.Readlink(p)
+	}
+	switch e.kind {
+	case entryRemoved:
+		return "", pathError("readlink", name, fs.ErrNotExist)
+	case entrySymlink:
+		return e.target, nil
+	default:
+		return "", pathError("readlink", name, syscall.EINVAL)
+	}
+}
+
+func (o *Overlay) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := o.follow(name)
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+	info, err := o.Lstat(p)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, pathError("readdir", name, syscall.ENOTDIR)
+	}
+
+	byName := map[string]fs.DirEntry{}
+	if _, replaced := o.entries[p]; !replaced {
+		base, err := o.base.ReadDir(p)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		for _, d := range base {
+			byName[d.Name()] = d
+		}
+	}
+	for path, e := range o.entries {
+		if filepath.Dir(path) != p || path == p {
+			continue
+		}
+		base := filepath.Base(path)
+		if e.kind == entryRemoved {
+			delete(byName, base)
+		} else {
+			byName[base] = fs.FileInfoToDirEntry(&memInfo{name: base, e: e})
+		}
+	}
+
+	entries := make([]fs.DirEntry, 0, len(byName))
+	for _, d := range byName {
+		entries = append(entries, d)
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	return entries, nil
+}
+
+func (o *Overlay) ReadFile(name string) ([]byte, error) {
+	p, err := o.follow(name)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	e, ok := o.entries[p]
+	if !ok {
+		return o.base.ReadFile(p)
+	}
+	switch e.kind {
+	case entryFile:
+		return slices.Clone(e.data), nil
+	case entryDir:
+		return nil, pathError("read", name, syscall.EISDIR)
+	default:
+		return nil, pathError("open", name, fs.ErrNotExist)
+	}
+}
+
+// checkParent returns an error unless the parent directory of p exists.
+func (o *Overlay) checkParent(op, name, p string) error {
+	info, err := o.Stat(filepath.Dir(p))
+	if err != nil {
+		return pathError(op, name, fs.ErrNotExist)
+	}
+	if !info.IsDir() {
+		return pathError(op, name, syscall.ENOTDIR)
+	}
+	return nil
+}
+
+func (o *Overlay) Symlink(oldname, newname string) error {
+	p, err := o.resolveParents(newname)
+	if err != nil {
+		return pathError("symlink", newname, err)
+	}
+	if _, err := o.Lstat(p); err == nil {
+		return pathError("symlink", newname, fs.ErrExist)
+	}
+	if err := o.checkParent("symlink", newname, p); err != nil {
+		return err
+	}
+	o.entries[p] = &entry{kind: entrySymlink, target: oldname, mode: fs.ModeSymlink | 0o777}
+	return nil
+}
+
+func (o *Overlay) Remove(name string) error {
+	p, err := o.resolveParents(name)
+	if err != nil {
+		return pathError("remove", name, err)
+	}
+	info, err := o.Lstat(p)
+	if err != nil {
+		return pathError("remove", name, fs.ErrNotExist)
+	}
+	if info.IsDir() {
+		children, err := o.ReadDir(p)
+		if err != nil {
+			return err
+		}
+		if len(children) > 0 {
+			return pathError("remove", name, syscall.ENOTEMPTY)
+		}
+	}
+	o.entries[p] = &entry{kind: entryRemoved}
+	return nil
+}
+
+func (o *Overlay) Rename(oldpath, newpath string) error {
+	src, err := o.resolveParents(oldpath)
+	if err != nil {
+		return pathError("rename", oldpath, err)
+	}
+	dst, err := o.resolveParents(newpath)
+	if err != nil {
+		return pathError("rename", newpath, err)
+	}
+	info, err := o.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if err := o.checkParent("rename", newpath, dst); err != nil {
+		return err
+	}
+	if dstInfo, err := o.Lstat(dst); err == nil && dstInfo.IsDir() {
+		return pathError("rename", newpath, syscall.EEXIST)
+	}
+
+	e := &entry{mode: info.Mode()}
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		e.kind = entrySymlink
+		if e.target, err = o.Readlink(src); err != nil {
+			return err
+		}
+	case info.IsDir():
+		// moving a directory would mean moving everything below it, which is never simulated
+		return pathError("rename", oldpath, syscall.EISDIR)
+	default:
+		e.kind = entryFile
+		if e.data, err = o.ReadFile(src); err != nil {
+			return err
+		}
+	}
+
+	o.entries[dst] = e
+	o.entries[src] = &entry{kind: entryRemoved}
+	return nil
+}
+
+func (o *Overlay) MkdirAll(path string, perm fs.FileMode) error {
+	p, err := o.resolveParents(path)
+	if err != nil {
+		return pathError("mkdir", path, err)
+	}
+	if info, err := o.Stat(p); err == nil {
+		if info.IsDir() {
+			return nil
+		}
+		return pathError("mkdir", path, syscall.ENOTDIR)
+	}
+
+	if parent := filepath.Dir(p); parent != p {
+		if err := o.MkdirAll(parent, perm); err != nil {
+			return err
+		}
+	}
+	// the parent may have been reached through a symlink, so resolve again
+	if p, err = o.resolveParents(p); err != nil {
+		return pathError("mkdir", path, err)
+	}
+	o.entries[p] = &entry{kind: entryDir, mode: fs.ModeDir | perm.Perm()}
+	return nil
+}
+
+func (o *Overlay) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	// like the OS, writing to a symlink writes to what it points to
+	p, err := o.follow(name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return pathError("open", name, err)
+	}
+	if info, err := o.Lstat(p); err == nil {
+		if info.IsDir() {
+			return pathError("open", name, syscall.EISDIR)
+		}
+		perm = info.Mode()
+	} else if err := o.checkParent("open", name, p); err != nil {
+		return err
+	}
+	if p, err = o.resolveParents(p); err != nil {
+		return pathError("open", name, err)
+	}
+	o.entries[p] = &entry{kind: entryFile, data: slices.Clone(data), mode: perm.Perm()}
+	return nil
+}
+
+func (o *Overlay) SameFile(fi1, fi2 fs.FileInfo) bool {
+	m1, ok1 := fi1.(*memInfo)
+	m2, ok2 := fi2.(*memInfo)
+	switch {
+	case ok1 && ok2:
+		return m1.e == m2.e
+	case ok1 || ok2:
+		return false
+	default:
+		return o.base.SameFile(fi1, fi2)
+	}
+}
+
+// memInfo describes an entry of an overlay. Entries have no modification time, so that the same
+// sequence of changes always simulates the same result.
+type memInfo struct {
+	name string
+	e    *entry
+}
+
+func (m *memInfo) Name() string       { return m.name }
+func (m *memInfo) Size() int64        { return int64(len(m.e.data)) }
+func (m *memInfo) Mode() fs.FileMode  { return m.e.mode }
+func (m *memInfo) ModTime() time.Time { return time.Time{} }
+func (m *memInfo) IsDir() bool        { return m.e.kind == entryDir }
+func (m *memInfo) Sys() any           { return nil }
+
+// emptyFS has nothing in it but the root directory.
+type emptyFS struct{}
+
+var rootInfo = &memInfo{name: string(filepath.Separator), e: &entry{kind: entryDir, mode: fs.ModeDir | 0o755}}
+
+func isRoot(name string) bool {
+	return filepath.Dir(name) == name
+}
+
+func (emptyFS) Lstat(name string) (fs.FileInfo, error) {
+	if isRoot(name) {
+		return rootInfo, nil
+	}
+	return nil, pathError("lstat", name, fs.ErrNotExist)
+}
+
+func (e emptyFS) Stat(name string) (fs.FileInfo, error) { return e.Lstat(name) }
+
+func (emptyFS) Readlink(name string) (string, error) {
+	return "", pathError("readlink", name, fs.ErrNotExist)
+}
+
+func (emptyFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if isRoot(name) {
+		return nil, nil
+	}
+	return nil, pathError("readdir", name, fs.ErrNotExist)
+}
+
+func (emptyFS) ReadFile(name string) ([]byte, error) {
+	return nil, pathError("open", name, fs.ErrNotExist)
+}
+
+func (emptyFS) Symlink(oldname, newname string) error { return errors.ErrUnsupported }
+func (emptyFS) Remove(name string) error              { return errors.ErrUnsupported }
+func (emptyFS) Rename(oldpath, newpath string) error  { return errors.ErrUnsupported }
+
+func (emptyFS) MkdirAll(path string, perm fs.FileMode) error { return errors.ErrUnsupported }
+
+func (emptyFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return errors.ErrUnsupported
+}
+
+func (emptyFS) SameFile(fi1, fi2 fs.FileInfo) bool { return fi1 == fi2 }
+
+// HostPath returns where name is on the host, as the base filesystem would place it.
+func (o *Overlay) HostPath(name string) string {
+	return HostPath(o.base, name)
+}
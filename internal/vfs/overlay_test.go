@@ -0,0 +1,107 @@
+package vfs_test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sneha-afk/trovl/internal/vfs"
+)
+
+func TestOverlay_DoesNotModifyBase(t *testing.T) {
+	tmp := t.TempDir()
+	file := filepath.Join(tmp, "file")
+	if err := os.WriteFile(file, []byte("base"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	o := vfs.NewOverlay(vfs.OS)
+	if err := o.Remove(file); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if _, err := o.Lstat(file); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Lstat() after Remove() error = %v, want ErrNotExist", err)
+	}
+	if err := o.MkdirAll(filepath.Join(tmp, "a", "b"), 0o755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if err := o.Symlink(filepath.Join(tmp, "target"), filepath.Join(tmp, "a", "b", "link")); err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+
+	if data, err := os.ReadFile(file); err != nil || string(data) != "base" {
+		t.Errorf("base file = %q, %v, want unchanged", data, err)
+	}
+	if _, err := os.Lstat(filepath.Join(tmp, "a")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("directory was created in base, error = %v", err)
+	}
+	if !o.Changed(file) || o.Changed(filepath.Join(tmp, "other")) {
+		t.Error("Changed() does not match what the overlay modified")
+	}
+}
+
+func TestMemFS(t *testing.T) {
+	m := vfs.NewMemFS()
+	root := string(filepath.Separator)
+	dir := filepath.Join(root, "home", "user")
+	target := filepath.Join(root, "dotfiles", "config")
+
+	if err := m.Symlink(target, filepath.Join(dir, "link")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Symlink() without parent error = %v, want ErrNotExist", err)
+	}
+	if err := m.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if err := m.MkdirAll(target, 0o755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if err := m.WriteFile(filepath.Join(target, "rc"), []byte("contents"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	link := filepath.Join(dir, "config")
+	if err := m.Symlink(target, link); err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+	if err := m.Symlink(target, link); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Symlink() over existing error = %v, want ErrExist", err)
+	}
+
+	info, err := m.Lstat(link)
+	if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		t.Fatalf("Lstat() = %v, %v, want a symlink", info, err)
+	}
+	if got, _ := m.Readlink(link); got != target {
+		t.Errorf("Readlink() = %q, want %q", got, target)
+	}
+
+	// paths through the symlinked directory reach the same file
+	data, err := m.ReadFile(filepath.Join(link, "rc"))
+	if err != nil || string(data) != "contents" {
+		t.Errorf("ReadFile() through symlink = %q, %v", data, err)
+	}
+	if info, err := m.Stat(link); err != nil || !info.IsDir() {
+		t.Errorf("Stat() = %v, %v, want the target directory", info, err)
+	}
+
+	entries, err := m.ReadDir(dir)
+	if err != nil || len(entries) != 1 || entries[0].Name() != "config" {
+		t.Errorf("ReadDir() = %v, %v, want [config]", entries, err)
+	}
+
+	if err := m.Remove(target); err == nil {
+		t.Error("Remove() of non-empty directory succeeded")
+	}
+	renamed := filepath.Join(dir, "moved")
+	if err := m.Rename(link, renamed); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+	if _, err := m.Lstat(link); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Lstat() of renamed path error = %v, want ErrNotExist", err)
+	}
+	if got, _ := m.Readlink(renamed); got != target {
+		t.Errorf("Readlink() after Rename() = %q, want %q", got, target)
+	}
+}
@@ -0,0 +1,211 @@
+package vfs
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Hoster is implemented by filesystems whose paths are not where the files are on the host, e.g.
+// Rooted.
+type Hoster interface {
+	// HostPath returns where name actually is on the host.
+	HostPath(name string) string
+}
+
+// HostPath returns the absolute path name on fsys refers to on the host, e.g. for recording it in
+// the journal, which is undone without any root.
+func HostPath(fsys FS, name string) string {
+	if h, ok := fsys.(Hoster); ok {
+		return h.HostPath(name)
+	}
+	if abs, err := filepath.Abs(name); err == nil {
+		return abs
+	}
+	return name
+}
+
+// Rooted returns base with every path interpreted inside root, as if root were mounted as /, e.g.
+// to place links into a container or disk image. Symlink contents are written and read as they are,
+// so links are correct once root is mounted, and symlinks are followed within root.
+func Rooted(base FS, root string) FS {
+	return rootedFS{base: base, root: root}
+}
+
+type rootedFS struct {
+	base FS
+	root string
+}
+
+// HostPath returns where name is on the host, with the symlinks among its parent directories
+// followed inside root. If they cannot be followed, name is placed inside root as it is.
+func (r rootedFS) HostPath(name string) string {
+	if p, err := r.walk(name, false); err == nil {
+		return r.host(p)
+	}
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		abs = filepath.Clean(name)
+	}
+	return r.host(abs)
+}
+
+// host returns where the clean, absolute path p inside root is on the host, without following
+// anything.
+func (r rootedFS) host(p string) string {
+	return filepath.Join(r.root, strings.TrimPrefix(p, filepath.VolumeName(p)))
+}
+
+// walk resolves name inside root as a chroot would: every symlink among its parent directories is
+// followed within root, as is the last element if follow is set, and .. never leaves root. The path
+// inside root is returned, with the part that does not exist kept as it is.
+func (r rootedFS) walk(name string, follow bool) (string, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return "", err
+	}
+	sep := string(filepath.Separator)
+	resolved, rest := sep, strings.Split(strings.TrimPrefix(abs, filepath.VolumeName(abs)), sep)
+
+	for hops := 0; len(rest) > 0; {
+		elem := rest[0]
+		rest = rest[1:]
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, elem)
+		if len(rest) == 0 && !follow {
+			return next, nil
+		}
+		info, err := r.base.Lstat(r.host(next))
+		if errors.Is(err, fs.ErrNotExist) {
+			// what does not exist is reported by the caller
+			return filepath.Join(append([]string{next}, rest...)...), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		if hops++; hops > maxHops {
+			return "", syscall.ELOOP
+		}
+		target, err := r.base.Readlink(r.host(next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = sep
+			target = strings.TrimPrefix(target, filepath.VolumeName(target))
+		}
+		rest = append(strings.Split(target, sep), rest...)
+	}
+	return resolved, nil
+}
+
+// hostPath is where name is on the host, following the last element too if follow is set.
+func (r rootedFS) hostPath(op, name string, follow bool) (string, error) {
+	p, err := r.walk(name, follow)
+	if err != nil {
+		return "", pathError(op, name, err)
+	}
+	return r.host(p), nil
+}
+
+func (r rootedFS) Lstat(name string) (fs.FileInfo, error) {
+	p, err := r.hostPath("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return r.base.Lstat(p)
+}
+
+func (r rootedFS) Stat(name string) (fs.FileInfo, error) {
+	p, err := r.hostPath("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return r.base.Lstat(p)
+}
+
+func (r rootedFS) Readlink(name string) (string, error) {
+	p, err := r.hostPath("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	return r.base.Readlink(p)
+}
+
+func (r rootedFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := r.hostPath("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return r.base.ReadDir(p)
+}
+
+func (r rootedFS) ReadFile(name string) ([]byte, error) {
+	p, err := r.hostPath("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return r.base.ReadFile(p)
+}
+
+func (r rootedFS) Symlink(oldname, newname string) error {
+	p, err := r.hostPath("symlink", newname, false)
+	if err != nil {
+		return err
+	}
+	return r.base.Symlink(oldname, p)
+}
+
+func (r rootedFS) Remove(name string) error {
+	p, err := r.hostPath("remove", name, false)
+	if err != nil {
+		return err
+	}
+	return r.base.Remove(p)
+}
+
+func (r rootedFS) Rename(oldpath, newpath string) error {
+	oldHost, err := r.hostPath("rename", oldpath, false)
+	if err != nil {
+		return err
+	}
+	newHost, err := r.hostPath("rename", newpath, false)
+	if err != nil {
+		return err
+	}
+	return r.base.Rename(oldHost, newHost)
+}
+
+func (r rootedFS) MkdirAll(path string, perm fs.FileMode) error {
+	p, err := r.hostPath("mkdir", path, true)
+	if err != nil {
+		return err
+	}
+	return r.base.MkdirAll(p, perm)
+}
+
+func (r rootedFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	p, err := r.hostPath("open", name, true)
+	if err != nil {
+		return err
+	}
+	return r.base.WriteFile(p, data, perm)
+}
+
+func (r rootedFS) SameFile(fi1, fi2 fs.FileInfo) bool {
+	return r.base.SameFile(fi1, fi2)
+}
@@ -0,0 +1,126 @@
+package vfs_test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sneha-afk/trovl/internal/vfs"
+)
+
+func TestRooted(t *testing.T) {
+	root := t.TempDir()
+	r := vfs.Rooted(vfs.OS, root)
+
+	slash := string(filepath.Separator)
+	dotfiles := filepath.Join(slash, "home", "user", "dotfiles")
+	target := filepath.Join(dotfiles, "vimrc")
+	link := filepath.Join(slash, "home", "user", ".vimrc")
+
+	if err := r.MkdirAll(dotfiles, 0o755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if err := r.WriteFile(target, []byte("set nocompatible"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "home", "user", "dotfiles", "vimrc")); err != nil {
+		t.Fatalf("expected file to be written inside root, got %v", err)
+	}
+
+	if err := r.Symlink(target, link); err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+	if got, _ := os.Readlink(filepath.Join(root, "home", "user", ".vimrc")); got != target {
+		t.Errorf("link contents = %q, want %q as seen once root is mounted", got, target)
+	}
+
+	// the absolute link contents are followed inside root, not on the host
+	if data, err := r.ReadFile(link); err != nil || string(data) != "set nocompatible" {
+		t.Errorf("ReadFile() through link = %q, %v", data, err)
+	}
+	info, err := r.Stat(link)
+	if err != nil || info.IsDir() {
+		t.Errorf("Stat() through link = %v, %v, want the target file", info, err)
+	}
+
+	loop := filepath.Join(slash, "loop")
+	if err := r.Symlink(loop, loop); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Stat(loop); err == nil {
+		t.Error("Stat() of a looping link succeeded, want an error")
+	}
+
+	if err := r.Remove(link); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if _, err := r.Lstat(link); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Lstat() after Remove() error = %v, want ErrNotExist", err)
+	}
+
+	if got, want := vfs.HostPath(vfs.NewOverlay(r), link), filepath.Join(root, "home", "user", ".vimrc"); got != want {
+		t.Errorf("HostPath() = %q, want %q", got, want)
+	}
+}
+
+func TestRooted_NoEscape(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "img")
+	outside := filepath.Join(dir, "outside")
+	for _, d := range []string{filepath.Join(root, "home", "me"), outside} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := vfs.Rooted(vfs.OS, root)
+
+	slash := string(filepath.Separator)
+	home := filepath.Join(slash, "home", "me")
+	// both links would point at the host's outside dir if followed there
+	if err := os.Symlink(outside, filepath.Join(root, "home", "me", ".config")); err != nil {
+		t.Fatal(err)
+	}
+	up := filepath.Join("..", "..", "..", "..", "..", "..", "..", "..", filepath.Base(dir), "outside")
+	if err := os.Symlink(up, filepath.Join(root, "home", "me", ".local")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, via := range []string{".config", ".local"} {
+		t.Run(via, func(t *testing.T) {
+			sub := filepath.Join(home, via, "app")
+			if err := r.MkdirAll(sub, 0o755); err != nil {
+				t.Fatalf("MkdirAll() error = %v", err)
+			}
+			if err := r.WriteFile(filepath.Join(sub, "conf"), []byte("x"), 0o644); err != nil {
+				t.Fatalf("WriteFile() error = %v", err)
+			}
+			link := filepath.Join(sub, "link")
+			if err := r.Symlink("conf", link); err != nil {
+				t.Fatalf("Symlink() error = %v", err)
+			}
+			if _, err := r.Lstat(link); err != nil {
+				t.Errorf("Lstat() error = %v", err)
+			}
+			if err := r.Rename(link, filepath.Join(sub, "moved")); err != nil {
+				t.Fatalf("Rename() error = %v", err)
+			}
+			if err := r.Remove(filepath.Join(sub, "moved")); err != nil {
+				t.Fatalf("Remove() error = %v", err)
+			}
+			if got := vfs.HostPath(r, sub); !strings.HasPrefix(got, root+slash) {
+				t.Errorf("HostPath() = %q, want inside %q", got, root)
+			}
+
+			entries, err := os.ReadDir(outside)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) > 0 {
+				t.Errorf("%d entries were created outside root", len(entries))
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package vfs
+
+import (
+	"io/fs"
+	"sync"
+)
+
+// Changer is implemented by filesystems that simulate changes, e.g. Overlay.
+type Changer interface {
+	// Changed reports whether name was modified in the simulation.
+	Changed(name string) bool
+}
+
+// Synchronized returns fsys made safe for concurrent use by allowing only one call at a time.
+// The real filesystem is already safe and is returned as is.
+func Synchronized(fsys FS) FS {
+	switch fsys.(type) {
+	case osFS, *syncFS:
+		return fsys
+	}
+	return &syncFS{fs: fsys}
+}
+
+type syncFS struct {
+	mu sync.Mutex
+	fs FS
+}
+
+func (s *syncFS) Lstat(name string) (fs.FileInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fs.Lstat(name)
+}
+
+func (s *syncFS) Stat(name string) (fs.FileInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fs.Stat(name)
+}
+
+func (s *syncFS) Readlink(name string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fs.Readlink(name)
+}
+
+func (s *syncFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fs.ReadDir(name)
+}
+
+func (s *syncFS) ReadFile(name string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fs.ReadFile(name)
+}
+
+func (s *syncFS) Symlink(oldname, newname string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fs.Symlink(oldname, newname)
+}
+
+func (s *syncFS) Remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fs.Remove(name)
+}
+
+func (s *syncFS) Rename(oldpath, newpath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fs.Rename(oldpath, newpath)
+}
+
+func (s *syncFS) MkdirAll(path string, perm fs.FileMode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fs.MkdirAll(path, perm)
+}
+
+func (s *syncFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fs.WriteFile(name, data, perm)
+}
+
+func (s *syncFS) SameFile(fi1, fi2 fs.FileInfo) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fs.SameFile(fi1, fi2)
+}
+
+func (s *syncFS) Changed(name string) bool {
+	c, ok := s.fs.(Changer)
+	if !ok {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return c.Changed(name)
+}
+
+func (s *syncFS) HostPath(name string) string {
+	return HostPath(s.fs, name)
+}
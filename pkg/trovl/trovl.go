@@ -0,0 +1,361 @@
+/*
+Package trovl is the public Go API of trovl, for embedding it in other tools. Every call takes its own
+Options and context, never touches the terminal, and returns errors rather than exiting.
+*/
+package trovl
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+
+	"github.com/sneha-afk/trovl/internal/ledger"
+	"github.com/sneha-afk/trovl/internal/links"
+	"github.com/sneha-afk/trovl/internal/lock"
+	"github.com/sneha-afk/trovl/internal/manifests"
+	"github.com/sneha-afk/trovl/internal/report"
+	"github.com/sneha-afk/trovl/internal/state"
+)
+
+// Options configures a single call.
+type Options struct {
+	DryRun           bool         // Walk through the changes without making them
+	UseRelative      bool         // Retain relative paths to targets
+	KeepGoing        bool         // Attempt every link even if some fail, joining their errors
+	BackupDir        string       // Where to back up replaced files (default: $XDG_CACHE_HOME/trovl/backups)
+	BackupCompress   bool         // Gzip the contents of backed up files
+	Jobs             int          // Number of links worked on at once, 1 or less to go one at a time
+	Force            bool         // Replace symlinks even if trovl did not place them
+	DotfilesRoots    []string     // Symlinks pointing inside these directories are treated as placed by trovl
+	LinkRoots        []string     // Links are only placed inside these directories (default: the home directory)
+	AllowOutsideHome bool         // Place links outside LinkRoots anyway
+	Root             string       // Place links inside this directory as if it were mounted as /, empty for the real root
+	Home             string       // Home directory ~ and $HOME expand to in paths, empty for the user's
+	StrictVars       bool         // Variables in paths that are not set are an error, rather than empty
+	WaitForLock      bool         // Wait for another trovl process to finish, rather than failing with ErrLocked
+	Logger           *slog.Logger // Receives diagnostic logs, nil to discard them
+	Resolver         Resolver     // Decides conflicts, nil to decline them all
+}
+
+// Conflict is a change that needs confirmation before it is made, e.g. replacing an existing file.
+type Conflict = state.Conflict
+
+// Resolver decides whether a change that needs confirmation goes ahead.
+type Resolver = state.Resolver
+
+// ResolverFunc adapts a function to a Resolver.
+type ResolverFunc = state.ResolverFunc
+
+var (
+	// AcceptAll is a resolver that lets every change go ahead, backing up what is replaced.
+	AcceptAll Resolver = ResolverFunc(func(Conflict) (bool, error) { return true, nil })
+	// DeclineAll is a resolver that leaves every conflicting file as it is.
+	DeclineAll Resolver = ResolverFunc(func(Conflict) (bool, error) { return false, nil })
+)
+
+// Record is the result for a single link.
+type Record = report.Record
+
+// Outcome is what happened to a link.
+type Outcome = report.Outcome
+
+const (
+	OutcomeCreated   = report.OutcomeCreated
+	OutcomeUnchanged = report.OutcomeUnchanged
+	OutcomeDeclined  = report.OutcomeDeclined
+	OutcomeSkipped   = report.OutcomeSkipped
+	OutcomeFailed    = report.OutcomeFailed
+	OutcomePlanned   = report.OutcomePlanned
+
+	OutcomeInterrupted = report.OutcomeInterrupted
+)
+
+// Summary counts records by their outcome.
+type Summary = report.Summary
+
+// Result is what a call did to each link.
+type Result struct {
+	Records []Record
+	Summary Summary
+}
+
+// Action is a single planned step of placing a link.
+type Action = links.Action
+
+// ActionKind is what an action does.
+type ActionKind = links.ActionKind
+
+const (
+	ActionMkdir         = links.ActionMkdir
+	ActionCreateTarget  = links.ActionCreateTarget
+	ActionCreate        = links.ActionCreate
+	ActionReplaceLink   = links.ActionReplaceLink
+	ActionBackupReplace = links.ActionBackupReplace
+	ActionRender        = links.ActionRender
+	ActionUnchanged     = links.ActionUnchanged
+	ActionDeclined      = links.ActionDeclined
+	ActionSkip          = links.ActionSkip
+)
+
+// LinkStatus is the state of a single link of a manifest.
+type LinkStatus = manifests.LinkStatus
+
+// Errors that can be matched with errors.Is.
+var (
+	ErrInvalidManifest  = manifests.ErrInvalidManifest
+	ErrTargetMissing    = links.ErrTargetMissing
+	ErrConflictDir      = links.ErrConflictDir
+	ErrNotSymlink       = links.ErrNotSymlink
+	ErrDeclined         = links.ErrDeclined
+	ErrPlanStale        = links.ErrPlanStale
+	ErrPlanMismatch     = manifests.ErrPlanMismatch
+	ErrInterrupted      = manifests.ErrInterrupted
+	ErrOverlappingLinks = manifests.ErrOverlappingLinks
+	ErrNotManaged       = links.ErrNotManaged
+	ErrProtectedPath    = links.ErrProtectedPath
+	ErrOutsideRoots     = links.ErrOutsideRoots
+	ErrLocked           = lock.ErrLocked
+)
+
+// collector is a reporter keeping every record in memory.
+type collector struct {
+	records []Record
+}
+
+func (c *collector) Report(r Record) { c.records = append(c.records, r) }
+func (c *collector) Close() error    { return nil }
+
+// newState creates the state a call runs with, independent of any other call.
+func newState(ctx context.Context, opts Options) (*state.TrovlState, *collector) {
+	c := &collector{}
+	s := state.NewWithLogger(&state.TrovlOptions{
+		DryRun:           opts.DryRun,
+		UseRelative:      opts.UseRelative,
+		KeepGoing:        opts.KeepGoing,
+		BackupDir:        opts.BackupDir,
+		BackupCompress:   opts.BackupCompress,
+		Jobs:             opts.Jobs,
+		Force:            opts.Force,
+		DotfilesRoots:    opts.DotfilesRoots,
+		LinkRoots:        opts.LinkRoots,
+		AllowOutsideHome: opts.AllowOutsideHome,
+		Root:             opts.Root,
+		Home:             opts.Home,
+		StrictVars:       opts.StrictVars,
+	}, opts.Logger, c)
+	if len(s.Options.LinkRoots) == 0 {
+		s.Options.LinkRoots = links.DefaultLinkRoots(s.Options.PathEnv())
+	}
+
+	// the record of placed symlinks is shared with the command line, so either can replace the
+	// links the other placed
+	if path, err := ledger.Path(); err == nil {
+		if s.Ledger, err = ledger.Load(path); err != nil {
+			s.Logger.Warn("Could not read record of placed symlinks", "error", err, "path", path)
+		}
+	}
+
+	s.Resolver = opts.Resolver
+	if s.Resolver == nil {
+		s.Resolver = DeclineAll
+	}
+	s.Context = ctx
+	return s, c
+}
+
+// saveLedger writes the record of placed symlinks after links were applied, unless nothing changed.
+func saveLedger(s *state.TrovlState) error {
+	if s.Options.DryRun || s.Ledger == nil {
+		return nil
+	}
+	path, err := ledger.Path()
+	if err != nil {
+		return err
+	}
+	return s.Ledger.Save(path)
+}
+
+func result(s *state.TrovlState, c *collector) *Result {
+	return &Result{Records: c.records, Summary: s.Summary}
+}
+
+// Manifest is a validated manifest.
+type Manifest struct {
+	m *manifests.Manifest
+}
+
+// LoadManifest reads and validates the manifest at path.
+func LoadManifest(path string) (*Manifest, error) {
+	m, err := manifests.New(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Manifest{m: m}, nil
+}
+
+// ParseManifest reads and validates a manifest from its JSON contents.
+func ParseManifest(data []byte) (*Manifest, error) {
+	m, err := manifests.Parse(data, "")
+	if err != nil {
+		return nil, err
+	}
+	return &Manifest{m: m}, nil
+}
+
+// Path returns the file the manifest was loaded from, empty if it was parsed from memory.
+func (m *Manifest) Path() string {
+	return m.m.Path
+}
+
+// Validate checks that every link of the manifest follows the schema. Overlapping links are checked
+// by CheckOverlaps, as they depend on how paths expand with the Options used.
+func (m *Manifest) Validate() error {
+	if err := m.m.Validate(); err != nil {
+		return errors.Join(ErrInvalidManifest, err)
+	}
+	return nil
+}
+
+// CheckOverlaps checks that the links of the manifests do not overlap once their paths are expanded
+// with opts, e.g. the same link path declared twice or a link inside another link to a directory.
+// NewPlan and Apply check this too before changing anything.
+func CheckOverlaps(ctx context.Context, opts Options, ms ...*Manifest) error {
+	s, _ := newState(ctx, opts)
+	if err := manifests.CheckOverlaps(s, inner(ms)...); err != nil {
+		return errors.Join(ErrInvalidManifest, err)
+	}
+	return nil
+}
+
+// inner returns the internal manifests of ms.
+func inner(ms []*Manifest) []*manifests.Manifest {
+	inner := make([]*manifests.Manifest, len(ms))
+	for i, m := range ms {
+		inner[i] = m.m
+	}
+	return inner
+}
+
+// Plan is the ordered list of actions applying manifests involves.
+type Plan struct {
+	p *manifests.Plan
+}
+
+// NewPlan computes the actions applying the manifests involves, without modifying anything.
+// With KeepGoing, links that cannot be planned are left out and their errors joined.
+func NewPlan(ctx context.Context, opts Options, ms ...*Manifest) (*Plan, error) {
+	s, _ := newState(ctx, opts)
+	p, err := plan(s, ms)
+	if err != nil && (p == nil || !opts.KeepGoing) {
+		return nil, err
+	}
+	return &Plan{p: p}, err
+}
+
+// plan plans the manifests together, after checking their links do not overlap each other.
+func plan(s *state.TrovlState, ms []*Manifest) (*manifests.Plan, error) {
+	if err := manifests.CheckOverlaps(s, inner(ms)...); err != nil {
+		return nil, errors.Join(ErrInvalidManifest, err)
+	}
+	manifests.ProtectRoots(s, inner(ms)...)
+
+	p := manifests.NewPlan()
+	var errs []error
+	for _, m := range ms {
+		if err := m.m.Plan(s, p); err != nil {
+			if !s.Options.KeepGoing || s.Err() != nil {
+				return nil, err
+			}
+			errs = append(errs, err)
+		}
+	}
+	return p, errors.Join(errs...)
+}
+
+// LoadPlan reads a plan previously written by Plan.Save.
+func LoadPlan(path string) (*Plan, error) {
+	p, err := manifests.LoadPlan(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Plan{p: p}, nil
+}
+
+// Actions returns the actions of the plan, in the order they are applied.
+func (p *Plan) Actions() []Action {
+	return p.p.Actions
+}
+
+// Save writes the plan as JSON to path.
+func (p *Plan) Save(path string) error {
+	return p.p.Save(path)
+}
+
+// WriteText writes a human-readable summary of the plan to w.
+func (p *Plan) WriteText(w io.Writer) {
+	p.p.Render(w)
+}
+
+// acquireLock takes the lock the command line holds while modifying links, so a call does not
+// interleave with a trovl process. Nothing is locked in dry-runs, where the lock returned is nil.
+func acquireLock(ctx context.Context, opts Options) (*lock.Lock, error) {
+	if opts.DryRun {
+		return nil, nil
+	}
+	path, err := lock.Path()
+	if err != nil {
+		return nil, err
+	}
+	return lock.Acquire(ctx, path, opts.WaitForLock)
+}
+
+// Apply executes the plan, as long as nothing it acts on has changed since it was computed.
+func (p *Plan) Apply(ctx context.Context, opts Options) (res *Result, err error) {
+	l, err := acquireLock(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = errors.Join(err, l.Release()) }()
+
+	s, c := newState(ctx, opts)
+	p.p.Adopt(s.Options)
+	s.ResetFS()
+	if err := p.p.Verify(s); err != nil {
+		return nil, err
+	}
+	err = errors.Join(p.p.Execute(s), saveLedger(s))
+	return result(s, c), err
+}
+
+// Apply plans and applies the manifests, returning what was done to each link.
+func Apply(ctx context.Context, opts Options, ms ...*Manifest) (res *Result, err error) {
+	l, err := acquireLock(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = errors.Join(err, l.Release()) }()
+
+	s, c := newState(ctx, opts)
+	p, planErr := plan(s, ms)
+	if p == nil {
+		return result(s, c), planErr
+	}
+	err = errors.Join(planErr, p.Execute(s), saveLedger(s))
+	return result(s, c), err
+}
+
+// Status reports the state of every link of the manifests that applies to the current platform,
+// without modifying anything.
+func Status(ctx context.Context, opts Options, ms ...*Manifest) ([]LinkStatus, error) {
+	s, _ := newState(ctx, opts)
+	var statuses []LinkStatus
+	for _, m := range ms {
+		st, err := m.m.Status(s)
+		statuses = append(statuses, st...)
+		if err != nil {
+			return statuses, err
+		}
+	}
+	return statuses, nil
+}
@@ -0,0 +1,253 @@
+package trovl_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sneha-afk/trovl/internal/lock"
+	"github.com/sneha-afk/trovl/pkg/trovl"
+)
+
+// setup creates a target file and a manifest linking to it from each of linkNames, in a temporary directory.
+func setup(t *testing.T, linkNames ...string) (string, *trovl.Manifest) {
+	t.Helper()
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", tmpDir)
+	t.Setenv("XDG_STATE_HOME", tmpDir)
+	t.Setenv("HOME", tmpDir)
+	t.Setenv("USERPROFILE", tmpDir)
+
+	target := filepath.Join(tmpDir, "actual_file")
+	if err := os.WriteFile(target, []byte("content"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	var entries []string
+	for _, name := range linkNames {
+		entries = append(entries, `{"target":"`+filepath.ToSlash(target)+`","link":"`+filepath.ToSlash(filepath.Join(tmpDir, name))+`"}`)
+	}
+	m, err := trovl.ParseManifest([]byte(`{"links":[` + strings.Join(entries, ",") + `]}`))
+	if err != nil {
+		t.Fatalf("unexpected error from ParseManifest(): %v", err)
+	}
+	return tmpDir, m
+}
+
+func TestParseManifest_Invalid(t *testing.T) {
+	for _, data := range []string{`{this is not json}`, `{"links":[{"target":"a"}]}`} {
+		if _, err := trovl.ParseManifest([]byte(data)); !errors.Is(err, trovl.ErrInvalidManifest) {
+			t.Errorf("%s: expected ErrInvalidManifest, got %v", data, err)
+		}
+	}
+}
+
+func TestCheckOverlaps(t *testing.T) {
+	m, err := trovl.ParseManifest([]byte(`{"links":[{"target":"/dotfiles/a","link":"/home/me/.rc"},{"target":"/dotfiles/b","link":"/home/me/.rc"}]}`))
+	if err != nil {
+		t.Fatalf("expected overlapping links to parse, got %v", err)
+	}
+	if err := m.Validate(); err != nil {
+		t.Errorf("unexpected error from Validate(): %v", err)
+	}
+	if err := trovl.CheckOverlaps(context.Background(), trovl.Options{}, m); !errors.Is(err, trovl.ErrOverlappingLinks) {
+		t.Errorf("expected ErrOverlappingLinks, got %v", err)
+	}
+}
+
+func TestApply(t *testing.T) {
+	tmpDir, m := setup(t, "link1", filepath.Join("nested", "link2"))
+
+	res, err := trovl.Apply(context.Background(), trovl.Options{}, m)
+	if err != nil {
+		t.Fatalf("unexpected error from Apply(): %v", err)
+	}
+	if res.Summary[trovl.OutcomeCreated] != 2 || len(res.Records) != 2 {
+		t.Errorf("expected 2 links created, got %v (%+v)", res.Summary, res.Records)
+	}
+	for _, name := range []string{"link1", filepath.Join("nested", "link2")} {
+		if _, err := os.Readlink(filepath.Join(tmpDir, name)); err != nil {
+			t.Errorf("expected %s to be a symlink: %v", name, err)
+		}
+	}
+
+	statuses, err := trovl.Status(context.Background(), trovl.Options{}, m)
+	if err != nil {
+		t.Fatalf("unexpected error from Status(): %v", err)
+	}
+	for _, st := range statuses {
+		if st.Status != "linked" {
+			t.Errorf("expected %s to be linked, got %s", st.Link, st.Status)
+		}
+	}
+
+	res, err = trovl.Apply(context.Background(), trovl.Options{}, m)
+	if err != nil {
+		t.Fatalf("unexpected error from Apply(): %v", err)
+	}
+	if res.Summary[trovl.OutcomeUnchanged] != 2 {
+		t.Errorf("expected 2 links unchanged when applied again, got %v", res.Summary)
+	}
+}
+
+func TestApply_Resolver(t *testing.T) {
+	tmpDir, m := setup(t, "link")
+	linkPath := filepath.Join(tmpDir, "link")
+	if err := os.WriteFile(linkPath, []byte("mine"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	// without a resolver, conflicts are declined rather than prompting
+	res, err := trovl.Apply(context.Background(), trovl.Options{}, m)
+	if err != nil {
+		t.Fatalf("unexpected error from Apply(): %v", err)
+	}
+	if res.Summary[trovl.OutcomeDeclined] != 1 {
+		t.Errorf("expected the conflict to be declined, got %v", res.Summary)
+	}
+
+	var asked []trovl.Conflict
+	resolver := trovl.ResolverFunc(func(c trovl.Conflict) (bool, error) {
+		asked = append(asked, c)
+		return true, nil
+	})
+	res, err = trovl.Apply(context.Background(), trovl.Options{Resolver: resolver}, m)
+	if err != nil {
+		t.Fatalf("unexpected error from Apply(): %v", err)
+	}
+	if len(asked) != 1 || asked[0].Path != linkPath || asked[0].Kind != string(trovl.ActionBackupReplace) {
+		t.Errorf("expected resolver to be asked about %s once, got %+v", linkPath, asked)
+	}
+	if res.Summary[trovl.OutcomeCreated] != 1 {
+		t.Errorf("expected the link to be created, got %v", res.Summary)
+	}
+}
+
+func TestApply_Canceled(t *testing.T) {
+	tmpDir, m := setup(t, "link")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := trovl.Apply(ctx, trovl.Options{}, m); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(tmpDir, "link")); !os.IsNotExist(err) {
+		t.Errorf("expected nothing to be created once canceled")
+	}
+}
+
+func TestApply_LinkRoots(t *testing.T) {
+	tmpDir, m := setup(t, "link")
+
+	opts := trovl.Options{LinkRoots: []string{filepath.Join(tmpDir, "home")}}
+	if _, err := trovl.Apply(context.Background(), opts, m); !errors.Is(err, trovl.ErrOutsideRoots) {
+		t.Errorf("expected ErrOutsideRoots, got %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(tmpDir, "link")); !os.IsNotExist(err) {
+		t.Errorf("expected nothing to be created outside the link roots")
+	}
+
+	opts.AllowOutsideHome = true
+	if _, err := trovl.Apply(context.Background(), opts, m); err != nil {
+		t.Errorf("unexpected error with AllowOutsideHome: %v", err)
+	}
+}
+
+func TestApply_Locked(t *testing.T) {
+	tmpDir, m := setup(t, "link")
+
+	path, err := lock.Path()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := lock.Acquire(context.Background(), path, false)
+	if err != nil {
+		t.Fatalf("could not take lock: %v", err)
+	}
+	if _, err := trovl.Apply(context.Background(), trovl.Options{}, m); !errors.Is(err, trovl.ErrLocked) {
+		t.Errorf("expected ErrLocked while another process holds the lock, got %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(tmpDir, "link")); !os.IsNotExist(err) {
+		t.Errorf("expected nothing to be created while locked")
+	}
+	if _, err := trovl.Apply(context.Background(), trovl.Options{DryRun: true}, m); err != nil {
+		t.Errorf("unexpected error from a dry-run while locked: %v", err)
+	}
+
+	if err := l.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := trovl.Apply(context.Background(), trovl.Options{}, m); err != nil {
+		t.Errorf("unexpected error once the lock is released: %v", err)
+	}
+	// the lock is released again after each call
+	if _, err := trovl.Apply(context.Background(), trovl.Options{}, m); err != nil {
+		t.Errorf("unexpected error from a second call: %v", err)
+	}
+}
+
+func TestApply_Root(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", tmpDir)
+	t.Setenv("XDG_STATE_HOME", tmpDir)
+
+	root := filepath.Join(tmpDir, "image")
+	if err := os.MkdirAll(filepath.Join(root, "dotfiles"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "dotfiles", "vimrc"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := trovl.ParseManifest([]byte(`{"links":[{"target":"/dotfiles/vimrc","link":"~/.vimrc"}]}`))
+	if err != nil {
+		t.Fatalf("unexpected error from ParseManifest(): %v", err)
+	}
+
+	opts := trovl.Options{Root: root, Home: "/home/user"}
+	if _, err := trovl.Apply(context.Background(), opts, m); err != nil {
+		t.Fatalf("unexpected error from Apply(): %v", err)
+	}
+	// the link is placed inside the root, pointing where the target is once the root is mounted
+	if got, err := os.Readlink(filepath.Join(root, "home", "user", ".vimrc")); err != nil || got != "/dotfiles/vimrc" {
+		t.Errorf("link contents = %q, %v, want %q", got, err, "/dotfiles/vimrc")
+	}
+
+	statuses, err := trovl.Status(context.Background(), opts, m)
+	if err != nil || len(statuses) != 1 || statuses[0].Status != "linked" {
+		t.Errorf("Status() = %+v, %v, want the link inside the root to be linked", statuses, err)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	tmpDir, m := setup(t, "link")
+
+	p, err := trovl.NewPlan(context.Background(), trovl.Options{}, m)
+	if err != nil {
+		t.Fatalf("unexpected error from NewPlan(): %v", err)
+	}
+	if len(p.Actions()) != 1 || p.Actions()[0].Kind != trovl.ActionCreate {
+		t.Fatalf("expected a single create action, got %+v", p.Actions())
+	}
+
+	planPath := filepath.Join(tmpDir, "plan.json")
+	if err := p.Save(planPath); err != nil {
+		t.Fatalf("unexpected error from Save(): %v", err)
+	}
+	loaded, err := trovl.LoadPlan(planPath)
+	if err != nil {
+		t.Fatalf("unexpected error from LoadPlan(): %v", err)
+	}
+	if _, err := loaded.Apply(context.Background(), trovl.Options{}); err != nil {
+		t.Fatalf("unexpected error from Apply(): %v", err)
+	}
+	if _, err := os.Readlink(filepath.Join(tmpDir, "link")); err != nil {
+		t.Errorf("expected link to be created: %v", err)
+	}
+
+	if _, err := loaded.Apply(context.Background(), trovl.Options{}); !errors.Is(err, trovl.ErrPlanStale) {
+		t.Errorf("expected ErrPlanStale applying the plan again, got %v", err)
+	}
+}